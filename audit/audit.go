@@ -0,0 +1,169 @@
+// Package audit records listener requests and deploy actions in an
+// append-only JSON-lines file with size-based rotation, and reads them back
+// for the `audit` subcommand.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Kinds of audit entries.
+const (
+	KindRequest = "request"
+	KindDeploy  = "deploy"
+)
+
+// Entry is one line of the audit log.
+type Entry struct {
+	Time     time.Time `json:"time"`
+	Kind     string    `json:"kind"`
+	Remote   string    `json:"remote,omitempty"`
+	Key      string    `json:"key,omitempty"`
+	Source   string    `json:"source,omitempty"`
+	Method   string    `json:"method,omitempty"`
+	Endpoint string    `json:"endpoint,omitempty"`
+	Status   int       `json:"status,omitempty"`
+	Outcome  string    `json:"outcome"`
+	Version  string    `json:"version,omitempty"`
+	Services []string  `json:"services,omitempty"`
+	Message  string    `json:"message,omitempty"`
+	Commit   string    `json:"commit,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// Log appends entries to a file and rotates it once it exceeds MaxSize,
+// keeping MaxBackups older files named <path>.1 (newest) to <path>.N.
+type Log struct {
+	Path       string
+	MaxSize    int64
+	MaxBackups int
+
+	mu sync.Mutex
+}
+
+// New creates a Log writing to path with default rotation limits.
+func New(path string) *Log {
+	return &Log{Path: path, MaxSize: 10 << 20, MaxBackups: 5}
+}
+
+// Write appends e to the log. A zero Time is set to now. A nil Log discards
+// the entry.
+func (l *Log) Write(e Entry) error {
+	if l == nil {
+		return nil
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if fi, err := os.Stat(l.Path); err == nil && fi.Size()+int64(len(line)) > l.MaxSize {
+		if err := l.rotate(); err != nil {
+			return fmt.Errorf("rotate audit log: %w", err)
+		}
+	}
+	f, err := os.OpenFile(l.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(line)
+	return err
+}
+
+// rotate shifts the backups by one and moves the current file to <path>.1.
+// The oldest backup beyond MaxBackups is removed.
+func (l *Log) rotate() error {
+	if l.MaxBackups <= 0 {
+		return os.Remove(l.Path)
+	}
+	_ = os.Remove(l.backup(l.MaxBackups))
+	for i := l.MaxBackups - 1; i >= 1; i-- {
+		if err := os.Rename(l.backup(i), l.backup(i+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return os.Rename(l.Path, l.backup(1))
+}
+
+func (l *Log) backup(n int) string { return fmt.Sprintf("%s.%d", l.Path, n) }
+
+// Filter selects entries returned by Query. Zero fields match everything.
+type Filter struct {
+	Since   time.Time
+	Until   time.Time
+	Outcome string
+	Key     string
+	Kind    string
+}
+
+// Match reports whether e satisfies the filter.
+func (f Filter) Match(e Entry) bool {
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && e.Time.After(f.Until) {
+		return false
+	}
+	if f.Outcome != "" && e.Outcome != f.Outcome {
+		return false
+	}
+	if f.Key != "" && e.Key != f.Key {
+		return false
+	}
+	if f.Kind != "" && e.Kind != f.Kind {
+		return false
+	}
+	return true
+}
+
+// Query reads the current file and its backups and returns the matching
+// entries from oldest to newest. Malformed lines are skipped.
+func (l *Log) Query(f Filter) ([]Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var entries []Entry
+	files := make([]string, 0, l.MaxBackups+1)
+	for i := l.MaxBackups; i >= 1; i-- {
+		files = append(files, l.backup(i))
+	}
+	files = append(files, l.Path)
+	for _, path := range files {
+		file, err := os.Open(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sc := bufio.NewScanner(file)
+		sc.Buffer(make([]byte, 0, 64*1024), 1<<20)
+		for sc.Scan() {
+			var e Entry
+			if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+				continue
+			}
+			if f.Match(e) {
+				entries = append(entries, e)
+			}
+		}
+		err = sc.Err()
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
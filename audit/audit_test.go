@@ -0,0 +1,153 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestWriteRotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	base := time.Date(2025, 10, 19, 10, 0, 0, 0, time.UTC)
+	line := func(i int) Entry {
+		return Entry{Time: base.Add(time.Duration(i) * time.Minute), Kind: KindRequest, Outcome: fmt.Sprintf("entry-%02d", i)}
+	}
+	first, err := jsonSize(line(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each file holds two entries; the third one rotates it.
+	l := &Log{Path: path, MaxSize: 2 * first, MaxBackups: 2}
+	for i := range 9 {
+		if err := l.Write(line(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, p := range []string{path, path + ".1", path + ".2"} {
+		fi, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Size() > l.MaxSize {
+			t.Errorf("%s has %d bytes, over the %d byte limit", p, fi.Size(), l.MaxSize)
+		}
+		if fi.Mode().Perm() != 0600 {
+			t.Errorf("%s has mode %v", p, fi.Mode().Perm())
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("backup beyond MaxBackups kept: %v", err)
+	}
+
+	entries, err := l.Query(Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Outcome)
+	}
+	// Entries 0 to 3 were rotated out; the rest come back oldest first.
+	want := []string{"entry-04", "entry-05", "entry-06", "entry-07", "entry-08"}
+	if !slices.Equal(got, want) {
+		t.Errorf("entries = %v, want %v", got, want)
+	}
+}
+
+func TestWriteWithoutBackups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	e := Entry{Time: time.Now().UTC(), Kind: KindDeploy, Outcome: "started"}
+	size, err := jsonSize(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &Log{Path: path, MaxSize: size, MaxBackups: 0}
+	for range 3 {
+		if err := l.Write(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := l.Query(Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("%d entries, want only the last one", len(entries))
+	}
+	if _, err := os.Stat(path + ".1"); !os.IsNotExist(err) {
+		t.Errorf("backup written with MaxBackups 0: %v", err)
+	}
+}
+
+func TestQueryFilter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l := New(path)
+	base := time.Date(2025, 10, 19, 10, 0, 0, 0, time.UTC)
+	for _, e := range []Entry{
+		{Time: base, Kind: KindRequest, Key: "ci", Status: 200, Outcome: "ok"},
+		{Time: base.Add(time.Hour), Kind: KindRequest, Key: "ops", Status: 403, Outcome: "denied"},
+		{Time: base.Add(2 * time.Hour), Kind: KindDeploy, Key: "ci", Outcome: "failed"},
+		{Time: base.Add(3 * time.Hour), Kind: KindDeploy, Key: "ci", Outcome: "succeeded"},
+	} {
+		if err := l.Write(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Malformed lines, such as a write cut short by a crash, are skipped.
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(`{"time":"2025-10-19T14:00:00Z","kind":"dep` + "\n"); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []string
+	}{
+		{"all", Filter{}, []string{"ok", "denied", "failed", "succeeded"}},
+		{"key", Filter{Key: "ci"}, []string{"ok", "failed", "succeeded"}},
+		{"outcome", Filter{Outcome: "denied"}, []string{"denied"}},
+		{"kind and key", Filter{Kind: KindDeploy, Key: "ci"}, []string{"failed", "succeeded"}},
+		{"since", Filter{Since: base.Add(2 * time.Hour)}, []string{"failed", "succeeded"}},
+		{"until", Filter{Until: base.Add(time.Hour)}, []string{"ok", "denied"}},
+		{"window", Filter{Since: base.Add(30 * time.Minute), Until: base.Add(150 * time.Minute)}, []string{"denied", "failed"}},
+		{"no match", Filter{Key: "github"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := l.Query(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, e := range entries {
+				got = append(got, e.Outcome)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("outcomes = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNilLogDiscards(t *testing.T) {
+	var l *Log
+	if err := l.Write(Entry{Kind: KindRequest}); err != nil {
+		t.Errorf("Write on a nil Log: %v", err)
+	}
+}
+
+// jsonSize returns the size of e as a log line.
+func jsonSize(e Entry) (int64, error) {
+	line, err := json.Marshal(e)
+	return int64(len(line)) + 1, err
+}
@@ -0,0 +1,99 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/plark-inc/hostship/config"
+	"github.com/spf13/cobra"
+)
+
+// Command constructs the `audit` subcommand which queries the audit log.
+func Command() *cobra.Command {
+	var since, until string
+	var f Filter
+	var asJSON bool
+	cmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Query the listener audit log",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var err error
+			if f.Since, err = parseTime(since); err != nil {
+				return err
+			}
+			if f.Until, err = parseTime(until); err != nil {
+				return err
+			}
+			return runAudit(f, asJSON)
+		},
+	}
+	cmd.Flags().StringVar(&since, "since", "", "only entries after this time (duration like 24h or RFC 3339)")
+	cmd.Flags().StringVar(&until, "until", "", "only entries before this time (duration like 1h or RFC 3339)")
+	cmd.Flags().StringVar(&f.Outcome, "outcome", "", "only entries with this outcome (e.g. denied, succeeded, failed)")
+	cmd.Flags().StringVar(&f.Key, "key", "", "only entries for this key name")
+	cmd.Flags().StringVar(&f.Kind, "kind", "", "only entries of this kind (request or deploy)")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print entries as JSON lines")
+	return cmd
+}
+
+func runAudit(f Filter, asJSON bool) error {
+	entries, err := New(config.AuditPath).Query(f)
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		for _, e := range entries {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tKIND\tREMOTE\tKEY\tENDPOINT\tOUTCOME\tVERSION\tDETAIL")
+	for _, e := range entries {
+		endpoint := strings.TrimSpace(e.Method + " " + e.Endpoint)
+		detail := e.Error
+		if detail == "" && len(e.Services) > 0 {
+			detail = strings.Join(e.Services, ",")
+		}
+		if e.Commit != "" {
+			detail = strings.TrimSpace(fmt.Sprintf("%s @%.7s", detail, e.Commit))
+		}
+		if e.Message != "" {
+			detail = strings.TrimSpace(fmt.Sprintf("%s %q", detail, e.Message))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", e.Time.Local().Format(time.DateTime),
+			e.Kind, dash(e.Remote), dash(e.Key), dash(endpoint), e.Outcome, dash(e.Version), detail)
+	}
+	return tw.Flush()
+}
+
+// parseTime accepts an empty string, a duration before now or an RFC 3339
+// timestamp.
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: use a duration like 24h or an RFC 3339 time", s)
+	}
+	return t, nil
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// DefaultListenAddr is the address of the hot-reload listener unless
+// overridden with --listen-addr or HOSTSHIP_LISTEN_ADDR.
+const DefaultListenAddr = ":8080"
+
+// ListenerURL returns the base URL of the hot-reload listener on the local
+// host. The address comes from HOSTSHIP_LISTEN_ADDR like the listener's own;
+// a wildcard host is reached on loopback, and https is used when .env
+// configures a certificate.
+func ListenerURL() string {
+	LoadEnv()
+	addr := os.Getenv("HOSTSHIP_LISTEN_ADDR")
+	if addr == "" {
+		addr = DefaultListenAddr
+	}
+	scheme := "http"
+	if os.Getenv("HOSTSHIP_TLS_CERT") != "" {
+		scheme = "https"
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return scheme + "://" + addr
+	}
+	if ip := net.ParseIP(host); host == "" || ip.IsUnspecified() {
+		host = "127.0.0.1"
+		if ip != nil && ip.To4() == nil {
+			host = "::1"
+		}
+	}
+	return scheme + "://" + net.JoinHostPort(host, port)
+}
+
+// ListenerClient returns an HTTP client for the hot-reload listener. When the
+// listener serves TLS with the certificate from .env, that certificate is
+// trusted in addition to the system roots so self-signed pairs generated by
+// setup verify. A zero timeout suits streaming responses.
+func ListenerClient(timeout time.Duration) (*http.Client, error) {
+	client := &http.Client{Timeout: timeout}
+	LoadEnv()
+	certFile := os.Getenv("HOSTSHIP_TLS_CERT")
+	if certFile == "" {
+		return client, nil
+	}
+	pem, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", certFile)
+	}
+	client.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}
+	return client, nil
+}
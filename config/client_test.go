@@ -0,0 +1,24 @@
+package config
+
+import "testing"
+
+func TestListenerURL(t *testing.T) {
+	t.Chdir(t.TempDir())
+	tests := []struct {
+		addr, cert, want string
+	}{
+		{"", "", "http://127.0.0.1:8080"},
+		{":9090", "", "http://127.0.0.1:9090"},
+		{"0.0.0.0:9090", "tls/cert.pem", "https://127.0.0.1:9090"},
+		{"[::]:9090", "", "http://[::1]:9090"},
+		{"10.0.0.5:8443", "", "http://10.0.0.5:8443"},
+		{"localhost:9090", "", "http://localhost:9090"},
+	}
+	for _, tt := range tests {
+		t.Setenv("HOSTSHIP_LISTEN_ADDR", tt.addr)
+		t.Setenv("HOSTSHIP_TLS_CERT", tt.cert)
+		if got := ListenerURL(); got != tt.want {
+			t.Errorf("ListenerURL() with %q = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package config
+
+import (
+	"os"
+	"strings"
+	"sync"
+)
+
+// EnvPath is the location of the environment file written by setup.
+const EnvPath = ".env"
+
+// fromFile records the variables LoadEnv took from the environment file, as
+// opposed to those set by the process environment, so ReloadEnv knows which
+// ones it may replace.
+var (
+	fromFileMu sync.Mutex
+	fromFile   = make(map[string]bool)
+)
+
+// LoadEnv reads KEY=VALUE pairs from the environment file into the process
+// environment. Variables that are already set take precedence.
+func LoadEnv() {
+	fromFileMu.Lock()
+	defer fromFileMu.Unlock()
+	for key, value := range readEnv() {
+		if _, ok := os.LookupEnv(key); !ok {
+			_ = os.Setenv(key, value)
+			fromFile[key] = true
+		}
+	}
+}
+
+// ReloadEnv reads the environment file again. Variables previously loaded
+// from the file take their new value or are unset when they were removed;
+// variables from the process environment still take precedence.
+func ReloadEnv() {
+	fromFileMu.Lock()
+	defer fromFileMu.Unlock()
+	vars := readEnv()
+	for key := range fromFile {
+		if _, ok := vars[key]; !ok {
+			_ = os.Unsetenv(key)
+			delete(fromFile, key)
+		}
+	}
+	for key, value := range vars {
+		if _, ok := os.LookupEnv(key); !ok || fromFile[key] {
+			_ = os.Setenv(key, value)
+			fromFile[key] = true
+		}
+	}
+}
+
+// readEnv parses the environment file. A missing file yields no variables.
+func readEnv() map[string]string {
+	vars := make(map[string]string)
+	data, err := os.ReadFile(EnvPath)
+	if err != nil {
+		return vars
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		vars[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return vars
+}
+
+// SetEnv sets key to value in the environment file, replacing an existing
+// assignment or appending a new one. The file is created when missing.
+func SetEnv(key, value string) error {
+	data, err := os.ReadFile(EnvPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var lines []string
+	if len(data) > 0 {
+		lines = strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	}
+	found := false
+	for i, line := range lines {
+		k, _, ok := strings.Cut(line, "=")
+		if ok && strings.TrimSpace(k) == key {
+			lines[i] = key + "=" + value
+			found = true
+		}
+	}
+	if !found {
+		lines = append(lines, key+"="+value)
+	}
+	if err := os.WriteFile(EnvPath, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		return err
+	}
+	fromFileMu.Lock()
+	defer fromFileMu.Unlock()
+	fromFile[key] = true
+	return os.Setenv(key, value)
+}
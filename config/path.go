@@ -2,3 +2,25 @@ package config
 
 // Path is the default location of the compose configuration file.
 const Path = "compose.json"
+
+// PreviousPath keeps the compose file replaced by the last deploy so it can
+// be rolled back to.
+const PreviousPath = "compose.previous.json"
+
+// KeysPath is the location of the hashed deploy keys.
+const KeysPath = "keys.json"
+
+// DeployKeyPath holds the secret of the default deploy key, readable only
+// by its owner, after `setup --key-file` so .env and DEPLOY_URL do not
+// contain it. Compose services that trigger deploys read it as a secret.
+const DeployKeyPath = "deploy.key"
+
+// AuditPath is the location of the listener's audit log.
+const AuditPath = "audit.log"
+
+// InflightPath records the deploys in progress so a restart can report and
+// resume the ones that were interrupted.
+const InflightPath = "inflight.json"
+
+// LogArchiveDir holds the container logs archived before deploys.
+const LogArchiveDir = "logs"
@@ -0,0 +1,280 @@
+// Package deploy implements the `deploy` subcommand which triggers an update
+// through the hot-reload listener, optionally following its progress.
+package deploy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/plark-inc/hostship/config"
+	"github.com/plark-inc/hostship/keys"
+	"github.com/spf13/cobra"
+)
+
+// options are the flags of the deploy subcommand.
+type options struct {
+	url        string
+	services   []string
+	dryRun     bool
+	version    string
+	composeURL string
+	images     map[string]string
+	message    string
+	commit     string
+	follow     bool
+}
+
+// Command constructs the `deploy` subcommand.
+func Command() *cobra.Command {
+	var opts options
+	cmd := &cobra.Command{
+		Use:   "deploy",
+		Short: "Trigger an update through the hot-reload listener",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runDeploy(opts)
+		},
+	}
+	cmd.Flags().StringVar(&opts.url, "url", "", "update URL with the key, or ending in /update to read deploy.key (default DEPLOY_URL)")
+	cmd.Flags().StringSliceVar(&opts.services, "service", nil, "only update these services")
+	cmd.Flags().BoolVar(&opts.dryRun, "dry-run", false, "only show what would change")
+	cmd.Flags().StringVar(&opts.version, "version", "", "require this x-metadata.version")
+	cmd.Flags().StringVar(&opts.composeURL, "compose-url", "", "deploy this compose file instead of x-metadata.url")
+	cmd.Flags().StringToStringVar(&opts.images, "image", nil, "override the image tag of a service (service=tag)")
+	cmd.Flags().StringVar(&opts.message, "message", "", "message recorded with the deploy")
+	cmd.Flags().StringVar(&opts.commit, "commit", "", "commit SHA recorded with the deploy")
+	cmd.Flags().BoolVarP(&opts.follow, "follow", "f", false, "stream deploy progress until it finishes")
+	cmd.Flags().BoolP("verbose", "v", false, "verbose output (same as --log-level debug)")
+	return cmd
+}
+
+// result is the response of the update endpoint.
+type result struct {
+	Status   string          `json:"status"`
+	Services []string        `json:"services"`
+	Version  string          `json:"version"`
+	Diff     json.RawMessage `json:"diff"`
+	Job      string          `json:"job"`
+	Error    string          `json:"error"`
+}
+
+func runDeploy(opts options) error {
+	client, err := config.ListenerClient(0)
+	if err != nil {
+		return err
+	}
+	raw := opts.url
+	if raw == "" {
+		raw = os.Getenv("DEPLOY_URL")
+	}
+	if raw == "" {
+		return fmt.Errorf("DEPLOY_URL not set; pass --url")
+	}
+	key, err := keys.DeployKey(raw)
+	if err != nil {
+		return fmt.Errorf("invalid update URL: %w", err)
+	}
+	if raw, err = keys.UpdateURL(raw, key); err != nil {
+		return err
+	}
+	body, err := json.Marshal(map[string]any{
+		"services":    opts.services,
+		"dry_run":     opts.dryRun,
+		"version":     opts.version,
+		"compose_url": opts.composeURL,
+		"images":      opts.images,
+		"message":     opts.message,
+		"commit":      opts.commit,
+		"async":       opts.follow && !opts.dryRun,
+	})
+	if err != nil {
+		return err
+	}
+	slog.Debug("request", "method", "POST", "url", redact(raw))
+	resp, err := client.Post(raw, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var res result
+	if err := json.Unmarshal(data, &res); err != nil {
+		// Some failures are reported as plain text.
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("%s: %s", resp.Status, firstNonEmpty(res.Error, res.Status))
+	}
+	fmt.Printf("%s", res.Status)
+	if res.Version != "" {
+		fmt.Printf(" version %s", res.Version)
+	}
+	if len(res.Services) > 0 {
+		fmt.Printf(": %s", strings.Join(res.Services, ", "))
+	}
+	fmt.Println()
+	if opts.dryRun && len(res.Diff) > 0 {
+		var out bytes.Buffer
+		if json.Indent(&out, res.Diff, "", "  ") == nil {
+			fmt.Println(out.String())
+		}
+	}
+	if !opts.follow || res.Job == "" {
+		return nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	events := fmt.Sprintf("%s://%s/jobs/%s/events", u.Scheme, u.Host, res.Job)
+	return follow(client, events, key)
+}
+
+// event is a progress event of a deploy job.
+type event struct {
+	Seq       int    `json:"seq"`
+	Type      string `json:"type"`
+	Phase     string `json:"phase"`
+	Service   string `json:"service"`
+	Layer     string `json:"layer"`
+	Container string `json:"container"`
+	Status    string `json:"status"`
+	Progress  string `json:"progress"`
+	Message   string `json:"message"`
+	Error     string `json:"error"`
+}
+
+// follow reads the server-sent events of a job and prints them until the
+// done event, reconnecting from the last event when the stream drops.
+func follow(client *http.Client, eventsURL, key string) error {
+	p := &printer{layers: make(map[string]string)}
+	last := 0
+	for attempt := 0; ; attempt++ {
+		done, err := stream(client, eventsURL, key, last, func(e event) {
+			last = e.Seq
+			p.print(e)
+		})
+		if done != nil {
+			if done.Status == "succeeded" || done.Status == "unchanged" {
+				return nil
+			}
+			return fmt.Errorf("deploy %s: %s", done.Status, done.Error)
+		}
+		if attempt == 3 {
+			return fmt.Errorf("event stream: %w", err)
+		}
+		slog.Debug("event stream interrupted, reconnecting", "err", err)
+		time.Sleep(time.Duration(attempt+1) * time.Second)
+	}
+}
+
+// stream consumes one connection to the event stream. It returns the done
+// event once received, or the error that ended the stream early.
+func stream(client *http.Client, eventsURL, key string, last int, fn func(event)) (*event, error) {
+	req, err := http.NewRequest(http.MethodGet, eventsURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Authorization", "Bearer "+key)
+	if last > 0 {
+		req.Header.Set("Last-Event-ID", strconv.Itoa(last))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	sc := bufio.NewScanner(resp.Body)
+	sc.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	var data strings.Builder
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case strings.HasPrefix(line, "data:"):
+			data.WriteString(strings.TrimSpace(strings.TrimPrefix(line, "data:")))
+		case line == "" && data.Len() > 0:
+			var e event
+			if err := json.Unmarshal([]byte(data.String()), &e); err == nil {
+				fn(e)
+				if e.Type == "done" {
+					return &e, nil
+				}
+			}
+			data.Reset()
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.ErrUnexpectedEOF
+}
+
+// printer renders events as log lines. Layer progress is only printed when
+// the status of a layer changes so redrawn progress bars do not flood CI
+// logs.
+type printer struct {
+	layers map[string]string
+}
+
+func (p *printer) print(e event) {
+	switch e.Type {
+	case "phase":
+		fmt.Printf("==> %s\n", e.Phase)
+	case "pull":
+		if e.Layer != "" {
+			if p.layers[e.Layer] == e.Status {
+				return
+			}
+			p.layers[e.Layer] = e.Status
+			fmt.Printf("    %s %s %s\n", e.Layer, e.Status, e.Progress)
+			return
+		}
+		fmt.Printf("%s: %s\n", e.Service, e.Status)
+	case "container":
+		fmt.Printf("%s: %s\n", e.Container, e.Status)
+	case "health":
+		fmt.Printf("%s: %s\n", firstNonEmpty(e.Service, e.Container), e.Status)
+	case "hook", "log":
+		fmt.Println(e.Message)
+	case "done":
+		if e.Error != "" {
+			fmt.Printf("deploy %s: %s\n", e.Status, e.Error)
+		} else {
+			fmt.Printf("deploy %s\n", e.Status)
+		}
+	}
+}
+
+// redact hides the key at the end of an update URL.
+func redact(raw string) string {
+	if i := strings.LastIndex(raw, "/"); i >= 0 {
+		return raw[:i+1] + "***"
+	}
+	return raw
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,64 @@
+// Package diff implements the `diff` subcommand which previews the changes an
+// update would apply to the local compose file.
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/plark-inc/hostship/config"
+	"github.com/plark-inc/hostship/docker"
+	"github.com/spf13/cobra"
+)
+
+// Command constructs the `diff` subcommand. Without an argument the compose
+// file is fetched from the x-metadata.url of the local configuration.
+func Command() *cobra.Command {
+	var asJSON bool
+	cmd := &cobra.Command{
+		Use:   "diff [compose_url]",
+		Short: "Show what an update would change in the compose file",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			url := ""
+			if len(args) == 1 {
+				url = args[0]
+			}
+			return runDiff(url, asJSON)
+		},
+	}
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print the diff as JSON")
+	cmd.Flags().BoolP("verbose", "v", false, "verbose output (same as --log-level debug)")
+	return cmd
+}
+
+func runDiff(url string, asJSON bool) error {
+	cfg, err := docker.Load(config.Path)
+	if err != nil {
+		return err
+	}
+	if url == "" {
+		url = docker.GetString(cfg, "x-metadata.url")
+		if url == "" {
+			return fmt.Errorf("missing x-metadata.url")
+		}
+	}
+	slog.Debug("fetching compose file", "url", url)
+	data, err := docker.Fetch(url, nil)
+	if err != nil {
+		return err
+	}
+	d, err := docker.DiffCompose(cfg, data)
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(d)
+	}
+	d.Print(os.Stdout)
+	return nil
+}
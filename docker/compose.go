@@ -3,15 +3,46 @@
 package docker
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 	"os"
 	"os/exec"
+	"strings"
 )
 
-type ComposeClient struct{ Runner }
+type ComposeClient struct {
+	Runner
+	removeOrphans bool
+}
+
+func NewComposeClient(dryRun bool) *ComposeClient {
+	return &ComposeClient{Runner: NewRunner(dryRun)}
+}
+
+// WithProgress returns a copy of the client that passes the output of every
+// command to fn line by line.
+func (c *ComposeClient) WithProgress(fn func(line string)) *ComposeClient {
+	cp := *c
+	cp.Progress = fn
+	return &cp
+}
 
-func NewComposeClient(dryRun, verbose bool) *ComposeClient {
-	return &ComposeClient{Runner: NewRunner(dryRun, verbose)}
+// RemovingOrphans returns a copy of the client whose Up and UpNoDeps also
+// remove the containers of services no longer defined in the compose file.
+func (c *ComposeClient) RemovingOrphans() *ComposeClient {
+	cp := *c
+	cp.removeOrphans = true
+	return &cp
+}
+
+// upArgs returns the arguments of `docker compose up` in the background.
+func (c *ComposeClient) upArgs(file, project string) []string {
+	args := []string{"compose", "-f", file, "--project-name", project, "up", "-d"}
+	if c.removeOrphans {
+		args = append(args, "--remove-orphans")
+	}
+	return args
 }
 
 func (c *ComposeClient) Pull(file, project string, services ...string) (string, error) {
@@ -23,26 +54,81 @@ func (c *ComposeClient) Pull(file, project string, services ...string) (string,
 }
 
 func (c *ComposeClient) Up(file, project string, services ...string) error {
-	args := []string{"compose", "-f", file, "--project-name", project, "up", "-d"}
+	args := append(c.upArgs(file, project), services...)
+	cmd := exec.Command("docker", args...)
+	cmd.Env = os.Environ()
+	return c.Run(cmd)
+}
+
+// UpNoDeps starts services without starting the services they depend on.
+// It is used to bring up a single service under an alternate project name.
+func (c *ComposeClient) UpNoDeps(file, project string, services ...string) error {
+	args := append(c.upArgs(file, project), "--no-deps")
+	args = append(args, services...)
+	cmd := exec.Command("docker", args...)
+	cmd.Env = os.Environ()
+	return c.Run(cmd)
+}
+
+// Ps returns the IDs of the running containers of service in project.
+func (c *ComposeClient) Ps(file, project, service string) ([]string, error) {
+	cmd := exec.Command("docker", "compose", "-f", file, "--project-name", project, "ps", "-q", service)
+	cmd.Env = os.Environ()
+	out, err := c.Output(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(out), nil
+}
+
+// Remove stops and removes the containers of the given services.
+func (c *ComposeClient) Remove(file, project string, services ...string) error {
+	args := []string{"compose", "-f", file, "--project-name", project, "rm", "-s", "-f"}
 	args = append(args, services...)
 	cmd := exec.Command("docker", args...)
 	cmd.Env = os.Environ()
 	return c.Run(cmd)
 }
 
-func EnsureComposeInstalled(dryRun, verbose bool) error {
-	if err := checkCompose(dryRun, verbose); err == nil {
+// Down stops and removes all containers and networks of project.
+func (c *ComposeClient) Down(file, project string) error {
+	cmd := exec.Command("docker", "compose", "-f", file, "--project-name", project, "down")
+	cmd.Env = os.Environ()
+	return c.Run(cmd)
+}
+
+// Exec runs a command inside the running container of service.
+func (c *ComposeClient) Exec(file, project, service string, command ...string) (string, error) {
+	args := []string{"compose", "-f", file, "--project-name", project, "exec", "-T", service}
+	args = append(args, command...)
+	cmd := exec.Command("docker", args...)
+	cmd.Env = os.Environ()
+	return c.Output(cmd)
+}
+
+// RunOneOff executes a one-off command in a new container for service and removes
+// the container afterwards. The command is killed when ctx is done.
+func (c *ComposeClient) RunOneOff(ctx context.Context, file, project, service string, command ...string) (string, error) {
+	args := []string{"compose", "-f", file, "--project-name", project, "run", "--rm", "-T", service}
+	args = append(args, command...)
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd.Env = os.Environ()
+	return c.Output(cmd)
+}
+
+func EnsureComposeInstalled(dryRun bool) error {
+	if err := checkCompose(dryRun); err == nil {
 		return nil
 	}
-	fmt.Println("docker compose not found, installing via convenience script")
+	slog.Info("docker compose not found, installing via convenience script")
 
-	if err := EnsureInstalled(dryRun, verbose); err != nil {
+	if err := EnsureInstalled(dryRun); err != nil {
 		return err
 	}
-	return checkCompose(dryRun, verbose)
+	return checkCompose(dryRun)
 }
 
-func checkCompose(dryRun, verbose bool) error {
+func checkCompose(dryRun bool) error {
 	cmd := exec.Command("docker", "compose", "version")
 	if dryRun {
 		fmt.Println(cmd.String())
@@ -0,0 +1,133 @@
+package docker
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// Inspect returns the result of applying the Go template format to the
+// container's inspect output.
+func (r Runner) Inspect(container, format string) (string, error) {
+	return r.Output(exec.Command("docker", "inspect", "-f", format, container))
+}
+
+// ContainerName returns the name of the container without the leading slash.
+func (r Runner) ContainerName(container string) (string, error) {
+	name, err := r.Inspect(container, "{{.Name}}")
+	return strings.TrimPrefix(name, "/"), err
+}
+
+// HealthStatus returns the health status of the container when it defines a
+// healthcheck and its state (e.g. "running", "exited") otherwise.
+func (r Runner) HealthStatus(container string) (string, error) {
+	return r.Inspect(container, "{{if .State.Health}}{{.State.Health.Status}}{{else}}{{.State.Status}}{{end}}")
+}
+
+// NetworkConnect attaches the container to network, reachable under alias.
+func (r Runner) NetworkConnect(network, alias, container string) error {
+	return r.Run(exec.Command("docker", "network", "connect", "--alias", alias, network, container))
+}
+
+// ContainerState describes a container of a compose project as reported by
+// `docker compose ps`.
+type ContainerState struct {
+	ID      string
+	Name    string
+	Project string
+	Service string
+	// Number is the index of the container among the replicas of a scaled
+	// service, starting at 1.
+	Number int
+	State  string
+	Health string
+}
+
+// States lists every container of project, including stopped ones.
+func (c *ComposeClient) States(file, project string) ([]ContainerState, error) {
+	cmd := exec.Command("docker", "compose", "-f", file, "--project-name", project, "ps", "-a", "--format", "json")
+	cmd.Env = os.Environ()
+	out, err := c.Output(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return parseStates(out), nil
+}
+
+// parseStates accepts both the JSON array printed by older compose releases
+// and the one object per line printed by newer ones.
+func parseStates(out string) []ContainerState {
+	var states []ContainerState
+	add := func(r gjson.Result) {
+		states = append(states, ContainerState{
+			ID:      r.Get("ID").String(),
+			Name:    r.Get("Name").String(),
+			Project: r.Get("Project").String(),
+			Service: r.Get("Service").String(),
+			State:   r.Get("State").String(),
+			Health:  r.Get("Health").String(),
+		})
+	}
+	out = strings.TrimSpace(out)
+	if strings.HasPrefix(out, "[") {
+		for _, r := range gjson.Parse(out).Array() {
+			add(r)
+		}
+		return states
+	}
+	for _, line := range strings.Split(out, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			add(gjson.Parse(line))
+		}
+	}
+	return states
+}
+
+// Compose labels identifying the containers of a project.
+const (
+	projectLabel = "com.docker.compose.project"
+	serviceLabel = "com.docker.compose.service"
+	numberLabel  = "com.docker.compose.container-number"
+)
+
+// ColorProjects returns project followed by the blue/green projects
+// "<project>-<service>-blue" and "<project>-<service>-green" of services.
+func ColorProjects(project string, services []string) []string {
+	projects := []string{project}
+	for _, s := range services {
+		projects = append(projects, project+"-"+s+"-blue", project+"-"+s+"-green")
+	}
+	return projects
+}
+
+// ProjectContainers lists the containers of projects, including stopped
+// ones. They are found by their compose project label, which must equal one
+// of projects, so scaled services and custom container names need no naming
+// conventions and unrelated projects sharing a prefix are left out.
+func (r Runner) ProjectContainers(projects ...string) ([]ContainerState, error) {
+	format := fmt.Sprintf(`{{.ID}}\t{{.Names}}\t{{.Label %q}}\t{{.Label %q}}\t{{.Label %q}}\t{{.State}}`, projectLabel, serviceLabel, numberLabel)
+	out, err := r.Output(exec.Command("docker", "ps", "-a", "--filter", "label="+projectLabel, "--format", format))
+	if err != nil {
+		return nil, err
+	}
+	var states []ContainerState
+	for _, line := range strings.Split(out, "\n") {
+		f := strings.Split(line, "\t")
+		if len(f) != 6 || !slices.Contains(projects, f[2]) {
+			continue
+		}
+		n, _ := strconv.Atoi(f[4])
+		states = append(states, ContainerState{ID: f[0], Name: f[1], Project: f[2], Service: f[3], Number: n, State: f[5]})
+	}
+	return states, nil
+}
+
+// Ping checks that the Docker daemon is reachable and returns its version.
+func (r Runner) Ping() (string, error) {
+	return r.Output(exec.Command("docker", "version", "--format", "{{.Server.Version}}"))
+}
@@ -0,0 +1,43 @@
+package docker
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestProjectContainers(t *testing.T) {
+	dir := t.TempDir()
+	script := `#!/bin/sh
+printf 'a1\thostship-web-1\thostship\tweb\t1\trunning\n'
+printf 'b2\thostship-web-blue-web-1\thostship-web-blue\tweb\t1\trunning\n'
+printf 'c3\thostship-web-green-web-1\thostship-web-green\tweb\t1\texited\n'
+printf 'd4\thostship-staging-web-1\thostship-staging\tweb\t1\trunning\n'
+printf 'e5\thostship2-db-1\thostship2\tdb\t1\trunning\n'
+printf 'f6\thostship-worker-2\thostship\tworker\t2\trunning\n'
+`
+	if err := os.WriteFile(filepath.Join(dir, "docker"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	projects := ColorProjects("hostship", []string{"web", "worker"})
+	if want := []string{"hostship", "hostship-web-blue", "hostship-web-green", "hostship-worker-blue", "hostship-worker-green"}; !slices.Equal(projects, want) {
+		t.Errorf("ColorProjects = %q, want %q", projects, want)
+	}
+	states, err := NewRunner(false).ProjectContainers(projects...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, s := range states {
+		ids = append(ids, s.ID)
+	}
+	if want := []string{"a1", "b2", "c3", "f6"}; !slices.Equal(ids, want) {
+		t.Errorf("containers = %q, want %q", ids, want)
+	}
+	if w := states[3]; w.Name != "hostship-worker-2" || w.Service != "worker" || w.Number != 2 || w.State != "running" {
+		t.Errorf("worker = %+v", w)
+	}
+}
@@ -0,0 +1,345 @@
+package docker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// secretKey matches environment variable names whose values should never be
+// printed in a diff.
+var secretKey = regexp.MustCompile(`(?i)(secret|passw(or)?d|token|key|credential|private|auth|dsn|cert)`)
+
+// maskedValue replaces the value of secret-looking environment variables.
+const maskedValue = "********"
+
+// urlPattern matches URLs inside values, which may carry a password in their
+// userinfo, e.g. "postgres://app:secret@db/app".
+var urlPattern = regexp.MustCompile(`[A-Za-z][A-Za-z0-9+.-]*://[^\s"'<>]+`)
+
+// redactURLs replaces the passwords of the URLs in s.
+func redactURLs(s string) string {
+	return urlPattern.ReplaceAllStringFunc(s, func(raw string) string {
+		u, err := url.Parse(raw)
+		if err != nil || u.User == nil {
+			return raw
+		}
+		if _, ok := u.User.Password(); !ok {
+			return raw
+		}
+		return u.Redacted()
+	})
+}
+
+// Diff describes the differences between two compose files.
+type Diff struct {
+	Version   *Change        `json:"version,omitempty"`
+	Services  []ServiceDiff  `json:"services"`
+	Resources []ResourceDiff `json:"resources,omitempty"`
+}
+
+// ServiceDiff describes how a single service changed. Status is one of
+// "added", "removed" or "changed".
+type ServiceDiff struct {
+	Name        string      `json:"name"`
+	Status      string      `json:"status"`
+	Image       *Change     `json:"image,omitempty"`
+	Environment []EnvChange `json:"environment,omitempty"`
+	Ports       *ListChange `json:"ports,omitempty"`
+	Volumes     *ListChange `json:"volumes,omitempty"`
+	Other       []string    `json:"other,omitempty"`
+}
+
+// ResourceDiff describes how a top-level network, volume, config or secret
+// changed. Kind is the top-level key ("networks", "volumes", "configs" or
+// "secrets") and Status one of "added", "removed" or "changed". Only names
+// are reported since definitions may hold inline config contents.
+type ResourceDiff struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
+
+// Change holds the old and new value of a scalar setting.
+type Change struct {
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+// EnvChange holds the old and new value of an environment variable. An empty
+// Old means the variable was added, an empty New that it was removed.
+type EnvChange struct {
+	Key string `json:"key"`
+	Change
+}
+
+// ListChange holds the entries added to and removed from a list setting such
+// as ports or volumes.
+type ListChange struct {
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+}
+
+// Empty reports whether the two compose files define the same services,
+// top-level resources and version.
+func (d *Diff) Empty() bool {
+	return d.Version == nil && len(d.Services) == 0 && len(d.Resources) == 0
+}
+
+// DiffCompose computes a per-service diff between prev and next, followed by
+// the top-level resources that changed. Values of secret-looking environment
+// variables are masked and passwords of URLs in any value are redacted.
+func DiffCompose(prev, next []byte) (*Diff, error) {
+	names, err := ServiceNames(next)
+	if err != nil {
+		return nil, err
+	}
+	d := &Diff{Services: make([]ServiceDiff, 0)}
+	if o, n := GetString(prev, "x-metadata.version"), GetString(next, "x-metadata.version"); o != n {
+		d.Version = &Change{Old: redactURLs(o), New: redactURLs(n)}
+	}
+	for _, name := range names {
+		path := "services." + gjson.Escape(name)
+		old := gjson.GetBytes(prev, path)
+		cur := gjson.GetBytes(next, path)
+		if !old.Exists() {
+			sd := diffService(name, gjson.Result{}, cur)
+			sd.Status = "added"
+			d.Services = append(d.Services, sd)
+			continue
+		}
+		if sameJSON(old.Raw, cur.Raw) {
+			continue
+		}
+		d.Services = append(d.Services, diffService(name, old, cur))
+	}
+	gjson.GetBytes(prev, "services").ForEach(func(key, value gjson.Result) bool {
+		if !gjson.GetBytes(next, "services."+gjson.Escape(key.String())).Exists() {
+			sd := diffService(key.String(), value, gjson.Result{})
+			sd.Status = "removed"
+			d.Services = append(d.Services, sd)
+		}
+		return true
+	})
+	for _, kind := range sharedKeys {
+		d.Resources = append(d.Resources, diffResources(kind, gjson.GetBytes(prev, kind), gjson.GetBytes(next, kind))...)
+	}
+	return d, nil
+}
+
+// diffResources compares the entries of a top-level resource section, those
+// of next first in file order, then the ones removed from prev.
+func diffResources(kind string, old, cur gjson.Result) []ResourceDiff {
+	var diffs []ResourceDiff
+	cur.ForEach(func(key, value gjson.Result) bool {
+		prev := old.Get(gjson.Escape(key.String()))
+		switch {
+		case !prev.Exists():
+			diffs = append(diffs, ResourceDiff{Kind: kind, Name: key.String(), Status: "added"})
+		case !sameJSON(orNull(prev.Raw), orNull(value.Raw)):
+			diffs = append(diffs, ResourceDiff{Kind: kind, Name: key.String(), Status: "changed"})
+		}
+		return true
+	})
+	old.ForEach(func(key, _ gjson.Result) bool {
+		if !cur.Get(gjson.Escape(key.String())).Exists() {
+			diffs = append(diffs, ResourceDiff{Kind: kind, Name: key.String(), Status: "removed"})
+		}
+		return true
+	})
+	return diffs
+}
+
+// diffService compares a single service definition. Either side may be the
+// zero Result for added or removed services.
+func diffService(name string, old, cur gjson.Result) ServiceDiff {
+	sd := ServiceDiff{Name: name, Status: "changed"}
+	if o, n := old.Get("image").String(), cur.Get("image").String(); o != n {
+		sd.Image = &Change{Old: redactURLs(o), New: redactURLs(n)}
+	}
+	sd.Environment = diffEnv(envMap(old.Get("environment")), envMap(cur.Get("environment")))
+	sd.Ports = diffList(old.Get("ports"), cur.Get("ports"))
+	sd.Volumes = diffList(old.Get("volumes"), cur.Get("volumes"))
+
+	handled := map[string]bool{"image": true, "environment": true, "ports": true, "volumes": true}
+	keys := make(map[string]bool)
+	for _, r := range []gjson.Result{old, cur} {
+		r.ForEach(func(k, _ gjson.Result) bool {
+			keys[k.String()] = true
+			return true
+		})
+	}
+	for k := range keys {
+		if handled[k] {
+			continue
+		}
+		p := gjson.Escape(k)
+		if !sameJSON(orNull(old.Get(p).Raw), orNull(cur.Get(p).Raw)) {
+			sd.Other = append(sd.Other, k)
+		}
+	}
+	sort.Strings(sd.Other)
+	return sd
+}
+
+// envMap normalizes the map and list forms of a compose environment.
+func envMap(r gjson.Result) map[string]string {
+	env := make(map[string]string)
+	if r.IsArray() {
+		for _, e := range r.Array() {
+			k, v, _ := strings.Cut(e.String(), "=")
+			env[k] = v
+		}
+		return env
+	}
+	r.ForEach(func(k, v gjson.Result) bool {
+		env[k.String()] = v.String()
+		return true
+	})
+	return env
+}
+
+func diffEnv(old, cur map[string]string) []EnvChange {
+	keys := make([]string, 0)
+	for k := range old {
+		keys = append(keys, k)
+	}
+	for k := range cur {
+		if _, ok := old[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var changes []EnvChange
+	for _, k := range keys {
+		o, n := old[k], cur[k]
+		if o == n {
+			continue
+		}
+		if secretKey.MatchString(k) {
+			if o != "" {
+				o = maskedValue
+			}
+			if n != "" {
+				n = maskedValue
+			}
+		}
+		o, n = redactURLs(o), redactURLs(n)
+		changes = append(changes, EnvChange{Key: k, Change: Change{Old: o, New: n}})
+	}
+	return changes
+}
+
+// diffList compares list settings whose entries are either strings or objects
+// (long syntax). Object entries are compared by their compact JSON form.
+func diffList(old, cur gjson.Result) *ListChange {
+	o, n := listEntries(old), listEntries(cur)
+	lc := &ListChange{}
+	for _, e := range n {
+		if !contains(o, e) {
+			lc.Added = append(lc.Added, e)
+		}
+	}
+	for _, e := range o {
+		if !contains(n, e) {
+			lc.Removed = append(lc.Removed, e)
+		}
+	}
+	if len(lc.Added) == 0 && len(lc.Removed) == 0 {
+		return nil
+	}
+	return lc
+}
+
+func listEntries(r gjson.Result) []string {
+	var entries []string
+	for _, e := range r.Array() {
+		if e.Type == gjson.String {
+			entries = append(entries, redactURLs(e.String()))
+		} else {
+			entries = append(entries, redactURLs(compactJSON(e.Raw)))
+		}
+	}
+	return entries
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+func compactJSON(raw string) string {
+	var b bytes.Buffer
+	if err := json.Compact(&b, []byte(raw)); err != nil {
+		return raw
+	}
+	return b.String()
+}
+
+func orNull(raw string) string {
+	if raw == "" {
+		return "null"
+	}
+	return raw
+}
+
+// Print writes a human readable form of the diff to w.
+func (d *Diff) Print(w io.Writer) {
+	if d.Empty() {
+		fmt.Fprintln(w, "no changes")
+		return
+	}
+	if d.Version != nil {
+		fmt.Fprintf(w, "version: %s -> %s\n", display(d.Version.Old), display(d.Version.New))
+	}
+	for _, s := range d.Services {
+		fmt.Fprintf(w, "%s %s (%s)\n", statusMarkers[s.Status], s.Name, s.Status)
+		if s.Image != nil {
+			fmt.Fprintf(w, "    image: %s -> %s\n", display(s.Image.Old), display(s.Image.New))
+		}
+		for _, e := range s.Environment {
+			fmt.Fprintf(w, "    env %s: %s -> %s\n", e.Key, display(e.Old), display(e.New))
+		}
+		printList(w, "ports", s.Ports)
+		printList(w, "volumes", s.Volumes)
+		if len(s.Other) > 0 {
+			fmt.Fprintf(w, "    other: %s\n", strings.Join(s.Other, ", "))
+		}
+	}
+	for _, r := range d.Resources {
+		fmt.Fprintf(w, "%s %s.%s (%s)\n", statusMarkers[r.Status], r.Kind, r.Name, r.Status)
+	}
+}
+
+// statusMarkers prefix added, removed and changed entries in Print.
+var statusMarkers = map[string]string{"added": "+", "removed": "-", "changed": "~"}
+
+func printList(w io.Writer, name string, lc *ListChange) {
+	if lc == nil {
+		return
+	}
+	for _, e := range lc.Added {
+		fmt.Fprintf(w, "    %s: + %s\n", name, e)
+	}
+	for _, e := range lc.Removed {
+		fmt.Fprintf(w, "    %s: - %s\n", name, e)
+	}
+}
+
+func display(s string) string {
+	if s == "" {
+		return "(none)"
+	}
+	return s
+}
@@ -0,0 +1,84 @@
+package docker
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedactURLs(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"postgres://app:s3cret@db:5432/app", "postgres://app:xxxxx@db:5432/app"},
+		{"redis://:s3cret@cache/0", "redis://:xxxxx@cache/0"},
+		{"https://user@example.com/path", "https://user@example.com/path"},
+		{"https://example.com/?token=1", "https://example.com/?token=1"},
+		{"--dsn amqp://guest:guest@mq/ --verbose", "--dsn amqp://guest:xxxxx@mq/ --verbose"},
+		{"plain value", "plain value"},
+	}
+	for _, tt := range tests {
+		if got := redactURLs(tt.in); got != tt.want {
+			t.Errorf("redactURLs(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestDiffComposeRedactsSecrets(t *testing.T) {
+	prev := `{"services":{"web":{"image":"web:1","environment":{
+		"API_TOKEN":"old","DATABASE_URL":"postgres://app:old@db/app"},
+		"volumes":["/srv:/srv"]}}}`
+	next := `{"services":{"web":{"image":"web:1","environment":{
+		"API_TOKEN":"new","DATABASE_URL":"postgres://app:new@db/app"},
+		"volumes":["/srv:/srv",{"type":"bind","source":"s3://k:hidden@bucket/","target":"/data"}]}}}`
+	d, err := DiffCompose([]byte(prev), []byte(next))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	d.Print(&b)
+	out := b.String()
+	for _, secret := range []string{"old", "new", "hidden"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("diff leaks %q:\n%s", secret, out)
+		}
+	}
+	for _, want := range []string{"env API_TOKEN: ******** -> ********", "env DATABASE_URL: postgres://app:xxxxx@db/app -> postgres://app:xxxxx@db/app"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("diff misses %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestDiffComposeResources(t *testing.T) {
+	prev := `{"services":{"web":{"image":"web:1"}},
+		"networks":{"edge":{"external":true},"old":{}},
+		"configs":{"app":{"content":"debug = false"}}}`
+	next := `{"services":{"web":{"image":"web:1"}},
+		"networks":{"edge":{"external":true},"internal":{"internal":true}},
+		"configs":{"app":{"content":"debug = true"}},
+		"volumes":{"data":{}}}`
+	d, err := DiffCompose([]byte(prev), []byte(next))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Empty() {
+		t.Fatal("diff with changed resources is empty")
+	}
+	var b strings.Builder
+	d.Print(&b)
+	want := "+ networks.internal (added)\n- networks.old (removed)\n+ volumes.data (added)\n~ configs.app (changed)\n"
+	if got := b.String(); got != want {
+		t.Errorf("diff:\n%s\nwant:\n%s", got, want)
+	}
+	if strings.Contains(b.String(), "debug") {
+		t.Error("diff prints config contents")
+	}
+
+	same, err := DiffCompose([]byte(prev), []byte(prev))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !same.Empty() {
+		t.Errorf("diff of identical files = %+v", same)
+	}
+}
@@ -3,8 +3,13 @@
 package docker
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
+	"reflect"
+	"time"
 
 	"github.com/tidwall/gjson"
 )
@@ -21,6 +26,52 @@ func Load(path string) ([]byte, error) {
 	return data, nil
 }
 
+// fetchTimeout bounds downloading a compose file, redirects included.
+const fetchTimeout = 30 * time.Second
+
+// maxRedirects is how many redirects Fetch follows, as net/http does.
+const maxRedirects = 10
+
+// Fetch downloads a compose file from url bypassing any caches and verifies it
+// defines services. The raw bytes are returned. When allowed is not nil every
+// redirect target must pass it too, so a redirect cannot leave an allowlist
+// the first URL was checked against.
+func Fetch(url string, allowed func(url string) bool) ([]byte, error) {
+	client := &http.Client{
+		Timeout: fetchTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			if allowed != nil && !allowed(req.URL.String()) {
+				return fmt.Errorf("redirect to %s not allowed", req.URL.Redacted())
+			}
+			return nil
+		},
+	}
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Cache-Control", "no-cache")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := ServiceNames(data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
 // Save writes the given JSON bytes back to disk.
 func Save(path string, data []byte) error {
 	return os.WriteFile(path, data, 0644)
@@ -46,3 +97,87 @@ func ServiceNames(data []byte) ([]string, error) {
 	}
 	return names, nil
 }
+
+// SetImage replaces the image of service in the compose JSON, keeping the
+// rest of the document untouched.
+func SetImage(data []byte, service, image string) ([]byte, error) {
+	res := gjson.GetBytes(data, "services."+gjson.Escape(service)+".image")
+	if !res.Exists() || res.Index == 0 {
+		return nil, fmt.Errorf("service %s has no image", service)
+	}
+	quoted, err := json.Marshal(image)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, len(data)+len(quoted))
+	out = append(out, data[:res.Index]...)
+	out = append(out, quoted...)
+	return append(out, data[res.Index+len(res.Raw):]...), nil
+}
+
+// sharedKeys are the top-level compose keys defining resources services
+// refer to by name.
+var sharedKeys = []string{"networks", "volumes", "configs", "secrets"}
+
+// ChangedServices compares two compose files and returns the services whose
+// definition differs between them, including services only present in next.
+// A change to a top-level network, volume, config or secret may affect any
+// service, so all services are returned then. The result follows the order
+// of the services in next. Services removed in next are reported by
+// RemovedServices.
+func ChangedServices(prev, next []byte) ([]string, error) {
+	names, err := ServiceNames(next)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range sharedKeys {
+		if !sameValue(gjson.GetBytes(prev, key), gjson.GetBytes(next, key)) {
+			return names, nil
+		}
+	}
+	changed := make([]string, 0)
+	for _, name := range names {
+		path := "services." + gjson.Escape(name)
+		old := gjson.GetBytes(prev, path)
+		cur := gjson.GetBytes(next, path)
+		if !old.Exists() || !sameJSON(old.Raw, cur.Raw) {
+			changed = append(changed, name)
+		}
+	}
+	return changed, nil
+}
+
+// RemovedServices returns the services of prev that next no longer defines,
+// in the order they appear in prev.
+func RemovedServices(prev, next []byte) []string {
+	var removed []string
+	gjson.GetBytes(prev, "services").ForEach(func(key, _ gjson.Result) bool {
+		if !gjson.GetBytes(next, "services."+gjson.Escape(key.String())).Exists() {
+			removed = append(removed, key.String())
+		}
+		return true
+	})
+	return removed
+}
+
+// sameValue reports whether two optional JSON values are both missing or
+// semantically equal.
+func sameValue(a, b gjson.Result) bool {
+	if !a.Exists() || !b.Exists() {
+		return a.Exists() == b.Exists()
+	}
+	return sameJSON(a.Raw, b.Raw)
+}
+
+// sameJSON reports whether two raw JSON values are semantically equal,
+// ignoring formatting and object key order.
+func sameJSON(a, b string) bool {
+	var va, vb any
+	if err := json.Unmarshal([]byte(a), &va); err != nil {
+		return false
+	}
+	if err := json.Unmarshal([]byte(b), &vb); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(va, vb)
+}
@@ -0,0 +1,157 @@
+package docker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestChangedServices(t *testing.T) {
+	const base = `{
+		"networks": {"front": {}},
+		"volumes": {"data": {}},
+		"services": {
+			"web": {"image": "web:1", "ports": ["80:80"]},
+			"worker": {"image": "worker:1", "environment": {"A": "1", "B": "2"}}
+		}
+	}`
+	tests := []struct {
+		name    string
+		next    string
+		changed []string
+		removed []string
+	}{
+		{
+			name:    "identical",
+			next:    base,
+			changed: []string{},
+		},
+		{
+			name: "formatting and key order",
+			next: `{"volumes":{"data":{}},"networks":{"front":{}},"services":{
+				"web":{"ports":["80:80"],"image":"web:1"},
+				"worker":{"environment":{"B":"2","A":"1"},"image":"worker:1"}}}`,
+			changed: []string{},
+		},
+		{
+			name: "one image",
+			next: `{"networks":{"front":{}},"volumes":{"data":{}},"services":{
+				"web":{"image":"web:2","ports":["80:80"]},
+				"worker":{"image":"worker:1","environment":{"A":"1","B":"2"}}}}`,
+			changed: []string{"web"},
+		},
+		{
+			name: "added service",
+			next: `{"networks":{"front":{}},"volumes":{"data":{}},"services":{
+				"web":{"image":"web:1","ports":["80:80"]},
+				"cron":{"image":"cron:1"},
+				"worker":{"image":"worker:1","environment":{"A":"1","B":"2"}}}}`,
+			changed: []string{"cron"},
+		},
+		{
+			name: "removed service",
+			next: `{"networks":{"front":{}},"volumes":{"data":{}},"services":{
+				"web":{"image":"web:1","ports":["80:80"]}}}`,
+			changed: []string{},
+			removed: []string{"worker"},
+		},
+		{
+			name: "changed network",
+			next: `{"networks":{"front":{"internal":true}},"volumes":{"data":{}},"services":{
+				"web":{"image":"web:1","ports":["80:80"]},
+				"worker":{"image":"worker:1","environment":{"A":"1","B":"2"}}}}`,
+			changed: []string{"web", "worker"},
+		},
+		{
+			name: "removed volumes",
+			next: `{"networks":{"front":{}},"services":{
+				"web":{"image":"web:1","ports":["80:80"]},
+				"worker":{"image":"worker:1","environment":{"A":"1","B":"2"}}}}`,
+			changed: []string{"web", "worker"},
+		},
+		{
+			name: "added secret",
+			next: `{"networks":{"front":{}},"volumes":{"data":{}},"secrets":{"db":{"file":"db.txt"}},"services":{
+				"web":{"image":"web:1","ports":["80:80"]}}}`,
+			changed: []string{"web"},
+			removed: []string{"worker"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changed, err := ChangedServices([]byte(base), []byte(tt.next))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(changed, tt.changed) {
+				t.Errorf("ChangedServices = %q, want %q", changed, tt.changed)
+			}
+			if removed := RemovedServices([]byte(base), []byte(tt.next)); !slices.Equal(removed, tt.removed) {
+				t.Errorf("RemovedServices = %q, want %q", removed, tt.removed)
+			}
+		})
+	}
+}
+
+func TestChangedServicesWithoutServices(t *testing.T) {
+	if _, err := ChangedServices([]byte(`{"services":{"web":{}}}`), []byte(`{}`)); err == nil {
+		t.Error("expected an error for a file without services")
+	}
+}
+
+func TestFetch(t *testing.T) {
+	const compose = `{"services":{"web":{"image":"web:1"}}}`
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(compose))
+	}))
+	defer other.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/stacks/web.json":
+			if r.Header.Get("Cache-Control") != "no-cache" {
+				t.Errorf("Cache-Control = %q", r.Header.Get("Cache-Control"))
+			}
+			_, _ = w.Write([]byte(compose))
+		case "/stacks/moved.json":
+			http.Redirect(w, r, "/stacks/web.json", http.StatusFound)
+		case "/stacks/away.json":
+			http.Redirect(w, r, other.URL+"/stacks/web.json", http.StatusFound)
+		case "/stacks/loop.json":
+			http.Redirect(w, r, "/stacks/loop.json", http.StatusFound)
+		case "/stacks/empty.json":
+			_, _ = w.Write([]byte(`{}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	underStacks := func(target string) bool { return strings.HasPrefix(target, srv.URL+"/stacks/") }
+	tests := []struct {
+		path    string
+		allowed func(string) bool
+		err     string
+	}{
+		{"/stacks/web.json", underStacks, ""},
+		{"/stacks/moved.json", underStacks, ""},
+		{"/stacks/away.json", nil, ""},
+		{"/stacks/away.json", underStacks, "redirect to " + other.URL + "/stacks/web.json not allowed"},
+		{"/stacks/loop.json", nil, "stopped after 10 redirects"},
+		{"/stacks/missing.json", nil, "404 Not Found"},
+		{"/stacks/empty.json", nil, "must define services"},
+	}
+	for _, tt := range tests {
+		data, err := Fetch(srv.URL+tt.path, tt.allowed)
+		if tt.err == "" {
+			if err != nil || string(data) != compose {
+				t.Errorf("Fetch(%s) = %q, %v", tt.path, data, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("Fetch(%s) error = %v, want %q", tt.path, err, tt.err)
+		}
+	}
+}
@@ -5,6 +5,7 @@ package docker
 
 import (
 	"fmt"
+	"log/slog"
 	"os"
 	"os/exec"
 )
@@ -13,18 +14,17 @@ import (
 // missing, a best-effort attempt is made to install it using whatever common
 // package manager is detected. When dryRun is true the install commands are
 // printed but not executed.
-func EnsureInstalled(dryRun, verbose bool) error {
+func EnsureInstalled(dryRun bool) error {
 	if _, err := exec.LookPath("docker"); err == nil {
 		return nil
 	}
-	fmt.Println("docker not found, installing via convenience script")
+	slog.Info("docker not found, installing via convenience script")
 	script := "curl -sSL https://get.docker.com | sh"
-	if verbose || dryRun {
-		fmt.Println(script)
-	}
 	if dryRun {
+		fmt.Println(script)
 		return nil
 	}
+	slog.Debug("running command", "cmd", script)
 	cmd := exec.Command("sh", "-c", script)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
@@ -34,8 +34,6 @@ func EnsureInstalled(dryRun, verbose bool) error {
 	if _, err := exec.LookPath("docker"); err != nil {
 		return err
 	}
-	if verbose {
-		fmt.Println("docker installed")
-	}
+	slog.Info("docker installed")
 	return nil
 }
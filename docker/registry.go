@@ -0,0 +1,261 @@
+package docker
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// dockerHub is the registry used for image references without a host.
+const dockerHub = "docker.io"
+
+// Reference is a parsed image reference such as ghcr.io/org/app:1.2.
+type Reference struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// ParseReference splits an image reference into registry, repository, tag
+// and digest, applying Docker's defaults (docker.io, library/, latest).
+func ParseReference(image string) Reference {
+	var ref Reference
+	name := image
+	if i := strings.Index(name, "@"); i >= 0 {
+		ref.Digest = name[i+1:]
+		name = name[:i]
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		ref.Tag = name[i+1:]
+		name = name[:i]
+	}
+	ref.Registry = dockerHub
+	if i := strings.Index(name, "/"); i >= 0 {
+		host := name[:i]
+		if strings.ContainsAny(host, ".:") || host == "localhost" {
+			ref.Registry = host
+			name = name[i+1:]
+		}
+	}
+	if ref.Registry == dockerHub && !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+	ref.Repository = name
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = "latest"
+	}
+	return ref
+}
+
+// SameRepository reports whether both references name the same repository.
+// An empty registry on other matches any registry.
+func (r Reference) SameRepository(other Reference) bool {
+	return r.Repository == other.Repository && (other.Registry == "" || r.Registry == other.Registry)
+}
+
+// String returns the reference in its canonical form.
+func (r Reference) String() string {
+	s := r.Registry + "/" + r.Repository
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// WithTag returns image with its tag and digest replaced. tag is either a tag
+// such as "1.4.2" or a digest such as "@sha256:...", which pins the image.
+func WithTag(image, tag string) string {
+	name, _, _ := strings.Cut(image, "@")
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name = name[:i]
+	}
+	if strings.HasPrefix(tag, "@") {
+		return name + tag
+	}
+	return name + ":" + tag
+}
+
+// manifestTypes are the manifest formats accepted when resolving a digest.
+// Listing the index types first returns the digest docker records in
+// RepoDigests for multi-platform images.
+var manifestTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// RemoteDigest asks the registry for the current manifest digest of the
+// image's tag. Credentials stored by `docker login` are used when present;
+// otherwise an anonymous token is requested.
+func RemoteDigest(ctx context.Context, image string) (string, error) {
+	ref := ParseReference(image)
+	if ref.Digest != "" {
+		return ref.Digest, nil
+	}
+	host := ref.Registry
+	if host == dockerHub {
+		host = "registry-1.docker.io"
+	}
+	url := fmt.Sprintf("https://%s/v2/%s/manifests/%s", host, ref.Repository, ref.Tag)
+	client := &http.Client{Timeout: 30 * time.Second}
+	basic := registryAuth(ref.Registry)
+
+	head := func(authorization string) (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", strings.Join(manifestTypes, ", "))
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		return client.Do(req)
+	}
+	resp, err := head("")
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		authorization := ""
+		if scheme, params := parseChallenge(challenge); strings.EqualFold(scheme, "bearer") {
+			token, err := fetchToken(ctx, client, params, basic)
+			if err != nil {
+				return "", fmt.Errorf("%s: %w", ref, err)
+			}
+			authorization = "Bearer " + token
+		} else if basic != "" {
+			authorization = "Basic " + basic
+		}
+		if resp, err = head(authorization); err != nil {
+			return "", err
+		}
+		resp.Body.Close()
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: registry returned %s", ref, resp.Status)
+	}
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return "", fmt.Errorf("%s: registry did not return a digest", ref)
+	}
+	return digest, nil
+}
+
+// parseChallenge splits a WWW-Authenticate header such as
+// `Bearer realm="https://auth.docker.io/token",service="registry.docker.io"`.
+func parseChallenge(header string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(header, " ")
+	params := make(map[string]string)
+	for _, part := range strings.Split(rest, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if ok {
+			params[strings.ToLower(k)] = strings.Trim(v, `"`)
+		}
+	}
+	return scheme, params
+}
+
+// fetchToken requests a pull token from the realm named in a bearer
+// challenge.
+func fetchToken(ctx context.Context, client *http.Client, params map[string]string, basic string) (string, error) {
+	realm := params["realm"]
+	if realm == "" {
+		return "", fmt.Errorf("auth challenge without realm")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm, nil)
+	if err != nil {
+		return "", err
+	}
+	q := req.URL.Query()
+	for _, k := range []string{"service", "scope"} {
+		if params[k] != "" {
+			q.Set(k, params[k])
+		}
+	}
+	req.URL.RawQuery = q.Encode()
+	if basic != "" {
+		req.Header.Set("Authorization", "Basic "+basic)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request returned %s", resp.Status)
+	}
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	return body.AccessToken, nil
+}
+
+// registryAuth returns the base64 user:password stored for registry in the
+// docker client config, or "" when there is none. Credential helpers are not
+// consulted.
+func registryAuth(registry string) string {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil {
+		return ""
+	}
+	keys := []string{registry, "https://" + registry}
+	if registry == dockerHub {
+		keys = append(keys, "https://index.docker.io/v1/", "index.docker.io")
+	}
+	for _, k := range keys {
+		auth := gjson.GetBytes(data, "auths."+gjson.Escape(k)+".auth").String()
+		if auth == "" {
+			continue
+		}
+		if _, err := base64.StdEncoding.DecodeString(auth); err == nil {
+			return auth
+		}
+	}
+	return ""
+}
+
+// ContainerDigests returns the repository digests of the image the
+// container was created from, e.g. ["nginx@sha256:..."]. Images built
+// locally have none.
+func (r Runner) ContainerDigests(container string) ([]string, error) {
+	id, err := r.Inspect(container, "{{.Image}}")
+	if err != nil {
+		return nil, err
+	}
+	out, err := r.Output(exec.Command("docker", "image", "inspect", "-f", `{{join .RepoDigests " "}}`, id))
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(out), nil
+}
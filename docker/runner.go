@@ -1,32 +1,37 @@
 package docker
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
+	"io"
+	"log/slog"
 	"os/exec"
 	"strings"
 )
 
-// Runner executes shell commands while respecting dry-run and verbose modes.
+// Runner executes shell commands while respecting dry-run mode.
 type Runner struct {
-	DryRun  bool
-	Verbose bool
+	DryRun bool
+	// Progress, when set, receives every line the command prints while it
+	// runs. Carriage returns used to redraw progress bars end a line too.
+	Progress func(line string)
 }
 
 // New creates a new Runner instance.
-func NewRunner(dryRun, verbose bool) Runner {
-	return Runner{DryRun: dryRun, Verbose: verbose}
+func NewRunner(dryRun bool) Runner {
+	return Runner{DryRun: dryRun}
 }
 
 // Run executes the provided command and returns any error including stderr
-// output. When verbose or dry-run mode is enabled the command is printed.
+// output. In dry-run mode the command is only printed.
 func (r Runner) Run(cmd *exec.Cmd) error {
-	if r.Verbose || r.DryRun {
-		fmt.Println(cmd.String())
-	}
 	if r.DryRun {
+		fmt.Println(cmd.String())
 		return nil
 	}
-	out, err := cmd.CombinedOutput()
+	slog.Debug("running command", "cmd", cmd.String())
+	out, err := r.combinedOutput(cmd)
 	if err != nil {
 		return fmt.Errorf("%s: %v: %s", cmd.Path, err, string(out))
 	}
@@ -34,17 +39,56 @@ func (r Runner) Run(cmd *exec.Cmd) error {
 }
 
 // Output executes the command and returns its trimmed stdout. Behavior for
-// verbose and dry-run modes matches Run().
+// dry-run mode matches Run().
 func (r Runner) Output(cmd *exec.Cmd) (string, error) {
-	if r.Verbose || r.DryRun {
-		fmt.Println(cmd.String())
-	}
 	if r.DryRun {
+		fmt.Println(cmd.String())
 		return "", nil
 	}
-	out, err := cmd.CombinedOutput()
+	slog.Debug("running command", "cmd", cmd.String())
+	out, err := r.combinedOutput(cmd)
 	if err != nil {
 		return "", fmt.Errorf("%s: %v: %s", cmd.Path, err, string(out))
 	}
 	return strings.TrimSpace(string(out)), nil
 }
+
+// combinedOutput runs cmd and returns its stdout and stderr, passing each
+// line to Progress as it arrives when set.
+func (r Runner) combinedOutput(cmd *exec.Cmd) ([]byte, error) {
+	if r.Progress == nil {
+		return cmd.CombinedOutput()
+	}
+	var buf bytes.Buffer
+	pr, pw := io.Pipe()
+	cmd.Stdout = io.MultiWriter(&buf, pw)
+	cmd.Stderr = cmd.Stdout
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sc := bufio.NewScanner(pr)
+		sc.Buffer(make([]byte, 0, 64*1024), 1<<20)
+		sc.Split(scanLines)
+		for sc.Scan() {
+			if line := strings.TrimSpace(sc.Text()); line != "" {
+				r.Progress(line)
+			}
+		}
+		_, _ = io.Copy(io.Discard, pr)
+	}()
+	err := cmd.Run()
+	pw.Close()
+	<-done
+	return buf.Bytes(), err
+}
+
+// scanLines is bufio.ScanLines that also splits on a lone carriage return.
+func scanLines(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
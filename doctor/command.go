@@ -0,0 +1,102 @@
+// Package doctor implements the `doctor` subcommand which queries the health
+// endpoints of the local hot-reload listener.
+package doctor
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/plark-inc/hostship/config"
+	"github.com/spf13/cobra"
+)
+
+// Command constructs the `doctor` subcommand.
+func Command() *cobra.Command {
+	var addr string
+	cmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Check the health of the local hot-reload listener",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runDoctor(addr)
+		},
+	}
+	cmd.Flags().StringVar(&addr, "addr", "", "base URL of the hot-reload listener (default from HOSTSHIP_LISTEN_ADDR, http://127.0.0.1:8080; https when TLS is configured)")
+	cmd.Flags().BoolP("verbose", "v", false, "verbose output (same as --log-level debug)")
+	return cmd
+}
+
+// readiness is the JSON returned by /readyz.
+type readiness struct {
+	Status string `json:"status"`
+	Checks map[string]struct {
+		OK    bool   `json:"ok"`
+		Info  string `json:"info"`
+		Error string `json:"error"`
+	} `json:"checks"`
+}
+
+func runDoctor(addr string) error {
+	client, err := newClient(addr == "")
+	if err != nil {
+		return err
+	}
+	if addr == "" {
+		addr = config.ListenerURL()
+	}
+	addr = strings.TrimRight(addr, "/")
+
+	slog.Debug("request", "method", "GET", "url", addr+"/healthz")
+	resp, err := client.Get(addr + "/healthz")
+	if err != nil {
+		fmt.Println("listener: not reachable")
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fmt.Printf("listener: %s\n", resp.Status)
+		return fmt.Errorf("healthz: %s", resp.Status)
+	}
+	fmt.Println("listener: alive")
+
+	slog.Debug("request", "method", "GET", "url", addr+"/readyz")
+	resp, err = client.Get(addr + "/readyz")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var ready readiness
+	if err := json.NewDecoder(resp.Body).Decode(&ready); err != nil {
+		return fmt.Errorf("readyz: %w", err)
+	}
+	names := make([]string, 0, len(ready.Checks))
+	for name := range ready.Checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c := ready.Checks[name]
+		if c.OK {
+			fmt.Printf("%s: ok %s\n", name, c.Info)
+		} else {
+			fmt.Printf("%s: FAIL %s\n", name, c.Error)
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("listener is %s", ready.Status)
+	}
+	return nil
+}
+
+// newClient returns the HTTP client used to reach the listener. The
+// certificate from .env is only trusted for the local listener.
+func newClient(local bool) (*http.Client, error) {
+	if !local {
+		return &http.Client{Timeout: 10 * time.Second}, nil
+	}
+	return config.ListenerClient(10 * time.Second)
+}
@@ -0,0 +1,130 @@
+package hotreload
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/plark-inc/hostship/audit"
+)
+
+// requestInfo collects what handlers learn about a request so it can be
+// recorded once the response is written.
+type requestInfo struct {
+	key     string
+	outcome string
+	version string
+}
+
+type requestInfoKey struct{}
+
+// info returns the requestInfo attached to r by serve. Handlers called
+// without serve get a throwaway value.
+func info(r *http.Request) *requestInfo {
+	if ri, ok := r.Context().Value(requestInfoKey{}).(*requestInfo); ok {
+		return ri
+	}
+	return &requestInfo{}
+}
+
+// statusRecorder remembers the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// serve wraps handle and writes an audit entry for every request except
+// successful health and metrics probes.
+func (u *Updater) serve(w http.ResponseWriter, r *http.Request) {
+	ri := &requestInfo{}
+	rec := &statusRecorder{ResponseWriter: w}
+	u.handle(rec, r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, ri)))
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	if r.Method == http.MethodGet && rec.status < 400 && isProbe(r.URL.Path) {
+		return
+	}
+	outcome := ri.outcome
+	if outcome == "" {
+		outcome = outcomeForStatus(rec.status)
+	}
+	u.writeAudit(audit.Entry{
+		Kind:     audit.KindRequest,
+		Remote:   remoteHost(r),
+		Key:      ri.key,
+		Method:   r.Method,
+		Endpoint: redactPath(r.URL.Path),
+		Status:   rec.status,
+		Outcome:  outcome,
+		Version:  ri.version,
+	})
+}
+
+func (u *Updater) writeAudit(e audit.Entry) {
+	if err := u.audit.Write(e); err != nil {
+		slog.Error("write audit log", "err", err)
+	}
+}
+
+func isProbe(path string) bool {
+	return path == "/healthz" || path == "/readyz" || path == "/metrics"
+}
+
+// outcomeForStatus derives the audit outcome of requests whose handler did not
+// set one.
+func outcomeForStatus(status int) string {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return "denied"
+	case status == http.StatusNotFound:
+		return "not_found"
+	case status >= 500:
+		return "error"
+	case status >= 400:
+		return "rejected"
+	}
+	return "ok"
+}
+
+// remoteHost returns the client IP without the port.
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// redactPath hides the key in /update/<key> and /rollback/<key> so secrets
+// never reach logs.
+func redactPath(path string) string {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) >= 2 && (parts[0] == "update" || parts[0] == "rollback") {
+		parts[1] = "***"
+		return "/" + strings.Join(parts, "/")
+	}
+	return path
+}
@@ -0,0 +1,268 @@
+package hotreload
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"text/template"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// StrategyBlueGreen is the x-metadata deploy strategy that replaces a service
+// without downtime.
+const StrategyBlueGreen = "blue_green"
+
+// defaultHealthTimeout bounds how long a new blue/green container may take to
+// become healthy.
+const defaultHealthTimeout = 2 * time.Minute
+
+// BlueGreen configures the blue/green deployment of a stateless service:
+//
+//	"x-metadata": {
+//	  "deploy": {
+//	    "web": {
+//	      "strategy": "blue_green",
+//	      "health_timeout": "2m",
+//	      "proxy": {
+//	        "file": "caddy/upstream.caddy",
+//	        "template": "reverse_proxy {{.Container}}:3000",
+//	        "reload": {"service": "caddy", "command": ["caddy", "reload", "--config", "/etc/caddy/Caddyfile"]}
+//	      }
+//	    }
+//	  }
+//	}
+//
+// The new version is started under the project hostship-<service>-<color>
+// and, once healthy, attached to the stack network under the service name
+// (or alias), so other services keep resolving it. The optional proxy file is
+// then rewritten and reloaded, and the old container is stopped. Blue/green services must not publish host ports since both colors
+// run side by side.
+type BlueGreen struct {
+	Service       string
+	Network       string
+	Alias         string
+	HealthTimeout time.Duration
+	ProxyFile     string
+	ProxyTemplate string
+	ReloadService string
+	ReloadCommand []string
+}
+
+// parseBlueGreen returns the blue/green configuration of every service that
+// declares the strategy, keyed by service name.
+func parseBlueGreen(cfg []byte) (map[string]BlueGreen, error) {
+	bgs := make(map[string]BlueGreen)
+	var err error
+	gjson.GetBytes(cfg, "x-metadata.deploy").ForEach(func(key, value gjson.Result) bool {
+		name := key.String()
+		strategy := value.Get("strategy").String()
+		if strategy == "" || strategy == "recreate" {
+			return true
+		}
+		if strategy != StrategyBlueGreen {
+			err = fmt.Errorf("service %s: unknown deploy strategy %q", name, strategy)
+			return false
+		}
+		svc := gjson.GetBytes(cfg, "services."+gjson.Escape(name))
+		if !svc.Exists() {
+			err = fmt.Errorf("deploy strategy for unknown service %s", name)
+			return false
+		}
+		if len(svc.Get("ports").Array()) > 0 || svc.Get("container_name").String() != "" {
+			err = fmt.Errorf("service %s: blue/green services cannot publish ports or set container_name", name)
+			return false
+		}
+		bg := BlueGreen{
+			Service:       name,
+			Network:       value.Get("network").String(),
+			Alias:         value.Get("alias").String(),
+			HealthTimeout: defaultHealthTimeout,
+			ProxyFile:     value.Get("proxy.file").String(),
+			ProxyTemplate: value.Get("proxy.template").String(),
+			ReloadService: value.Get("proxy.reload.service").String(),
+		}
+		if bg.Network == "" {
+			bg.Network = project + "_default"
+		}
+		if bg.Alias == "" {
+			bg.Alias = name
+		}
+		if t := value.Get("health_timeout").String(); t != "" {
+			d, perr := time.ParseDuration(t)
+			if perr != nil {
+				err = fmt.Errorf("service %s: invalid health_timeout: %w", name, perr)
+				return false
+			}
+			bg.HealthTimeout = d
+		}
+		for _, a := range value.Get("proxy.reload.command").Array() {
+			bg.ReloadCommand = append(bg.ReloadCommand, a.String())
+		}
+		if (bg.ProxyFile == "") != (bg.ProxyTemplate == "") {
+			err = fmt.Errorf("service %s: proxy requires both file and template", name)
+			return false
+		}
+		if _, perr := template.New("proxy").Parse(bg.ProxyTemplate); perr != nil {
+			err = fmt.Errorf("service %s: invalid proxy template: %w", name, perr)
+			return false
+		}
+		bgs[name] = bg
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return bgs, nil
+}
+
+// deployBlueGreen switches bg.Service to the version defined in the current
+// compose file. On failure the new container is removed and the old one keeps
+// serving traffic.
+func (u *Updater) deployBlueGreen(j *job, bg BlueGreen) error {
+	oldProject, newProject, err := u.colors(bg.Service)
+	if err != nil {
+		return err
+	}
+	slog.Debug("switching blue/green service", "job", jobID(j), "service", bg.Service, "from", oldProject, "to", newProject)
+	if err := u.composeFor(j).UpNoDeps(u.file, newProject, bg.Service); err != nil {
+		return err
+	}
+	abort := func(err error) error {
+		if derr := u.compose.Down(u.file, newProject); derr != nil {
+			err = fmt.Errorf("%w; cleanup: %v", err, derr)
+		}
+		return err
+	}
+	ids, err := u.compose.Ps(u.file, newProject, bg.Service)
+	if err != nil {
+		return abort(err)
+	}
+	if len(ids) == 0 {
+		return abort(fmt.Errorf("blue/green %s: new container not running", bg.Service))
+	}
+	// The alias only resolves to the new containers once they are healthy,
+	// so other services never reach a container that is still starting.
+	for _, id := range ids {
+		if err := u.waitHealthy(j, bg.Service, id, bg.HealthTimeout); err != nil {
+			return abort(fmt.Errorf("blue/green %s: %w", bg.Service, err))
+		}
+	}
+	for _, id := range ids {
+		if err := u.compose.NetworkConnect(bg.Network, bg.Alias, id); err != nil {
+			return abort(err)
+		}
+	}
+	if bg.ProxyFile != "" {
+		if err := u.switchProxy(bg, ids[0]); err != nil {
+			return abort(err)
+		}
+	}
+	if oldProject == project {
+		return u.compose.Remove(u.file, project, bg.Service)
+	}
+	return u.compose.Down(u.file, oldProject)
+}
+
+// colors returns the project currently running service and the project the
+// next version should be started under. Services that were never deployed
+// with blue/green run in the main project and move to blue first.
+func (u *Updater) colors(service string) (string, string, error) {
+	blue := fmt.Sprintf("%s-%s-blue", project, service)
+	green := fmt.Sprintf("%s-%s-green", project, service)
+	ids, err := u.compose.Ps(u.file, blue, service)
+	if err != nil {
+		return "", "", err
+	}
+	if len(ids) > 0 {
+		return blue, green, nil
+	}
+	ids, err = u.compose.Ps(u.file, green, service)
+	if err != nil {
+		return "", "", err
+	}
+	if len(ids) > 0 {
+		return green, blue, nil
+	}
+	return project, blue, nil
+}
+
+// waitHealthy polls the container until it reports healthy, or running when
+// it has no healthcheck.
+func (u *Updater) waitHealthy(j *job, service, id string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	last := ""
+	for {
+		status, err := u.compose.HealthStatus(id)
+		if err != nil {
+			return err
+		}
+		if status != last {
+			j.emit(jobEvent{Type: eventHealth, Service: service, Container: id, Status: status})
+			last = status
+		}
+		switch status {
+		case "healthy", "running":
+			return nil
+		case "unhealthy", "exited", "dead":
+			return fmt.Errorf("container %s is %s", id, status)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("container %s not healthy after %s (status %s)", id, timeout, status)
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
+// switchProxy renders the proxy configuration for the new container and asks
+// the proxy service to reload it. The previous file is restored on failure.
+func (u *Updater) switchProxy(bg BlueGreen, id string) error {
+	name, err := u.compose.ContainerName(id)
+	if err != nil {
+		return err
+	}
+	tmpl, err := template.New("proxy").Parse(bg.ProxyTemplate)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, map[string]string{"Container": name, "Service": bg.Service, "Alias": bg.Alias}); err != nil {
+		return err
+	}
+	prev, prevErr := os.ReadFile(bg.ProxyFile)
+	if err := os.MkdirAll(filepath.Dir(bg.ProxyFile), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(bg.ProxyFile, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	if bg.ReloadService == "" || len(bg.ReloadCommand) == 0 {
+		return nil
+	}
+	if _, err := u.compose.Exec(u.file, project, bg.ReloadService, bg.ReloadCommand...); err != nil {
+		if prevErr == nil {
+			_ = os.WriteFile(bg.ProxyFile, prev, 0644)
+			_, _ = u.compose.Exec(u.file, project, bg.ReloadService, bg.ReloadCommand...)
+		}
+		return fmt.Errorf("reload proxy: %w", err)
+	}
+	return nil
+}
+
+// splitStrategies separates services deployed by recreating them in place
+// from those deployed with blue/green.
+func splitStrategies(services []string, bgs map[string]BlueGreen) ([]string, []BlueGreen) {
+	recreate := make([]string, 0, len(services))
+	var blueGreen []BlueGreen
+	for _, s := range services {
+		if bg, ok := bgs[s]; ok {
+			blueGreen = append(blueGreen, bg)
+		} else {
+			recreate = append(recreate, s)
+		}
+	}
+	return recreate, blueGreen
+}
@@ -0,0 +1,205 @@
+package hotreload
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/plark-inc/hostship/docker"
+)
+
+func TestParseBlueGreen(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  string
+		want map[string]BlueGreen
+		err  string
+	}{
+		{
+			name: "defaults",
+			cfg:  `{"x-metadata":{"deploy":{"web":{"strategy":"blue_green"},"db":{"strategy":"recreate"}}},"services":{"web":{},"db":{}}}`,
+			want: map[string]BlueGreen{"web": {Service: "web", Network: "hostship_default", Alias: "web", HealthTimeout: defaultHealthTimeout}},
+		},
+		{
+			name: "proxy",
+			cfg: `{"x-metadata":{"deploy":{"web":{"strategy":"blue_green","network":"edge","alias":"app","health_timeout":"30s",
+				"proxy":{"file":"up.caddy","template":"reverse_proxy {{.Container}}:3000","reload":{"service":"caddy","command":["caddy","reload"]}}}}},
+				"services":{"web":{}}}`,
+			want: map[string]BlueGreen{"web": {
+				Service:       "web",
+				Network:       "edge",
+				Alias:         "app",
+				HealthTimeout: 30 * time.Second,
+				ProxyFile:     "up.caddy",
+				ProxyTemplate: "reverse_proxy {{.Container}}:3000",
+				ReloadService: "caddy",
+				ReloadCommand: []string{"caddy", "reload"},
+			}},
+		},
+		{
+			name: "unknown strategy",
+			cfg:  `{"x-metadata":{"deploy":{"web":{"strategy":"canary"}}},"services":{"web":{}}}`,
+			err:  `unknown deploy strategy "canary"`,
+		},
+		{
+			name: "unknown service",
+			cfg:  `{"x-metadata":{"deploy":{"api":{"strategy":"blue_green"}}},"services":{"web":{}}}`,
+			err:  "unknown service api",
+		},
+		{
+			name: "published ports",
+			cfg:  `{"x-metadata":{"deploy":{"web":{"strategy":"blue_green"}}},"services":{"web":{"ports":["80:80"]}}}`,
+			err:  "cannot publish ports",
+		},
+		{
+			name: "invalid timeout",
+			cfg:  `{"x-metadata":{"deploy":{"web":{"strategy":"blue_green","health_timeout":"soon"}}},"services":{"web":{}}}`,
+			err:  "invalid health_timeout",
+		},
+		{
+			name: "proxy without template",
+			cfg:  `{"x-metadata":{"deploy":{"web":{"strategy":"blue_green","proxy":{"file":"up.caddy"}}}},"services":{"web":{}}}`,
+			err:  "requires both file and template",
+		},
+		{
+			name: "invalid template",
+			cfg:  `{"x-metadata":{"deploy":{"web":{"strategy":"blue_green","proxy":{"file":"up.caddy","template":"{{.Container"}}}},"services":{"web":{}}}`,
+			err:  "invalid proxy template",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseBlueGreen([]byte(tt.cfg))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseBlueGreen = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeCompose puts a docker executable on PATH that keeps one container per
+// compose project: `up` creates it, `down` and `rm` remove it and `ps` lists
+// it as <project>-id. Running projects are files in the returned directory
+// and every call is logged to its calls file. Containers report the health
+// status in FAKE_HEALTH, healthy by default.
+func fakeCompose(t *testing.T, running ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, p := range running {
+		if err := os.WriteFile(filepath.Join(dir, p), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	script := `#!/bin/sh
+state=` + dir + `
+echo "$@" >> "$state/calls"
+case "$*" in
+compose*" up "*) touch "$state/$5" ;;
+compose*" down"|compose*" rm "*) rm -f "$state/$5" ;;
+compose*" ps "*) [ -f "$state/$5" ] && echo "$5-id" ;;
+"inspect -f {{.Name}} "*) echo "/$4" ;;
+inspect*) echo "${FAKE_HEALTH:-healthy}" ;;
+esac
+exit 0
+`
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "docker"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return dir
+}
+
+func TestDeployBlueGreenSwitchesColor(t *testing.T) {
+	state := fakeCompose(t, "hostship-web-blue")
+	u := New(docker.NewComposeClient(false))
+	u.file = filepath.Join(t.TempDir(), "compose.json")
+	proxy := filepath.Join(t.TempDir(), "upstream.caddy")
+	bg := BlueGreen{
+		Service:       "web",
+		Network:       "hostship_default",
+		Alias:         "web",
+		HealthTimeout: time.Second,
+		ProxyFile:     proxy,
+		ProxyTemplate: "reverse_proxy {{.Container}}:3000",
+	}
+	if err := u.deployBlueGreen(u.jobs.create([]string{"web"}), bg); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(state, "hostship-web-blue")); !os.IsNotExist(err) {
+		t.Error("blue project still running")
+	}
+	if _, err := os.Stat(filepath.Join(state, "hostship-web-green")); err != nil {
+		t.Error("green project not running")
+	}
+	data, err := os.ReadFile(proxy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(data); got != "reverse_proxy hostship-web-green-id:3000" {
+		t.Errorf("proxy file = %q", got)
+	}
+
+	calls := readCalls(t, state)
+	health := index(calls, "inspect -f {{if .State.Health}}")
+	connect := index(calls, "network connect --alias web hostship_default hostship-web-green-id")
+	down := index(calls, "--project-name hostship-web-blue down")
+	if health < 0 || connect < health || down < connect {
+		t.Errorf("docker calls:\n%s\nwant the health check, then the alias, then blue down", strings.Join(calls, "\n"))
+	}
+}
+
+func TestDeployBlueGreenAbortsUnhealthy(t *testing.T) {
+	state := fakeCompose(t, "hostship-web-blue")
+	t.Setenv("FAKE_HEALTH", "starting")
+	u := New(docker.NewComposeClient(false))
+	u.file = filepath.Join(t.TempDir(), "compose.json")
+	bg := BlueGreen{Service: "web", Network: "hostship_default", Alias: "web", HealthTimeout: time.Nanosecond}
+
+	err := u.deployBlueGreen(u.jobs.create([]string{"web"}), bg)
+	if err == nil || !strings.Contains(err.Error(), "not healthy") {
+		t.Fatalf("err = %v, want a health timeout", err)
+	}
+	if _, err := os.Stat(filepath.Join(state, "hostship-web-green")); !os.IsNotExist(err) {
+		t.Error("green project not torn down")
+	}
+	if _, err := os.Stat(filepath.Join(state, "hostship-web-blue")); err != nil {
+		t.Error("blue project stopped")
+	}
+	if calls := readCalls(t, state); index(calls, "network connect") >= 0 {
+		t.Errorf("unhealthy container got the alias:\n%s", strings.Join(calls, "\n"))
+	}
+}
+
+// readCalls returns the docker calls logged by fakeCompose.
+func readCalls(t *testing.T, state string) []string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(state, "calls"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+// index returns the position of the first call containing s, or -1.
+func index(calls []string, s string) int {
+	for i, c := range calls {
+		if strings.Contains(c, s) {
+			return i
+		}
+	}
+	return -1
+}
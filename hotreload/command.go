@@ -1,21 +1,39 @@
 package hotreload
 
 import (
+	"time"
+
 	"github.com/spf13/cobra"
 )
 
 // Command constructs the `hotreload` subcommand which only runs the hot-reload
 // listener without starting the container.
 func Command() *cobra.Command {
-	var verbose bool
+	opts := Options{Guard: DefaultGuardOptions(), GracePeriod: time.Minute, Resume: true}
 	cmd := &cobra.Command{
 		Use:    "hotreload",
 		Short:  "Run only the hot-reload listener",
 		Hidden: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return StartUpdateServer(verbose)
+			return StartUpdateServer(opts)
 		},
 	}
-	cmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "verbose output")
+	cmd.Flags().BoolP("verbose", "v", false, "verbose output (same as --log-level debug)")
+	cmd.Flags().StringVar(&opts.ListenAddr, "listen-addr", "", "address of the update endpoint (default :8080, or HOSTSHIP_LISTEN_ADDR)")
+	cmd.Flags().StringVar(&opts.MetricsAddr, "metrics-addr", "", "serve Prometheus metrics on this address (e.g. :9100, or :8080 to share the update port)")
+	cmd.Flags().StringVar(&opts.TLSCert, "tls-cert", "", "TLS certificate file (enables HTTPS)")
+	cmd.Flags().StringVar(&opts.TLSKey, "tls-key", "", "TLS private key file")
+	cmd.Flags().StringVar(&opts.TLSClientCA, "tls-client-ca", "", "CA bundle used to require client certificates for updates")
+	cmd.Flags().IntVar(&opts.Guard.RatePerMinute, "rate-limit", opts.Guard.RatePerMinute, "update requests allowed per minute and remote address (0 disables)")
+	cmd.Flags().IntVar(&opts.Guard.Burst, "rate-burst", opts.Guard.Burst, "update requests allowed at once per remote address")
+	cmd.Flags().IntVar(&opts.Guard.MaxFailures, "max-failures", opts.Guard.MaxFailures, "invalid keys before a remote address is locked out (0 disables)")
+	cmd.Flags().DurationVar(&opts.Guard.Lockout, "lockout", opts.Guard.Lockout, "how long a remote address stays locked out")
+	cmd.Flags().StringSliceVar(&opts.Guard.AllowCIDRs, "allow-cidr", nil, "source networks allowed to call the update endpoint (default loopback and Docker bridge pools, or HOSTSHIP_ALLOW_CIDRS)")
+	cmd.Flags().DurationVar(&opts.WatchImages, "watch-images", 0, "check the registry for new service images at this interval (e.g. 5m; default HOSTSHIP_WATCH_IMAGES, off)")
+	cmd.Flags().StringSliceVar(&opts.ComposeURLs, "allow-compose-url", nil, "URL prefixes update requests may deploy compose files from (default HOSTSHIP_COMPOSE_URL_PREFIXES)")
+	cmd.Flags().DurationVar(&opts.GracePeriod, "grace-period", opts.GracePeriod, "how long to wait for deploys in progress when stopping")
+	cmd.Flags().BoolVar(&opts.Resume, "resume", opts.Resume, "resume deploys interrupted by a previous stop on start")
+	cmd.Flags().IntVar(&opts.LogArchive, "log-archive", 0, "archive container logs before deploys, keeping this many files per service (default HOSTSHIP_LOG_ARCHIVE, off)")
+	cmd.Flags().BoolVar(&opts.AllowHostHooks, "allow-host-hooks", false, "allow deploy hooks to run commands on the host")
 	return cmd
 }
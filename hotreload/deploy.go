@@ -0,0 +1,523 @@
+package hotreload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/plark-inc/hostship/audit"
+	"github.com/plark-inc/hostship/config"
+	"github.com/plark-inc/hostship/docker"
+	"github.com/plark-inc/hostship/notify"
+	"github.com/tidwall/gjson"
+)
+
+// Sources that can trigger a deploy.
+const (
+	sourceAPI      = "api"
+	sourceRegistry = "registry"
+	sourceWatcher  = "watcher"
+)
+
+// deployRequest describes a deploy triggered through any of the listener's
+// endpoints.
+type deployRequest struct {
+	// Services restricts the deploy to these services. Empty means the
+	// services whose definition changed.
+	Services []string
+	DryRun   bool
+	// ImagesOnly redeploys the current compose file, pulling newer images
+	// for Services, instead of fetching the file from x-metadata.url.
+	ImagesOnly bool
+	// Rollback deploys the compose file kept from before the last deploy
+	// instead of fetching one.
+	Rollback bool
+	// ComposeURL replaces x-metadata.url as the location of the compose
+	// file. Callers must check it against the allowlist.
+	ComposeURL string
+	// Version, when set, must equal x-metadata.version of the downloaded
+	// compose file.
+	Version string
+	// Images overrides the image tag of services in the downloaded file,
+	// keyed by service. A value starting with "@" pins a digest instead.
+	Images map[string]string
+	// Message and Commit describe the change being deployed and are
+	// recorded in the audit log and notifications.
+	Message string
+	Commit  string
+	// Async returns as soon as the deploy is queued instead of after the
+	// pull and pre-deploy hooks; progress is available from its job.
+	Async bool
+	// Key is the name of the deploy key or webhook that authorized the
+	// request; Source is what triggered it (api, github, ...).
+	Key    string
+	Source string
+	// Previous is the compose file replaced by the interrupted deploy an
+	// ImagesOnly request resumes. It is written back when the deploy fails
+	// and kept for /rollback, as the file on disk is already the new one.
+	Previous []byte
+}
+
+// deployResult is returned to the caller once the deploy has been started.
+type deployResult struct {
+	Status   string       `json:"status"`
+	Services []string     `json:"services"`
+	Removed  []string     `json:"removed,omitempty"`
+	Version  string       `json:"version,omitempty"`
+	Diff     *docker.Diff `json:"diff,omitempty"`
+	// Job identifies the deploy's progress stream at /jobs/<job>/events.
+	Job string `json:"job,omitempty"`
+}
+
+// deployError is a deploy failure together with the HTTP status and audit
+// outcome it maps to.
+type deployError struct {
+	status  int
+	outcome string
+	version string
+	err     error
+}
+
+func (e *deployError) Error() string { return e.err.Error() }
+func (e *deployError) Unwrap() error { return e.err }
+
+func failDeploy(status int, err error) *deployError {
+	return &deployError{status: status, err: err}
+}
+
+// deploy downloads the compose file from the URL specified in x-metadata and
+// restarts the services that need it. When the request names services only
+// those are pulled and recreated; otherwise only the services whose definition
+// changed between the current and the downloaded compose file are, and the
+// containers of services no longer in the file are removed. A dry run
+// returns the compose diff without touching the local file. Pre-deploy hooks
+// run after pulling; when one fails the previous compose file is restored and
+// the containers are left untouched. The services are then brought up in the
+// background and post-deploy hooks run once they are up.
+//
+// Every deploy but a dry run gets a job that records its progress. An async
+// request returns the job right away and runs the whole pipeline in the
+// background. Deploys other than dry runs run one at a time; a deploy
+// waits in the queued phase while another is in progress.
+func (u *Updater) deploy(req deployRequest) (*deployResult, error) {
+	var j *job
+	if !req.DryRun {
+		j = u.jobs.create(req.Services)
+	}
+	if req.Async && j != nil {
+		go func() { _, _ = u.runDeploy(j, req) }()
+		return &deployResult{Status: "accepted", Services: append([]string{}, req.Services...), Job: j.ID}, nil
+	}
+	res, err := u.runDeploy(j, req)
+	if res != nil && j != nil {
+		res.Job = j.ID
+	}
+	return res, err
+}
+
+// runDeploy is the deploy pipeline described on deploy. Progress is recorded
+// in j, which is finished once the deploy, including the background part,
+// has completed.
+func (u *Updater) runDeploy(j *job, req deployRequest) (res *deployResult, err error) {
+	done := u.deploys.begin()
+	if j != nil {
+		// Deploys change compose.json and the containers, so they run one
+		// at a time; dry runs only read the file.
+		j.setPhase("queued")
+		u.deploying.Lock()
+		end := done
+		done = func() {
+			end()
+			u.deploying.Unlock()
+		}
+	}
+	async := false
+	defer func() {
+		if async {
+			return
+		}
+		done()
+		var de *deployError
+		switch {
+		case res != nil:
+			u.finish(j, res.Status, nil)
+		case errors.As(err, &de) && de.outcome != "":
+			u.finish(j, de.outcome, err)
+		default:
+			// The deploy failed before it started, so report never
+			// counted it; dry runs are not deploys.
+			if j != nil {
+				u.metrics.deploys.Inc(outcomeFailed)
+			}
+			u.finish(j, outcomeFailed, err)
+		}
+	}()
+	j.setPhase("fetch")
+	cfg, err := docker.Load(u.file)
+	if err != nil {
+		return nil, failDeploy(http.StatusInternalServerError, err)
+	}
+	data := cfg
+	switch {
+	case req.Rollback:
+		if data, err = docker.Load(u.previousFile()); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil, failDeploy(http.StatusConflict, fmt.Errorf("no previous compose file to roll back to"))
+			}
+			return nil, failDeploy(http.StatusInternalServerError, err)
+		}
+	case !req.ImagesOnly:
+		url := firstNonEmpty(req.ComposeURL, docker.GetString(cfg, "x-metadata.url"))
+		if url == "" {
+			return nil, failDeploy(http.StatusInternalServerError, fmt.Errorf("missing x-metadata.url"))
+		}
+		start := time.Now()
+		var allowed func(string) bool
+		if req.ComposeURL != "" {
+			prefixes := u.current().composeURLs
+			allowed = func(target string) bool { return urlAllowed(target, prefixes) }
+		}
+		data, err = docker.Fetch(url, allowed)
+		u.metrics.observePhase("fetch", start)
+		if err != nil {
+			return nil, failDeploy(http.StatusInternalServerError, err)
+		}
+	}
+	if v := docker.GetString(data, "x-metadata.version"); req.Version != "" && v != req.Version {
+		return nil, failDeploy(http.StatusConflict, fmt.Errorf("compose file has version %q, requested %q", v, req.Version))
+	}
+	if data, err = applyImages(data, req.Images); err != nil {
+		return nil, failDeploy(http.StatusBadRequest, err)
+	}
+	names, err := docker.ServiceNames(data)
+	if err != nil {
+		return nil, failDeploy(http.StatusInternalServerError, err)
+	}
+	services, err := selectServices(cfg, data, names, req.Services)
+	if err != nil {
+		return nil, failDeploy(http.StatusBadRequest, err)
+	}
+	removed := docker.RemovedServices(cfg, data)
+	if err := u.validateHooks(data); err != nil {
+		return nil, failDeploy(http.StatusInternalServerError, err)
+	}
+	bgs, err := parseBlueGreen(data)
+	if err != nil {
+		return nil, failDeploy(http.StatusInternalServerError, err)
+	}
+	version := docker.GetString(data, "x-metadata.version")
+	if req.DryRun {
+		d, err := docker.DiffCompose(cfg, data)
+		if err != nil {
+			return nil, failDeploy(http.StatusInternalServerError, err)
+		}
+		return &deployResult{Status: "dry_run", Services: services, Removed: removed, Version: version, Diff: d}, nil
+	}
+	// compose reads the new file for the pull, so it is written now; every
+	// failure below writes the replaced file back so a retry sees the
+	// services as changed again.
+	replaced, changed := cfg, !req.ImagesOnly
+	if req.Previous != nil {
+		replaced, changed = req.Previous, true
+	}
+	if !req.ImagesOnly {
+		if err := docker.Save(u.file, data); err != nil {
+			return nil, failDeploy(http.StatusInternalServerError, err)
+		}
+	}
+	restore := func(err error) error {
+		if !changed {
+			return err
+		}
+		if rbErr := docker.Save(u.file, replaced); rbErr != nil {
+			return fmt.Errorf("%w; rollback: %v", err, rbErr)
+		}
+		return err
+	}
+	j.setServices(services)
+	if len(services) == 0 && len(removed) == 0 {
+		u.metrics.deploys.Inc(outcomeUnchanged)
+		u.metrics.setVersion(data)
+		return &deployResult{Status: outcomeUnchanged, Services: services, Version: version}, nil
+	}
+	event := notify.Event{
+		Version:  version,
+		Services: services,
+		Key:      req.Key,
+		Source:   req.Source,
+		Message:  req.Message,
+		Commit:   req.Commit,
+		Job:      j.ID,
+	}
+	u.report(notify.DeployStarted, event, nil)
+	cp := checkpoint{
+		Job:      j.ID,
+		Services: services,
+		Version:  version,
+		Key:      req.Key,
+		Source:   req.Source,
+		Message:  req.Message,
+		Commit:   req.Commit,
+		Started:  time.Now().UTC(),
+	}
+	if changed {
+		cp.Previous = replaced
+	}
+	phase := func(p string) {
+		j.setPhase(p)
+		cp.Phase = p
+		u.inflight.put(cp)
+		setStatus("deploying %s: %s", strings.Join(services, ", "), p)
+	}
+	if len(services) > 0 {
+		phase("pull")
+		start := time.Now()
+		_, err = u.composeFor(j).Pull(u.file, project, services...)
+		u.metrics.observePhase("pull", start)
+		if err != nil {
+			err = restore(err)
+			u.report(notify.DeployFailed, event, err)
+			return nil, &deployError{status: http.StatusInternalServerError, outcome: outcomeFailed, version: version, err: err}
+		}
+	}
+	phase(PreDeploy)
+	start := time.Now()
+	err = u.runHooks(context.Background(), j, data, PreDeploy)
+	u.metrics.observePhase(PreDeploy, start)
+	if err != nil {
+		err = restore(err)
+		u.report(notify.DeployRolledBack, event, err)
+		return nil, &deployError{status: http.StatusInternalServerError, outcome: outcomeRolledBack, version: version, err: err}
+	}
+	if changed {
+		// Keep the replaced file for /rollback; rolling back twice returns
+		// to the file rolled back from.
+		if err := docker.Save(u.previousFile(), replaced); err != nil {
+			slog.Warn("keep previous compose file", "job", j.ID, "err", err)
+		}
+	}
+	async = true
+	go func() {
+		defer done()
+		phase("up")
+		start := time.Now()
+		err := u.up(j, services, names, removed, bgs)
+		u.metrics.observePhase("up", start)
+		if err != nil {
+			err = restore(err)
+			u.report(notify.DeployFailed, event, err)
+			u.finish(j, outcomeFailed, err)
+			return
+		}
+		phase(PostDeploy)
+		start = time.Now()
+		err = u.runHooks(context.Background(), j, data, PostDeploy)
+		u.metrics.observePhase(PostDeploy, start)
+		if err != nil {
+			err = restore(err)
+			u.report(notify.DeployFailed, event, err)
+			u.finish(j, outcomeFailed, err)
+			return
+		}
+		u.metrics.setVersion(data)
+		u.report(notify.DeploySucceeded, event, nil)
+		u.finish(j, outcomeSucceeded, nil)
+	}()
+	return &deployResult{Status: "updated", Services: services, Removed: removed, Version: version}, nil
+}
+
+// previousFile returns where the compose file replaced by the last deploy
+// is kept.
+func (u *Updater) previousFile() string {
+	return filepath.Join(filepath.Dir(u.file), config.PreviousPath)
+}
+
+// finish ends the job of a deploy and drops its checkpoint.
+func (u *Updater) finish(j *job, status string, err error) {
+	if j == nil {
+		return
+	}
+	u.inflight.remove(j.ID)
+	j.finish(status, err)
+}
+
+// composeFor returns the compose client to use for a deploy, forwarding
+// command output to the job when there is one.
+func (u *Updater) composeFor(j *job) *docker.ComposeClient {
+	if j == nil {
+		return u.compose
+	}
+	return u.compose.WithProgress(j.line)
+}
+
+// report records a deploy event of the given type in the metrics and the
+// audit log and sends it to the configured webhooks in the background.
+func (u *Updater) report(typ string, e notify.Event, err error) {
+	e.Type = typ
+	if err != nil {
+		e.Error = err.Error()
+	}
+	u.writeAudit(audit.Entry{
+		Kind:     audit.KindDeploy,
+		Key:      e.Key,
+		Source:   e.Source,
+		Outcome:  strings.TrimPrefix(typ, "deploy_"),
+		Version:  e.Version,
+		Services: e.Services,
+		Message:  e.Message,
+		Commit:   e.Commit,
+		Error:    e.Error,
+	})
+	switch typ {
+	case notify.DeploySucceeded:
+		u.metrics.deploys.Inc(outcomeSucceeded)
+		u.metrics.lastSuccess.Set(float64(time.Now().Unix()))
+	case notify.DeployFailed:
+		u.metrics.deploys.Inc(outcomeFailed)
+	case notify.DeployRolledBack:
+		u.metrics.deploys.Inc(outcomeRolledBack)
+	case notify.DeployInterrupted:
+		u.metrics.deploys.Inc(outcomeInterrupted)
+	}
+	u.logDeploy(e)
+	u.current().notifier.Go(e)
+}
+
+// deployLevels are the log levels of deploy events.
+var deployLevels = map[string]slog.Level{
+	notify.DeployStarted:     slog.LevelInfo,
+	notify.DeploySucceeded:   slog.LevelInfo,
+	notify.DeployFailed:      slog.LevelError,
+	notify.DeployRolledBack:  slog.LevelWarn,
+	notify.DeployInterrupted: slog.LevelWarn,
+}
+
+// logDeploy logs a deploy event and, once the deploy has ended, shows its
+// outcome as the service status.
+func (u *Updater) logDeploy(e notify.Event) {
+	outcome := strings.TrimPrefix(e.Type, "deploy_")
+	attrs := []any{"job", e.Job, "services", strings.Join(e.Services, ","), "outcome", outcome}
+	for _, kv := range [][2]string{{"version", e.Version}, {"key", e.Key}, {"source", e.Source}, {"commit", e.Commit}, {"err", e.Error}} {
+		if kv[1] != "" {
+			attrs = append(attrs, kv[0], kv[1])
+		}
+	}
+	slog.Log(context.Background(), deployLevels[e.Type], "deploy "+outcome, attrs...)
+	if e.Type != notify.DeployStarted {
+		setStatus("idle; last deploy %s at %s", outcome, time.Now().Format(time.DateTime))
+	}
+}
+
+// up recreates services in place and switches blue/green services to their
+// new version. When blue/green services are configured the in-place services
+// are started without their dependencies so compose never recreates a
+// blue/green service inside the main project. When services were removed
+// from the compose file their containers are removed as orphans; names are
+// all services of the file, brought up when no service changed.
+func (u *Updater) up(j *job, services, names, removed []string, bgs map[string]BlueGreen) error {
+	u.archiveLogs(j, services)
+	recreate, blueGreen := splitStrategies(services, bgs)
+	compose := u.composeFor(j)
+	if len(removed) > 0 {
+		compose = compose.RemovingOrphans()
+		if len(recreate) == 0 {
+			recreate, _ = splitStrategies(names, bgs)
+		}
+	}
+	if len(recreate) > 0 {
+		up := compose.Up
+		if len(bgs) > 0 {
+			up = compose.UpNoDeps
+		}
+		if err := up(u.file, project, recreate...); err != nil {
+			return err
+		}
+	}
+	for _, bg := range blueGreen {
+		if err := u.deployBlueGreen(j, bg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// archiveLogs saves the logs of the containers of services, including
+// blue/green ones, before a deploy replaces them. Failures are logged and do
+// not stop the deploy.
+func (u *Updater) archiveLogs(j *job, services []string) {
+	if u.archive == nil {
+		return
+	}
+	containers, err := u.compose.ProjectContainers(docker.ColorProjects(project, services)...)
+	if err != nil {
+		slog.Warn("failed to list containers to archive logs", "job", jobID(j), "err", err)
+		return
+	}
+	for _, c := range containers {
+		if !slices.Contains(services, c.Service) {
+			continue
+		}
+		if err := u.archive.Save(c, jobID(j)); err != nil {
+			slog.Warn("failed to archive logs", "job", jobID(j), "service", c.Service, "container", c.Name, "err", err)
+		}
+	}
+}
+
+// applyImages returns data with the image tag of each service in overrides
+// replaced. Services are processed in a stable order so errors are
+// deterministic.
+func applyImages(data []byte, overrides map[string]string) ([]byte, error) {
+	names := make([]string, 0, len(overrides))
+	for name := range overrides {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		tag := overrides[name]
+		if tag == "" || strings.ContainsAny(tag, "/ ") || (!strings.HasPrefix(tag, "@") && strings.ContainsAny(tag, ":@")) {
+			return nil, fmt.Errorf("invalid image tag %q for service %s", tag, name)
+		}
+		image := docker.GetString(data, "services."+gjson.Escape(name)+".image")
+		if image == "" {
+			return nil, fmt.Errorf("service %s has no image to override", name)
+		}
+		var err error
+		if data, err = docker.SetImage(data, name, docker.WithTag(image, tag)); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// selectServices returns the services to pull and recreate. Requested services
+// must exist in the new compose file; without a selection the services that
+// changed between prev and next are returned.
+func selectServices(prev, next []byte, names, requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return docker.ChangedServices(prev, next)
+	}
+	known := make(map[string]bool, len(names))
+	for _, n := range names {
+		known[n] = true
+	}
+	seen := make(map[string]bool, len(requested))
+	services := make([]string, 0, len(requested))
+	for _, s := range requested {
+		if !known[s] {
+			return nil, fmt.Errorf("service %s not found", s)
+		}
+		if !seen[s] {
+			seen[s] = true
+			services = append(services, s)
+		}
+	}
+	return services, nil
+}
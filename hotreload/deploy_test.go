@@ -0,0 +1,239 @@
+package hotreload
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/plark-inc/hostship/docker"
+)
+
+// fakeDocker puts a docker executable on PATH that logs its arguments to
+// the returned file and succeeds, except for pulls while FAKE_PULL_FAILS is
+// set. `up` takes FAKE_UP_DELAY seconds.
+func fakeDocker(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	log := filepath.Join(dir, "calls")
+	script := "#!/bin/sh\necho \"$@\" >> " + log + "\n" +
+		"case \"$*\" in *\" pull \"*) [ -z \"$FAKE_PULL_FAILS\" ] || exit 1 ;; *\" up \"*) sleep ${FAKE_UP_DELAY:-0} ;; esac\n"
+	if err := os.WriteFile(filepath.Join(dir, "docker"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return log
+}
+
+func TestDeployPreDeployFailureRollsBack(t *testing.T) {
+	calls := fakeDocker(t)
+	next := `{"x-metadata":{"version":"2","hooks":{"pre_deploy":[{"host":true,"command":"echo migrating; exit 3"}]}},
+		"services":{"web":{"image":"web:2"}}}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(next))
+	}))
+	defer srv.Close()
+
+	file := filepath.Join(t.TempDir(), "compose.json")
+	prev := `{"x-metadata":{"version":"1","url":"` + srv.URL + `"},"services":{"web":{"image":"web:1"}}}`
+	if err := os.WriteFile(file, []byte(prev), 0644); err != nil {
+		t.Fatal(err)
+	}
+	u := New(docker.NewComposeClient(false))
+	u.file = file
+	u.allowHostHooks = true
+
+	res, err := u.deploy(deployRequest{Key: "test", Source: sourceAPI})
+	var de *deployError
+	if !errors.As(err, &de) {
+		t.Fatalf("deploy = %v, %v; want a deploy error", res, err)
+	}
+	if de.outcome != outcomeRolledBack || de.status != http.StatusInternalServerError {
+		t.Errorf("outcome %q status %d, want %q 500", de.outcome, de.status, outcomeRolledBack)
+	}
+	if !strings.Contains(err.Error(), "pre_deploy hook") {
+		t.Errorf("error %q does not name the hook", err)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != prev {
+		t.Errorf("compose.json not restored:\n%s", data)
+	}
+
+	log, err := os.ReadFile(calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(log); !strings.Contains(got, " pull web") || strings.Contains(got, " up ") {
+		t.Errorf("docker calls:\n%s\nwant a pull and no up", got)
+	}
+
+	if len(u.jobs.jobs) != 1 {
+		t.Fatalf("%d jobs, want 1", len(u.jobs.jobs))
+	}
+	for _, j := range u.jobs.jobs {
+		events, _, finished := j.since(0)
+		if !finished {
+			t.Fatal("job not finished")
+		}
+		last := events[len(events)-1]
+		if last.Type != eventDone || last.Status != outcomeRolledBack {
+			t.Errorf("last event %+v, want done %s", last, outcomeRolledBack)
+		}
+	}
+
+	// The failed deploy must release the deploy lock.
+	if _, err := u.deploy(deployRequest{ImagesOnly: true, Services: []string{"web"}}); err != nil {
+		t.Errorf("deploy after rollback: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if !u.deploys.wait(ctx) {
+		t.Error("deploy after rollback did not finish")
+	}
+}
+
+func TestDeployRetryAfterPullFailure(t *testing.T) {
+	calls := fakeDocker(t)
+	next := `{"x-metadata":{"version":"2"},"services":{"web":{"image":"web:2"}}}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(next))
+	}))
+	defer srv.Close()
+
+	file := filepath.Join(t.TempDir(), "compose.json")
+	prev := `{"x-metadata":{"version":"1","url":"` + srv.URL + `"},"services":{"web":{"image":"web:1"}}}`
+	if err := os.WriteFile(file, []byte(prev), 0644); err != nil {
+		t.Fatal(err)
+	}
+	u := New(docker.NewComposeClient(false))
+	u.file = file
+
+	t.Setenv("FAKE_PULL_FAILS", "1")
+	_, err := u.deploy(deployRequest{})
+	var de *deployError
+	if !errors.As(err, &de) || de.outcome != outcomeFailed {
+		t.Fatalf("deploy with a failing pull = %v, want a failed deploy", err)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != prev {
+		t.Errorf("compose.json not restored after the failed pull:\n%s", data)
+	}
+
+	t.Setenv("FAKE_PULL_FAILS", "")
+	res, err := u.deploy(deployRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != "updated" || len(res.Services) != 1 || res.Services[0] != "web" {
+		t.Fatalf("retry = %s %v, want updated [web]", res.Status, res.Services)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if !u.deploys.wait(ctx) {
+		t.Fatal("retry did not finish")
+	}
+	log, err := os.ReadFile(calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(log); strings.Count(got, " pull web") != 2 || !strings.Contains(got, " up ") {
+		t.Errorf("docker calls:\n%s\nwant two pulls and an up", got)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	fakeDocker(t)
+	next := `{"x-metadata":{"version":"2"},"services":{"web":{"image":"web:2"}}}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(next))
+	}))
+	defer srv.Close()
+
+	file := filepath.Join(t.TempDir(), "compose.json")
+	prev := `{"x-metadata":{"version":"1","url":"` + srv.URL + `"},"services":{"web":{"image":"web:1"}}}`
+	if err := os.WriteFile(file, []byte(prev), 0644); err != nil {
+		t.Fatal(err)
+	}
+	u := New(docker.NewComposeClient(false))
+	u.file = file
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := u.deploy(deployRequest{Rollback: true})
+	var de *deployError
+	if !errors.As(err, &de) || de.status != http.StatusConflict {
+		t.Fatalf("rollback without a previous file = %v, want 409", err)
+	}
+
+	steps := []struct {
+		req     deployRequest
+		version string
+	}{
+		{deployRequest{}, "2"},
+		{deployRequest{Rollback: true}, "1"},
+		{deployRequest{Rollback: true}, "2"},
+	}
+	for _, step := range steps {
+		res, err := u.deploy(step.req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Status != "updated" || res.Version != step.version {
+			t.Errorf("deploy %+v = %s %s, want updated %s", step.req, res.Status, res.Version, step.version)
+		}
+		if !u.deploys.wait(ctx) {
+			t.Fatal("deploy did not finish")
+		}
+		data, err := docker.Load(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v := docker.GetString(data, "x-metadata.version"); v != step.version {
+			t.Errorf("compose.json has version %s, want %s", v, step.version)
+		}
+	}
+}
+
+func TestDeployCountsFailuresBeforeStart(t *testing.T) {
+	next := `{"x-metadata":{"version":"2"},"services":{"web":{"image":"web:2"}}}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(next))
+	}))
+	defer srv.Close()
+
+	file := filepath.Join(t.TempDir(), "compose.json")
+	prev := `{"x-metadata":{"version":"1","url":"` + srv.URL + `"},"services":{"web":{"image":"web:1"}}}`
+	if err := os.WriteFile(file, []byte(prev), 0644); err != nil {
+		t.Fatal(err)
+	}
+	u := New(docker.NewComposeClient(false))
+	u.file = file
+
+	for _, req := range []deployRequest{
+		{Version: "3"},
+		{Version: "3", DryRun: true},
+		{ComposeURL: srv.URL + "/other"},
+		{Rollback: true},
+	} {
+		if _, err := u.deploy(req); err == nil {
+			t.Fatalf("deploy %+v succeeded", req)
+		}
+	}
+	var b strings.Builder
+	u.metrics.deploys.Write(&b)
+	if want := `hostship_deploys_total{outcome="failed"} 3`; !strings.Contains(b.String(), want) {
+		t.Errorf("metrics:\n%s\nwant %s", b.String(), want)
+	}
+}
@@ -0,0 +1,254 @@
+package hotreload
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultAllowCIDRs covers loopback and Docker's default bridge address pools,
+// which include the docker0 bridge and the networks compose creates, so
+// containers on the host can reach the update endpoint out of the box.
+var DefaultAllowCIDRs = []string{"127.0.0.0/8", "::1/128", "172.16.0.0/12"}
+
+// guard protects the update endpoint against brute-force attempts. It limits
+// requests per remote IP with a token bucket, locks an IP out after repeated
+// invalid keys and rejects sources outside the allowlist.
+type guard struct {
+	rate      float64 // tokens added per second
+	burst     float64
+	threshold int
+	window    time.Duration
+	lockout   time.Duration
+	allow     []*net.IPNet
+	now       func() time.Time
+
+	mu      sync.Mutex
+	clients map[string]*client
+	calls   int
+}
+
+// client is the state kept per remote IP.
+type client struct {
+	tokens   float64
+	last     time.Time
+	failures []time.Time
+	locked   time.Time
+}
+
+// GuardOptions configures rate limiting and lockouts.
+type GuardOptions struct {
+	// RatePerMinute is the sustained number of update requests allowed per
+	// remote IP; Burst is how many may arrive at once.
+	RatePerMinute int
+	Burst         int
+	// MaxFailures invalid keys within FailureWindow lock the IP out for
+	// Lockout.
+	MaxFailures   int
+	FailureWindow time.Duration
+	Lockout       time.Duration
+	// AllowCIDRs lists the source networks allowed to call the update
+	// endpoint. Empty means DefaultAllowCIDRs.
+	AllowCIDRs []string
+}
+
+// DefaultGuardOptions returns the limits used when nothing is configured.
+func DefaultGuardOptions() GuardOptions {
+	return GuardOptions{
+		RatePerMinute: 30,
+		Burst:         10,
+		MaxFailures:   5,
+		FailureWindow: 15 * time.Minute,
+		Lockout:       15 * time.Minute,
+	}
+}
+
+func newGuard(opts GuardOptions) (*guard, error) {
+	allow, err := parseCIDRs(opts.AllowCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	return &guard{
+		rate:      float64(opts.RatePerMinute) / 60,
+		burst:     float64(opts.Burst),
+		threshold: opts.MaxFailures,
+		window:    opts.FailureWindow,
+		lockout:   opts.Lockout,
+		allow:     allow,
+		now:       time.Now,
+		clients:   make(map[string]*client),
+	}, nil
+}
+
+// parseCIDRs parses the allowlist. Bare addresses are accepted as single
+// host networks and an empty list means DefaultAllowCIDRs.
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	if len(cidrs) == 0 {
+		cidrs = DefaultAllowCIDRs
+	}
+	var allow []*net.IPNet
+	for _, c := range cidrs {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		if !strings.Contains(c, "/") {
+			if strings.Contains(c, ":") {
+				c += "/128"
+			} else {
+				c += "/32"
+			}
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowed CIDR %q: %w", c, err)
+		}
+		allow = append(allow, n)
+	}
+	return allow, nil
+}
+
+// setAllow replaces the allowlist, keeping the state of known clients.
+func (g *guard) setAllow(allow []*net.IPNet) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.allow = allow
+}
+
+// allowed reports whether ip is inside the allowlist.
+func (g *guard) allowed(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, n := range g.allow {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// get returns the state of ip, pruning idle clients from time to time so the
+// map cannot grow without bound.
+func (g *guard) get(ip string, now time.Time) *client {
+	g.calls++
+	if g.calls%1000 == 0 {
+		for k, c := range g.clients {
+			if now.Sub(c.last) > g.window && now.After(c.locked) {
+				delete(g.clients, k)
+			}
+		}
+	}
+	c, ok := g.clients[ip]
+	if !ok {
+		c = &client{tokens: g.burst, last: now}
+		g.clients[ip] = c
+	}
+	return c
+}
+
+// lockedUntil returns the end of the lockout of ip, if any.
+func (g *guard) lockedUntil(ip string) (time.Time, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	c := g.get(ip, now)
+	return c.locked, now.Before(c.locked)
+}
+
+// take consumes a token for ip and reports whether the request may proceed.
+func (g *guard) take(ip string) bool {
+	if g.rate <= 0 {
+		return true
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	c := g.get(ip, now)
+	c.tokens += now.Sub(c.last).Seconds() * g.rate
+	if c.tokens > g.burst {
+		c.tokens = g.burst
+	}
+	c.last = now
+	if c.tokens < 1 {
+		return false
+	}
+	c.tokens--
+	return true
+}
+
+// fail records an invalid key from ip and reports whether it triggered a
+// lockout.
+func (g *guard) fail(ip string) bool {
+	if g.threshold <= 0 {
+		return false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	c := g.get(ip, now)
+	recent := c.failures[:0]
+	for _, t := range c.failures {
+		if now.Sub(t) <= g.window {
+			recent = append(recent, t)
+		}
+	}
+	c.failures = append(recent, now)
+	if len(c.failures) >= g.threshold {
+		c.failures = nil
+		c.locked = now.Add(g.lockout)
+		return true
+	}
+	return false
+}
+
+// succeed clears the failures of ip after a valid key.
+func (g *guard) succeed(ip string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.get(ip, g.now()).failures = nil
+}
+
+// admit applies the allowlist (when checkSource is set), lockout and rate
+// limit to a request. It writes the rejection and returns false when the
+// request must not proceed.
+func (u *Updater) admit(w http.ResponseWriter, r *http.Request, checkSource bool) bool {
+	ip := remoteHost(r)
+	if checkSource && !u.guard.allowed(ip) {
+		u.metrics.rejected.Inc("source")
+		info(r).outcome = "forbidden_source"
+		u.forbiddenSource(w)
+		return false
+	}
+	if until, locked := u.guard.lockedUntil(ip); locked {
+		u.metrics.rejected.Inc("lockout")
+		info(r).outcome = "locked_out"
+		u.tooManyRequests(w, time.Until(until))
+		return false
+	}
+	if !u.guard.take(ip) {
+		u.metrics.rejected.Inc("rate")
+		info(r).outcome = "rate_limited"
+		u.tooManyRequests(w, time.Minute)
+		return false
+	}
+	return true
+}
+
+// failed records an invalid or missing key from the request's source and
+// starts a lockout once the threshold is reached.
+func (u *Updater) failed(r *http.Request) {
+	ip := remoteHost(r)
+	if u.guard.fail(ip) {
+		u.metrics.lockouts.Inc()
+		info(r).outcome = "lockout_started"
+		slog.Warn("locking out remote address", "remote", ip, "duration", u.guard.lockout)
+	}
+}
@@ -0,0 +1,187 @@
+package hotreload
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/plark-inc/hostship/config"
+	"github.com/plark-inc/hostship/keys"
+)
+
+// fakeClock is a settable time source for the guard.
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func testGuard(t *testing.T, opts GuardOptions) (*guard, *fakeClock) {
+	t.Helper()
+	g, err := newGuard(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := &fakeClock{t: time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)}
+	g.now = clock.now
+	return g, clock
+}
+
+func TestGuardRateLimit(t *testing.T) {
+	g, clock := testGuard(t, GuardOptions{RatePerMinute: 60, Burst: 3})
+	steps := []struct {
+		advance time.Duration
+		ip      string
+		want    bool
+	}{
+		{0, "10.0.0.1", true},
+		{0, "10.0.0.1", true},
+		{0, "10.0.0.1", true},
+		{0, "10.0.0.1", false}, // burst used up
+		{0, "10.0.0.2", true},  // other addresses have their own bucket
+		{500 * time.Millisecond, "10.0.0.1", false},
+		{500 * time.Millisecond, "10.0.0.1", true}, // one token per second
+		{0, "10.0.0.1", false},
+		{time.Hour, "10.0.0.1", true}, // refills up to the burst only
+		{0, "10.0.0.1", true},
+		{0, "10.0.0.1", true},
+		{0, "10.0.0.1", false},
+	}
+	for i, s := range steps {
+		clock.advance(s.advance)
+		if got := g.take(s.ip); got != s.want {
+			t.Errorf("step %d: take(%s) = %v, want %v", i, s.ip, got, s.want)
+		}
+	}
+
+	unlimited, _ := testGuard(t, GuardOptions{})
+	for i := 0; i < 100; i++ {
+		if !unlimited.take("10.0.0.1") {
+			t.Fatal("a zero rate must not limit requests")
+		}
+	}
+}
+
+func TestGuardLockout(t *testing.T) {
+	const ip = "10.0.0.1"
+	tests := []struct {
+		name     string
+		failures []time.Duration // delay before each failure
+		succeed  int             // index of the failure followed by a valid key, -1 for none
+		locked   bool
+	}{
+		{"below threshold", []time.Duration{0, time.Second}, -1, false},
+		{"threshold reached", []time.Duration{0, time.Second, time.Second}, -1, true},
+		{"failures outside the window", []time.Duration{0, 6 * time.Minute, 6 * time.Minute}, -1, false},
+		{"valid key resets", []time.Duration{0, time.Second, time.Second}, 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, clock := testGuard(t, GuardOptions{MaxFailures: 3, FailureWindow: 10 * time.Minute, Lockout: 15 * time.Minute})
+			started := false
+			for i, d := range tt.failures {
+				clock.advance(d)
+				started = g.fail(ip)
+				if i == tt.succeed {
+					g.succeed(ip)
+				}
+			}
+			if started != tt.locked {
+				t.Errorf("lockout started = %v, want %v", started, tt.locked)
+			}
+			until, locked := g.lockedUntil(ip)
+			if locked != tt.locked {
+				t.Fatalf("locked = %v, want %v", locked, tt.locked)
+			}
+			if _, other := g.lockedUntil("10.0.0.2"); other {
+				t.Error("lockout applies to another address")
+			}
+			if !locked {
+				return
+			}
+			if want := clock.t.Add(15 * time.Minute); !until.Equal(want) {
+				t.Errorf("locked until %v, want %v", until, want)
+			}
+			clock.advance(15*time.Minute - time.Second)
+			if _, locked := g.lockedUntil(ip); !locked {
+				t.Error("lockout ended early")
+			}
+			clock.advance(time.Second)
+			if _, locked := g.lockedUntil(ip); locked {
+				t.Error("lockout did not end")
+			}
+		})
+	}
+}
+
+func TestGuardAllowlist(t *testing.T) {
+	g, _ := testGuard(t, GuardOptions{AllowCIDRs: []string{"10.1.0.0/16", "192.0.2.7", "2001:db8::1"}})
+	for ip, want := range map[string]bool{
+		"10.1.2.3":    true,
+		"10.2.0.1":    false,
+		"192.0.2.7":   true,
+		"192.0.2.8":   false,
+		"2001:db8::1": true,
+		"2001:db8::2": false,
+		"not-an-ip":   false,
+	} {
+		if got := g.allowed(ip); got != want {
+			t.Errorf("allowed(%s) = %v, want %v", ip, got, want)
+		}
+	}
+	if _, err := newGuard(GuardOptions{AllowCIDRs: []string{"10.0.0.0/33"}}); err == nil {
+		t.Error("expected an error for an invalid CIDR")
+	}
+	def, _ := testGuard(t, GuardOptions{})
+	if !def.allowed("172.17.0.1") || !def.allowed("127.0.0.1") || def.allowed("8.8.8.8") {
+		t.Error("default allowlist must cover loopback and the docker bridges only")
+	}
+}
+
+func TestUpdateEndpointLocksOut(t *testing.T) {
+	t.Chdir(t.TempDir())
+	store, err := keys.Load(config.KeysPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Create("ci", []string{keys.ScopeStatus}, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(config.KeysPath); err != nil {
+		t.Fatal(err)
+	}
+
+	u := New(nil)
+	g, _ := testGuard(t, GuardOptions{RatePerMinute: 600, Burst: 100, MaxFailures: 3, FailureWindow: time.Minute, Lockout: time.Minute, AllowCIDRs: []string{"192.0.2.0/24"}})
+	u.guard = g
+	post := func(path, remote string) (int, string) {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.RemoteAddr = remote + ":40000"
+		rec := httptest.NewRecorder()
+		u.serve(rec, req)
+		return rec.Code, rec.Body.String()
+	}
+	steps := []struct {
+		path, remote string
+		status       int
+		body         string
+	}{
+		{"/update/wrong", "192.0.2.1", http.StatusForbidden, "invalid key"},
+		{"/update", "192.0.2.1", http.StatusUnauthorized, "missing key"},
+		{"/rollback/wrong", "192.0.2.1", http.StatusForbidden, "invalid key"},
+		{"/update/wrong", "192.0.2.1", http.StatusTooManyRequests, ""},
+		{"/update/wrong", "192.0.2.2", http.StatusForbidden, "invalid key"},
+		{"/update/wrong", "198.51.100.1", http.StatusForbidden, "source"},
+	}
+	for i, s := range steps {
+		status, body := post(s.path, s.remote)
+		if status != s.status || !strings.Contains(body, s.body) {
+			t.Errorf("step %d: POST %s from %s = %d %q, want %d %q", i, s.path, s.remote, status, body, s.status, s.body)
+		}
+	}
+}
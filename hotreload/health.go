@@ -0,0 +1,164 @@
+package hotreload
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/plark-inc/hostship/docker"
+)
+
+// stuckAfter is how long a deploy may run before /readyz reports it as stuck.
+const stuckAfter = 30 * time.Minute
+
+// deployTracker records the deploys currently in progress.
+type deployTracker struct {
+	mu      sync.Mutex
+	running map[int]time.Time
+	next    int
+}
+
+// begin marks a deploy as started and returns the function that marks it as
+// finished.
+func (t *deployTracker) begin() func() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.running == nil {
+		t.running = make(map[int]time.Time)
+	}
+	id := t.next
+	t.next++
+	t.running[id] = time.Now()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			delete(t.running, id)
+		})
+	}
+}
+
+// oldest returns the start time of the longest running deploy.
+func (t *deployTracker) oldest() (time.Time, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var oldest time.Time
+	for _, start := range t.running {
+		if oldest.IsZero() || start.Before(oldest) {
+			oldest = start
+		}
+	}
+	return oldest, !oldest.IsZero()
+}
+
+// wait blocks until no deploy is in progress or ctx is done and reports
+// whether all deploys finished.
+func (t *deployTracker) wait(ctx context.Context) bool {
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if _, busy := t.oldest(); !busy {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+}
+
+// check is the result of a single readiness check.
+type check struct {
+	OK    bool   `json:"ok"`
+	Info  string `json:"info,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// healthz reports that the process is alive.
+func (u *Updater) healthz(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// aliveTimeout bounds the watchdog's request to /healthz.
+const aliveTimeout = 5 * time.Second
+
+// alive returns the watchdog's liveness check: a request to /healthz through
+// the update listener at addr. A stuck accept loop or handler then stops the
+// pings and systemd restarts the service. Once shutdown begins the listener
+// is closed, so it reports true while deploys drain.
+func (u *Updater) alive(addr net.Addr) func() bool {
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return func() bool { return true }
+	}
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+	scheme, transport := "http", &http.Transport{DisableKeepAlives: true}
+	if u.tls != nil {
+		// The certificate is for the public name, not the address dialed.
+		scheme = "https"
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	client := &http.Client{Transport: transport, Timeout: aliveTimeout}
+	url := scheme + "://" + net.JoinHostPort(host, port) + "/healthz"
+	return func() bool {
+		select {
+		case <-u.stopping:
+			return true
+		default:
+		}
+		resp, err := client.Get(url)
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	}
+}
+
+// readyz reports whether the listener can deploy: the compose file loads, the
+// Docker daemon answers and no deploy has been running for longer than
+// stuckAfter.
+func (u *Updater) readyz(w http.ResponseWriter) {
+	checks := map[string]check{}
+
+	if cfg, err := docker.Load(u.file); err != nil {
+		checks["compose"] = check{Error: err.Error()}
+	} else {
+		checks["compose"] = check{OK: true, Info: docker.GetString(cfg, "x-metadata.version")}
+	}
+
+	if v, err := u.compose.Ping(); err != nil {
+		checks["docker"] = check{Error: err.Error()}
+	} else {
+		checks["docker"] = check{OK: true, Info: v}
+	}
+
+	deploy := check{OK: true, Info: "idle"}
+	if start, ok := u.deploys.oldest(); ok {
+		age := time.Since(start).Round(time.Second)
+		deploy.Info = "running for " + age.String()
+		if age > stuckAfter {
+			deploy = check{Error: "deploy running for " + age.String()}
+		}
+	}
+	checks["deploy"] = deploy
+
+	status, code := "ready", http.StatusOK
+	for _, c := range checks {
+		if !c.OK {
+			status, code = "not ready", http.StatusServiceUnavailable
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]any{"status": status, "checks": checks})
+}
@@ -0,0 +1,38 @@
+package hotreload
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAlive(t *testing.T) {
+	u := New(nil)
+	srv := httptest.NewServer(http.HandlerFunc(u.serve))
+	addr := srv.Listener.Addr()
+	if !u.alive(addr)() {
+		t.Error("alive = false while /healthz answers")
+	}
+	if !u.alive(&net.TCPAddr{IP: net.IPv4zero, Port: addr.(*net.TCPAddr).Port})() {
+		t.Error("alive = false for a listener on all addresses")
+	}
+
+	stuck := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "wedged", http.StatusInternalServerError)
+	}))
+	defer stuck.Close()
+	if u.alive(stuck.Listener.Addr())() {
+		t.Error("alive = true while /healthz fails")
+	}
+
+	srv.Close()
+	check := u.alive(addr)
+	if check() {
+		t.Error("alive = true after the listener closed")
+	}
+	u.stopOnce.Do(func() { close(u.stopping) })
+	if !check() {
+		t.Error("alive = false while shutting down")
+	}
+}
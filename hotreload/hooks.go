@@ -0,0 +1,136 @@
+package hotreload
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// Hook phases that can be declared under x-metadata.hooks.
+const (
+	PreDeploy  = "pre_deploy"
+	PostDeploy = "post_deploy"
+)
+
+// defaultHookTimeout bounds hooks that do not declare a timeout.
+const defaultHookTimeout = 10 * time.Minute
+
+// Hook is a command run at a deploy phase. Service hooks run as a one-off
+// `docker compose run` against the named service; host hooks run directly on
+// the host and are only executed when the listener allows them.
+//
+//	"x-metadata": {
+//	  "hooks": {
+//	    "pre_deploy": [{"service": "app", "command": ["./migrate"], "timeout": "5m"}],
+//	    "post_deploy": [{"host": true, "command": "curl -fsS localhost/warmup"}]
+//	  }
+//	}
+//
+// A string command is run through `sh -c`, an array is executed as is.
+type Hook struct {
+	Service string
+	Host    bool
+	Command []string
+	Timeout time.Duration
+}
+
+// String returns a short description of the hook for logs and errors.
+func (h Hook) String() string {
+	if h.Host {
+		return fmt.Sprintf("host %q", h.Command)
+	}
+	return fmt.Sprintf("%s %q", h.Service, h.Command)
+}
+
+// parseHooks returns the hooks declared for phase in the compose file.
+func parseHooks(cfg []byte, phase string) ([]Hook, error) {
+	var hooks []Hook
+	for i, r := range gjson.GetBytes(cfg, "x-metadata.hooks."+phase).Array() {
+		h := Hook{
+			Service: r.Get("service").String(),
+			Host:    r.Get("host").Bool(),
+			Timeout: defaultHookTimeout,
+		}
+		cmd := r.Get("command")
+		if cmd.IsArray() {
+			for _, a := range cmd.Array() {
+				h.Command = append(h.Command, a.String())
+			}
+		} else if cmd.String() != "" {
+			h.Command = []string{"sh", "-c", cmd.String()}
+		}
+		if len(h.Command) == 0 {
+			return nil, fmt.Errorf("%s hook %d: missing command", phase, i)
+		}
+		if h.Host == (h.Service != "") {
+			return nil, fmt.Errorf("%s hook %d: exactly one of service or host must be set", phase, i)
+		}
+		if t := r.Get("timeout").String(); t != "" {
+			d, err := time.ParseDuration(t)
+			if err != nil {
+				return nil, fmt.Errorf("%s hook %d: invalid timeout: %w", phase, i, err)
+			}
+			h.Timeout = d
+		}
+		hooks = append(hooks, h)
+	}
+	return hooks, nil
+}
+
+// validateHooks checks the hooks of every phase before a deploy starts so a
+// malformed or disallowed hook never leaves a half-applied update behind.
+func (u *Updater) validateHooks(cfg []byte) error {
+	for _, phase := range []string{PreDeploy, PostDeploy} {
+		hooks, err := parseHooks(cfg, phase)
+		if err != nil {
+			return err
+		}
+		for _, h := range hooks {
+			if h.Host && !u.allowHostHooks {
+				return fmt.Errorf("%s hook %s: host hooks are not allowed; start the listener with --allow-host-hooks", phase, h)
+			}
+		}
+	}
+	return nil
+}
+
+// runHooks executes the hooks declared for phase in order and stops at the
+// first failure.
+func (u *Updater) runHooks(ctx context.Context, j *job, cfg []byte, phase string) error {
+	hooks, err := parseHooks(cfg, phase)
+	if err != nil {
+		return err
+	}
+	for _, h := range hooks {
+		slog.Debug("running hook", "job", jobID(j), "phase", phase, "hook", h.String())
+		j.emit(jobEvent{Type: eventHook, Message: "running " + h.String()})
+		out, err := u.runHook(ctx, j, h)
+		if out != "" {
+			slog.Debug("hook output", "job", jobID(j), "hook", h.String(), "output", out)
+		}
+		if err != nil {
+			return fmt.Errorf("%s hook %s: %w", phase, h, err)
+		}
+	}
+	return nil
+}
+
+func (u *Updater) runHook(ctx context.Context, j *job, h Hook) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, h.Timeout)
+	defer cancel()
+	compose := u.composeFor(j)
+	if !h.Host {
+		return compose.RunOneOff(ctx, u.file, project, h.Service, h.Command...)
+	}
+	if !u.allowHostHooks {
+		return "", fmt.Errorf("host hooks are not allowed; start the listener with --allow-host-hooks")
+	}
+	cmd := exec.CommandContext(ctx, h.Command[0], h.Command[1:]...)
+	cmd.Env = os.Environ()
+	return compose.Output(cmd)
+}
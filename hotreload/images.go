@@ -0,0 +1,200 @@
+package hotreload
+
+import (
+	"context"
+	"crypto/subtle"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/plark-inc/hostship/config"
+	"github.com/plark-inc/hostship/docker"
+	"github.com/tidwall/gjson"
+)
+
+// handleRegistryWebhook redeploys the services whose image was pushed, as
+// reported by a Docker Hub webhook or a registry (distribution) notification.
+// The compose file is not fetched again. Requests authenticate with
+// HOSTSHIP_REGISTRY_TOKEN as a bearer token or a "token" query parameter,
+// since Docker Hub cannot send custom headers.
+func (u *Updater) handleRegistryWebhook(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBody))
+	if err != nil {
+		u.badRequest(w, err)
+		return
+	}
+	config.LoadEnv()
+	token := os.Getenv("HOSTSHIP_REGISTRY_TOKEN")
+	given := firstNonEmpty(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "), r.URL.Query().Get("token"))
+	if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(given)) != 1 {
+		u.rejectWebhook(w, r, sourceRegistry)
+		return
+	}
+	info(r).key = sourceRegistry
+	u.guard.succeed(remoteHost(r))
+	pushed := parseRegistryPush(body)
+	if len(pushed) == 0 {
+		info(r).outcome = "ignored"
+		u.webhookIgnored(w, "no pushed tags in payload")
+		return
+	}
+	cfg, err := docker.Load(u.file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	services := servicesUsing(cfg, pushed)
+	if len(services) == 0 {
+		info(r).outcome = "ignored"
+		u.webhookIgnored(w, "no service uses the pushed images")
+		return
+	}
+	slog.Info("registry push updates services", "services", strings.Join(services, ","))
+	res, err := u.deploy(deployRequest{Services: services, ImagesOnly: true, Key: sourceRegistry, Source: sourceRegistry})
+	u.writeDeployResult(w, r, res, err)
+}
+
+// parseRegistryPush returns the image references pushed according to a
+// Docker Hub webhook or a distribution notification envelope. References
+// from distribution notifications carry no registry because the host the
+// pusher used may differ from the one in the compose file.
+func parseRegistryPush(body []byte) []docker.Reference {
+	var refs []docker.Reference
+	if repo := gjson.GetBytes(body, "repository.repo_name").String(); repo != "" {
+		ref := docker.ParseReference(repo)
+		ref.Tag = gjson.GetBytes(body, "push_data.tag").String()
+		if ref.Tag != "" {
+			refs = append(refs, ref)
+		}
+		return refs
+	}
+	for _, e := range gjson.GetBytes(body, "events").Array() {
+		tag := e.Get("target.tag").String()
+		if e.Get("action").String() != "push" || tag == "" {
+			continue
+		}
+		refs = append(refs, docker.Reference{Repository: e.Get("target.repository").String(), Tag: tag})
+	}
+	return refs
+}
+
+// servicesUsing returns the services of cfg whose image is one of refs.
+func servicesUsing(cfg []byte, refs []docker.Reference) []string {
+	names, err := docker.ServiceNames(cfg)
+	if err != nil {
+		return nil
+	}
+	var services []string
+	for _, name := range names {
+		image := gjson.GetBytes(cfg, "services."+gjson.Escape(name)+".image").String()
+		if image == "" {
+			continue
+		}
+		svc := docker.ParseReference(image)
+		for _, ref := range refs {
+			// Distribution notifications use the bare repository path, which
+			// for Docker Hub images lacks the implicit library/ prefix.
+			if svc.Tag == ref.Tag && (svc.SameRepository(ref) || svc.Repository == "library/"+ref.Repository) {
+				services = append(services, name)
+				break
+			}
+		}
+	}
+	return services
+}
+
+// watch compares the image digest of every running service with the
+// registry every interval and redeploys the services whose tag now points to
+// a different image. Ticks are skipped while a deploy is in progress.
+func (u *Updater) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if _, busy := u.deploys.oldest(); busy {
+			continue
+		}
+		services, err := u.outdatedServices(ctx)
+		if err != nil {
+			slog.Error("image watch", "err", err)
+			continue
+		}
+		if len(services) == 0 {
+			continue
+		}
+		slog.Info("new images for services", "services", strings.Join(services, ","))
+		_, err = u.deploy(deployRequest{Services: services, ImagesOnly: true, Key: sourceWatcher, Source: sourceWatcher})
+		if err != nil {
+			slog.Error("image watch", "err", err)
+		}
+	}
+}
+
+// outdatedServices returns the running services whose image tag resolves to
+// a different digest in the registry than the image of their container.
+// Services without an image, pinned by digest or built locally are skipped,
+// as are registries that cannot be reached.
+func (u *Updater) outdatedServices(ctx context.Context) ([]string, error) {
+	cfg, err := docker.Load(u.file)
+	if err != nil {
+		return nil, err
+	}
+	names, err := docker.ServiceNames(cfg)
+	if err != nil {
+		return nil, err
+	}
+	bgs, err := parseBlueGreen(cfg)
+	if err != nil {
+		return nil, err
+	}
+	remote := make(map[string]string)
+	var outdated []string
+	for _, name := range names {
+		image := gjson.GetBytes(cfg, "services."+gjson.Escape(name)+".image").String()
+		if image == "" || docker.ParseReference(image).Digest != "" {
+			continue
+		}
+		p := project
+		if _, ok := bgs[name]; ok {
+			if p, _, err = u.colors(name); err != nil {
+				return nil, err
+			}
+		}
+		ids, err := u.compose.Ps(u.file, p, name)
+		if err != nil || len(ids) == 0 {
+			continue
+		}
+		local, err := u.compose.ContainerDigests(ids[0])
+		if err != nil || len(local) == 0 {
+			continue
+		}
+		digest, ok := remote[image]
+		if !ok {
+			if digest, err = docker.RemoteDigest(ctx, image); err != nil {
+				slog.Debug("image watch", "service", name, "err", err)
+			}
+			remote[image] = digest
+		}
+		if digest != "" && !hasDigest(local, digest) {
+			outdated = append(outdated, name)
+		}
+	}
+	return outdated, nil
+}
+
+// hasDigest reports whether one of the repository digests ends in digest.
+func hasDigest(repoDigests []string, digest string) bool {
+	for _, d := range repoDigests {
+		if strings.HasSuffix(d, "@"+digest) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,162 @@
+package hotreload
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/plark-inc/hostship/notify"
+)
+
+// sourceResume marks deploys started to finish an interrupted one.
+const sourceResume = "resume"
+
+// checkpoint is the persisted state of a deploy in progress.
+type checkpoint struct {
+	Job      string    `json:"job"`
+	Services []string  `json:"services"`
+	Version  string    `json:"version,omitempty"`
+	Phase    string    `json:"phase"`
+	Key      string    `json:"key,omitempty"`
+	Source   string    `json:"source,omitempty"`
+	Message  string    `json:"message,omitempty"`
+	Commit   string    `json:"commit,omitempty"`
+	Started  time.Time `json:"started"`
+	// Previous is the compose file the deploy replaced, restored when its
+	// resumption fails.
+	Previous json.RawMessage `json:"previous,omitempty"`
+}
+
+// inflight keeps a checkpoint of every deploy that changed the compose file
+// and has not finished yet, written through to a file so it survives the
+// listener being stopped or killed. A nil inflight records nothing.
+type inflight struct {
+	path string
+
+	mu    sync.Mutex
+	items map[string]checkpoint
+}
+
+func newInflight(path string) *inflight {
+	return &inflight{path: path, items: make(map[string]checkpoint)}
+}
+
+// put stores or updates cp.
+func (f *inflight) put(cp checkpoint) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.items[cp.Job] = cp
+	f.save()
+}
+
+// remove drops the checkpoint of job once its deploy has finished.
+func (f *inflight) remove(job string) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.items[job]; !ok {
+		return
+	}
+	delete(f.items, job)
+	f.save()
+}
+
+// save writes the checkpoints, removing the file when there are none. It
+// must be called with mu held.
+func (f *inflight) save() {
+	if len(f.items) == 0 {
+		if err := os.Remove(f.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.Error("save in-flight deploys", "err", err)
+		}
+		return
+	}
+	list := make([]checkpoint, 0, len(f.items))
+	for _, cp := range f.items {
+		list = append(list, cp)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Started.Before(list[j].Started) })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return
+	}
+	tmp := f.path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0600); err != nil {
+		slog.Error("save in-flight deploys", "err", err)
+		return
+	}
+	if err := os.Rename(tmp, f.path); err != nil {
+		slog.Error("save in-flight deploys", "err", err)
+	}
+}
+
+// takeInterrupted reads the checkpoints left by a previous run and clears
+// the file.
+func (f *inflight) takeInterrupted() ([]checkpoint, error) {
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []checkpoint
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", f.path, err)
+	}
+	if err := os.Remove(f.path); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// recoverInterrupted reports the deploys a previous run was stopped in the
+// middle of and, when resume is set, deploys their services again from the
+// compose file already on disk so pre-deploy hooks, recreation and
+// post-deploy hooks run to completion. A resumption that fails restores the
+// compose file the interrupted deploy replaced.
+func (u *Updater) recoverInterrupted(resume bool) {
+	list, err := u.inflight.takeInterrupted()
+	if err != nil {
+		slog.Error("read interrupted deploys", "err", err)
+		return
+	}
+	for _, cp := range list {
+		u.report(notify.DeployInterrupted, notify.Event{
+			Version:  cp.Version,
+			Services: cp.Services,
+			Key:      cp.Key,
+			Source:   cp.Source,
+			Message:  cp.Message,
+			Commit:   cp.Commit,
+			Job:      cp.Job,
+		}, fmt.Errorf("listener stopped during %s", cp.Phase))
+		if !resume {
+			continue
+		}
+		res, err := u.deploy(deployRequest{
+			Services:   cp.Services,
+			ImagesOnly: true,
+			Async:      true,
+			Key:        cp.Key,
+			Source:     sourceResume,
+			Message:    firstNonEmpty(cp.Message, "resume interrupted deploy "+cp.Job),
+			Commit:     cp.Commit,
+			Previous:   cp.Previous,
+		})
+		if err != nil {
+			slog.Error("resume interrupted deploy", "job", cp.Job, "err", err)
+			continue
+		}
+		slog.Info("resuming interrupted deploy", "job", res.Job, "interrupted_job", cp.Job)
+	}
+}
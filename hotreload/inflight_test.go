@@ -0,0 +1,211 @@
+package hotreload
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/plark-inc/hostship/config"
+	"github.com/plark-inc/hostship/docker"
+	"github.com/plark-inc/hostship/keys"
+)
+
+// waitJobs waits until every job of u has finished and returns their last
+// events.
+func waitJobs(t *testing.T, u *Updater) []jobEvent {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		u.jobs.mu.Lock()
+		jobs := make([]*job, 0, len(u.jobs.jobs))
+		for _, j := range u.jobs.jobs {
+			jobs = append(jobs, j)
+		}
+		u.jobs.mu.Unlock()
+		var last []jobEvent
+		for _, j := range jobs {
+			if events, _, finished := j.since(0); finished {
+				last = append(last, events[len(events)-1])
+			}
+		}
+		if len(jobs) > 0 && len(last) == len(jobs) {
+			return last
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d of %d jobs finished", len(last), len(jobs))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// writeCheckpoint leaves inflight.json as a listener stopped during phase
+// of a deploy from prev to the compose file in dir would.
+func writeCheckpoint(t *testing.T, dir, phase, prev string) string {
+	t.Helper()
+	path := filepath.Join(dir, "inflight.json")
+	data, err := json.Marshal([]checkpoint{{
+		Job:      "interrupted",
+		Services: []string{"web"},
+		Version:  "2",
+		Phase:    phase,
+		Key:      "ci",
+		Source:   sourceAPI,
+		Started:  time.Now().UTC(),
+		Previous: json.RawMessage(prev),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestResumeInterruptedDeploy(t *testing.T) {
+	prev := `{"x-metadata":{"version":"1"},"services":{"web":{"image":"web:1"}}}`
+	tests := []struct {
+		name    string
+		hooks   string
+		outcome string
+		file    string
+	}{
+		{"completes", `{}`, outcomeSucceeded, "2"},
+		{"restores the replaced file", `{"pre_deploy":[{"host":true,"command":"exit 3"}]}`, outcomeRolledBack, "1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := fakeDocker(t)
+			dir := t.TempDir()
+			file := filepath.Join(dir, "compose.json")
+			next := `{"x-metadata":{"version":"2","hooks":` + tt.hooks + `},"services":{"web":{"image":"web:2"}}}`
+			if err := os.WriteFile(file, []byte(next), 0644); err != nil {
+				t.Fatal(err)
+			}
+			path := writeCheckpoint(t, dir, "pull", prev)
+			u := New(docker.NewComposeClient(false))
+			u.file = file
+			u.allowHostHooks = true
+			u.inflight = newInflight(path)
+
+			u.recoverInterrupted(true)
+			last := waitJobs(t, u)
+			if len(last) != 1 || last[0].Type != eventDone || last[0].Status != tt.outcome {
+				t.Fatalf("resumed job ended with %+v, want done %s", last, tt.outcome)
+			}
+			data, err := docker.Load(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v := docker.GetString(data, "x-metadata.version"); v != tt.file {
+				t.Errorf("compose.json has version %s, want %s", v, tt.file)
+			}
+			if _, err := os.Stat(path); !os.IsNotExist(err) {
+				t.Error("inflight.json left behind")
+			}
+			log, err := os.ReadFile(calls)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(log), " pull web") {
+				t.Errorf("docker calls:\n%s\nwant a pull of web", log)
+			}
+		})
+	}
+}
+
+func TestStartDrainsDeploysOnStop(t *testing.T) {
+	calls := fakeDocker(t)
+	t.Setenv("FAKE_UP_DELAY", "1")
+	t.Chdir(t.TempDir())
+	store, err := keys.Load(config.KeysPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := store.Create("ci", []string{keys.ScopeDeploy}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save(); err != nil {
+		t.Fatal(err)
+	}
+	next := `{"x-metadata":{"version":"2"},"services":{"web":{"image":"web:2"}}}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(next))
+	}))
+	defer srv.Close()
+	prev := `{"x-metadata":{"version":"1","url":"` + srv.URL + `"},"services":{"web":{"image":"web:1"}}}`
+	if err := os.WriteFile("compose.json", []byte(prev), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	u := New(docker.NewComposeClient(false))
+	u.listenAddr = addr
+	u.grace = 10 * time.Second
+	u.inflight = newInflight(config.InflightPath)
+
+	// The service's signal handler cancels the context on SIGTERM.
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() { stopped <- u.Start(ctx, "compose.json") }()
+
+	var resp *http.Response
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		resp, err = http.Post("http://"+addr+"/update/"+secret, "application/json", nil)
+		if err == nil || time.Now().After(deadline) {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("update: %s", resp.Status)
+	}
+	if _, err := os.Stat(config.InflightPath); err != nil {
+		t.Fatalf("no checkpoint while the deploy runs: %v", err)
+	}
+	cancel()
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Start did not return")
+	}
+
+	// Start returns only once the deploy in progress has finished.
+	u.jobs.mu.Lock()
+	defer u.jobs.mu.Unlock()
+	for _, j := range u.jobs.jobs {
+		events, _, finished := j.since(0)
+		if last := events[len(events)-1]; !finished || last.Status != outcomeSucceeded {
+			t.Errorf("deploy not finished when Start returned: %+v", last)
+		}
+	}
+	if _, err := os.Stat(config.InflightPath); !os.IsNotExist(err) {
+		t.Error("checkpoint of the drained deploy left behind")
+	}
+	log, err := os.ReadFile(calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(log), " up ") {
+		t.Errorf("docker calls:\n%s\nwant an up", log)
+	}
+}
@@ -0,0 +1,268 @@
+package hotreload
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Types of job events streamed on /jobs/<id>/events.
+const (
+	eventPhase     = "phase"
+	eventPull      = "pull"
+	eventContainer = "container"
+	eventHook      = "hook"
+	eventHealth    = "health"
+	eventLog       = "log"
+	eventDone      = "done"
+)
+
+// Finished jobs are kept for late subscribers until they are older than
+// jobRetention or more than maxJobs jobs exist.
+const (
+	jobRetention = time.Hour
+	maxJobs      = 100
+)
+
+// jobEvent is one progress event of a deploy job.
+type jobEvent struct {
+	Seq       int       `json:"seq"`
+	Type      string    `json:"type"`
+	Time      time.Time `json:"time"`
+	Phase     string    `json:"phase,omitempty"`
+	Service   string    `json:"service,omitempty"`
+	Layer     string    `json:"layer,omitempty"`
+	Container string    `json:"container,omitempty"`
+	Status    string    `json:"status,omitempty"`
+	Progress  string    `json:"progress,omitempty"`
+	Message   string    `json:"message,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// job collects the events of one deploy so they can be replayed to clients
+// that subscribe late. A nil job discards events.
+type job struct {
+	ID      string
+	started time.Time
+
+	mu       sync.Mutex
+	phase    string
+	services map[string]bool
+	events   []jobEvent
+	finished time.Time
+	notify   chan struct{} // closed and replaced whenever an event is added
+}
+
+// emit appends an event, filling in its sequence number and time.
+func (j *job) emit(e jobEvent) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if !j.finished.IsZero() {
+		return
+	}
+	e.Seq = len(j.events) + 1
+	e.Time = time.Now().UTC()
+	if e.Type == eventPhase {
+		j.phase = e.Phase
+	} else if e.Phase == "" {
+		e.Phase = j.phase
+	}
+	j.events = append(j.events, e)
+	if e.Type == eventDone {
+		j.finished = e.Time
+	}
+	close(j.notify)
+	j.notify = make(chan struct{})
+}
+
+// setServices records the services being deployed so pull output can be
+// told apart from layer progress.
+func (j *job) setServices(services []string) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, name := range services {
+		j.services[name] = true
+	}
+}
+
+// setPhase starts a new phase of the deploy.
+func (j *job) setPhase(phase string) { j.emit(jobEvent{Type: eventPhase, Phase: phase}) }
+
+// finish emits the final event with the deploy's outcome.
+func (j *job) finish(status string, err error) {
+	e := jobEvent{Type: eventDone, Status: status}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	j.emit(e)
+}
+
+// since returns the events after seq, a channel closed when more arrive and
+// whether the job has finished.
+func (j *job) since(seq int) ([]jobEvent, <-chan struct{}, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if seq < 0 || seq > len(j.events) {
+		seq = 0
+	}
+	events := append([]jobEvent(nil), j.events[seq:]...)
+	return events, j.notify, !j.finished.IsZero()
+}
+
+var (
+	// progressPattern matches the transferred/total sizes compose prints
+	// while pulling, e.g. "12.5MB/48.1MB".
+	progressPattern = regexp.MustCompile(`[\d.]+\s?[kMGT]?B/[\d.]+\s?[kMGT]?B`)
+	// barPattern matches the progress bar drawn before the sizes.
+	barPattern = regexp.MustCompile(`\[[=> ]*\]`)
+)
+
+// line turns a line of compose or hook output into an event according to
+// the current phase. Compose prints "<layer|service> <status>" while pulling
+// and "Container <name> <status>" while recreating.
+func (j *job) line(s string) {
+	if j == nil {
+		return
+	}
+	fields := strings.Fields(s)
+	j.mu.Lock()
+	phase := j.phase
+	isService := len(fields) > 0 && j.services[fields[0]]
+	j.mu.Unlock()
+
+	switch {
+	case phase == PreDeploy || phase == PostDeploy:
+		j.emit(jobEvent{Type: eventHook, Message: s})
+	case len(fields) >= 3 && fields[0] == "Container":
+		j.emit(jobEvent{Type: eventContainer, Container: fields[1], Status: strings.Join(fields[2:], " ")})
+	case phase == "pull" && len(fields) >= 2:
+		e := jobEvent{Type: eventPull, Progress: progressPattern.FindString(s)}
+		status := barPattern.ReplaceAllString(strings.Join(fields[1:], " "), "")
+		e.Status = strings.TrimSpace(strings.Replace(status, e.Progress, "", 1))
+		if isService {
+			e.Service = fields[0]
+		} else {
+			e.Layer = fields[0]
+		}
+		j.emit(e)
+	default:
+		j.emit(jobEvent{Type: eventLog, Message: s})
+	}
+}
+
+// jobStore keeps the jobs of recent deploys.
+type jobStore struct {
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+// create registers a new job for a deploy of services and prunes old ones.
+func (s *jobStore) create(services []string) *job {
+	j := &job{
+		ID:       uuid.New().String(),
+		started:  time.Now(),
+		services: make(map[string]bool),
+		notify:   make(chan struct{}),
+	}
+	for _, name := range services {
+		j.services[name] = true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.jobs == nil {
+		s.jobs = make(map[string]*job)
+	}
+	s.prune()
+	s.jobs[j.ID] = j
+	return j
+}
+
+// prune drops finished jobs past their retention and, when there are still
+// too many, the oldest finished ones.
+func (s *jobStore) prune() {
+	var oldest *job
+	for id, j := range s.jobs {
+		j.mu.Lock()
+		finished := j.finished
+		j.mu.Unlock()
+		if finished.IsZero() {
+			continue
+		}
+		if time.Since(finished) > jobRetention {
+			delete(s.jobs, id)
+			continue
+		}
+		if oldest == nil || j.started.Before(oldest.started) {
+			oldest = j
+		}
+	}
+	if len(s.jobs) >= maxJobs && oldest != nil {
+		delete(s.jobs, oldest.ID)
+	}
+}
+
+func (s *jobStore) get(id string) (*job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[id]
+	return j, ok
+}
+
+// handleJobEvents streams the events of a job as server-sent events. Events
+// already emitted are replayed first, starting after Last-Event-ID when the
+// client reconnects. The stream ends after the done event.
+func (u *Updater) handleJobEvents(w http.ResponseWriter, r *http.Request, id string) {
+	j, ok := u.jobs.get(id)
+	if !ok {
+		u.unknownEndpoint(w)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	seq, _ := strconv.Atoi(r.Header.Get("Last-Event-ID"))
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(15 * time.Second)
+	defer keepalive.Stop()
+	for {
+		events, more, finished := j.since(seq)
+		for _, e := range events {
+			data, _ := json.Marshal(e)
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Seq, e.Type, data)
+			seq = e.Seq
+		}
+		flusher.Flush()
+		if finished {
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-u.stopping:
+			return
+		case <-more:
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		}
+	}
+}
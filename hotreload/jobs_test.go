@@ -0,0 +1,184 @@
+package hotreload
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/plark-inc/hostship/config"
+	"github.com/plark-inc/hostship/keys"
+)
+
+// sseEvent is an event read back from a job stream.
+type sseEvent struct {
+	id   int
+	name string
+	data jobEvent
+}
+
+// readEvents parses a server-sent event stream until it ends.
+func readEvents(t *testing.T, resp *http.Response) []sseEvent {
+	t.Helper()
+	var events []sseEvent
+	var cur sseEvent
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case line == "":
+			if cur.name != "" {
+				events = append(events, cur)
+			}
+			cur = sseEvent{}
+		case strings.HasPrefix(line, "id: "):
+			cur.id, _ = strconv.Atoi(strings.TrimPrefix(line, "id: "))
+		case strings.HasPrefix(line, "event: "):
+			cur.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &cur.data); err != nil {
+				t.Fatalf("bad data line %q: %v", line, err)
+			}
+		}
+	}
+	return events
+}
+
+// ids returns the ids of events, checking each matches its sequence number.
+func ids(t *testing.T, events []sseEvent) []int {
+	t.Helper()
+	var out []int
+	for _, e := range events {
+		if e.id != e.data.Seq {
+			t.Errorf("id %d does not match seq %d", e.id, e.data.Seq)
+		}
+		out = append(out, e.id)
+	}
+	return out
+}
+
+func TestJobEventsReplay(t *testing.T) {
+	t.Chdir(t.TempDir())
+	store, err := keys.Load(config.KeysPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := store.Create("viewer", []string{keys.ScopeStatus}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deployOnly, err := store.Create("ci", []string{keys.ScopeDeploy}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rollbackOnly, err := store.Create("ops", []string{keys.ScopeRollback}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	u := New(nil)
+	srv := httptest.NewServer(http.HandlerFunc(u.serve))
+	defer srv.Close()
+
+	j := u.jobs.create([]string{"web"})
+	j.setPhase("pull")
+	j.line("web Pulling")
+	j.line("a1b2c3 Downloading [==>   ] 1.2MB/4.8MB")
+	j.setPhase("up")
+	j.line("Container hostship-web-1 Recreated")
+
+	get := func(key, lastID string) (*http.Response, error) {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+"/jobs/"+j.ID+"/events", nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+key)
+		if lastID != "" {
+			req.Header.Set("Last-Event-ID", lastID)
+		}
+		return http.DefaultClient.Do(req)
+	}
+
+	resp, err := get(rollbackOnly, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("rollback-only key: status %d, want 403", resp.StatusCode)
+	}
+
+	// A client that reconnects while the job runs gets the events after
+	// Last-Event-ID, then the live ones until done. CI follows the deploy
+	// with the deploy-only key it triggered it with.
+	resp, err = get(deployOnly, "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	done := make(chan []sseEvent)
+	go func() {
+		defer resp.Body.Close()
+		done <- readEvents(t, resp)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	j.emit(jobEvent{Type: eventHealth, Service: "web", Status: "healthy"})
+	j.finish(outcomeSucceeded, nil)
+	var live []sseEvent
+	select {
+	case live = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream did not end after the done event")
+	}
+	if got, want := ids(t, live), []int{4, 5, 6, 7}; !slices.Equal(got, want) {
+		t.Errorf("resumed ids = %v, want %v", got, want)
+	}
+	if last := live[len(live)-1]; last.name != eventDone || last.data.Status != outcomeSucceeded {
+		t.Errorf("last event %+v, want done %s", last, outcomeSucceeded)
+	}
+	if e := live[1]; e.name != eventContainer || e.data.Container != "hostship-web-1" || e.data.Phase != "up" {
+		t.Errorf("container event %+v", e)
+	}
+
+	// Finished jobs replay everything to new subscribers; an out of range
+	// Last-Event-ID starts over.
+	for _, tt := range []struct {
+		lastID string
+		want   []int
+	}{
+		{"", []int{1, 2, 3, 4, 5, 6, 7}},
+		{"6", []int{7}},
+		{"7", nil},
+		{"99", []int{1, 2, 3, 4, 5, 6, 7}},
+		{"junk", []int{1, 2, 3, 4, 5, 6, 7}},
+	} {
+		resp, err := get(status, tt.lastID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		events := readEvents(t, resp)
+		resp.Body.Close()
+		if got := ids(t, events); !slices.Equal(got, tt.want) {
+			t.Errorf("Last-Event-ID %q: ids %v, want %v", tt.lastID, got, tt.want)
+		}
+	}
+
+	resp, err = http.Get(srv.URL + "/jobs/unknown/events?key=" + status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown job: status %d, want 404", resp.StatusCode)
+	}
+}
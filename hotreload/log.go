@@ -0,0 +1,23 @@
+package hotreload
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/plark-inc/hostship/systemd"
+)
+
+// jobID returns the id of j for log records, or "" without a job.
+func jobID(j *job) string {
+	if j == nil {
+		return ""
+	}
+	return j.ID
+}
+
+// setStatus shows status in `systemctl status` when running under systemd.
+func setStatus(format string, args ...any) {
+	if err := systemd.SetStatus(fmt.Sprintf(format, args...)); err != nil {
+		slog.Warn("notify systemd", "err", err)
+	}
+}
@@ -0,0 +1,97 @@
+package hotreload
+
+import (
+	"io"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/plark-inc/hostship/docker"
+	"github.com/plark-inc/hostship/metrics"
+)
+
+// Deploy outcomes recorded in hostship_deploys_total.
+const (
+	outcomeSucceeded   = "succeeded"
+	outcomeFailed      = "failed"
+	outcomeRolledBack  = "rolled_back"
+	outcomeUnchanged   = "unchanged"
+	outcomeInterrupted = "interrupted"
+)
+
+// updaterMetrics groups the metrics exported by the listener on /metrics.
+type updaterMetrics struct {
+	registry     *metrics.Registry
+	deploys      *metrics.CounterVec
+	phases       *metrics.HistogramVec
+	lastSuccess  *metrics.GaugeVec
+	composeInfo  *metrics.GaugeVec
+	authFailures *metrics.CounterVec
+	rejected     *metrics.CounterVec
+	lockouts     *metrics.CounterVec
+}
+
+func newUpdaterMetrics(u *Updater) *updaterMetrics {
+	m := &updaterMetrics{
+		registry: metrics.NewRegistry(),
+		deploys: metrics.NewCounterVec("hostship_deploys_total",
+			"Deploys handled by the listener by outcome.", "outcome"),
+		phases: metrics.NewHistogramVec("hostship_deploy_phase_duration_seconds",
+			"Duration of each deploy phase.", metrics.DefBuckets, "phase"),
+		lastSuccess: metrics.NewGaugeVec("hostship_last_successful_deploy_timestamp_seconds",
+			"Unix time of the last successful deploy."),
+		composeInfo: metrics.NewGaugeVec("hostship_compose_info",
+			"Version of the compose file currently deployed.", "version"),
+		authFailures: metrics.NewCounterVec("hostship_auth_failures_total",
+			"Rejected update requests by reason.", "reason"),
+		rejected: metrics.NewCounterVec("hostship_rejected_requests_total",
+			"Update requests refused before authentication by reason (source, lockout, rate).", "reason"),
+		lockouts: metrics.NewCounterVec("hostship_lockouts_total",
+			"Remote addresses locked out after repeated invalid keys."),
+	}
+	m.registry.Register(m.deploys, m.phases, m.lastSuccess, m.composeInfo, m.authFailures, m.rejected, m.lockouts,
+		metrics.CollectorFunc(u.writeContainerStates))
+	return m
+}
+
+// observePhase records the time elapsed since start for phase.
+func (m *updaterMetrics) observePhase(phase string, start time.Time) {
+	m.phases.Observe(time.Since(start).Seconds(), phase)
+}
+
+// setVersion records the compose version currently deployed.
+func (m *updaterMetrics) setVersion(cfg []byte) {
+	m.composeInfo.Reset()
+	m.composeInfo.Set(1, docker.GetString(cfg, "x-metadata.version"))
+}
+
+// writeContainerStates queries docker at scrape time and exports one sample
+// per container of the stack, including blue/green projects.
+func (u *Updater) writeContainerStates(w io.Writer) {
+	cfg, err := docker.Load(u.file)
+	if err != nil {
+		return
+	}
+	bgs, _ := parseBlueGreen(cfg)
+	projects := docker.ColorProjects(project, slices.Sorted(maps.Keys(bgs)))
+	var samples []metrics.Sample
+	for _, p := range projects {
+		states, err := u.compose.States(u.file, p)
+		if err != nil {
+			continue
+		}
+		for _, s := range states {
+			state := s.State
+			if s.Health != "" {
+				state = s.Health
+			}
+			samples = append(samples, metrics.Sample{
+				Labels: []string{"service", "container", "state"},
+				Values: []string{s.Service, s.Name, state},
+				Value:  1,
+			})
+		}
+	}
+	metrics.WriteGauge(w, "hostship_container_state",
+		"Containers of the stack by service and state (health when available).", samples)
+}
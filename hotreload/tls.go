@@ -0,0 +1,129 @@
+package hotreload
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// reloadCheckInterval bounds how often handshakes look at the modification
+// times of the certificate files.
+const reloadCheckInterval = time.Second
+
+// certReloader serves the certificate and client CA pool from disk and
+// reloads them whenever one of the files changes, so renewed certificates are
+// picked up without restarting the listener.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+
+	mu      sync.Mutex
+	checked time.Time
+	modTime time.Time
+	cert    *tls.Certificate
+	pool    *x509.CertPool
+}
+
+func newCertReloader(certFile, keyFile, caFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile, caFile: caFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// latestModTime returns the most recent modification time of the files.
+func (r *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, f := range []string{r.certFile, r.keyFile, r.caFile} {
+		if f == "" {
+			continue
+		}
+		fi, err := os.Stat(f)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// reload reads the files again when they changed since the last load. Once
+// loaded, the files are checked at most every reloadCheckInterval.
+func (r *certReloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	if r.cert != nil && now.Sub(r.checked) < reloadCheckInterval {
+		return nil
+	}
+	r.checked = now
+	mod, err := r.latestModTime()
+	if err != nil {
+		return err
+	}
+	if r.cert != nil && !mod.After(r.modTime) {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("load TLS key pair: %w", err)
+	}
+	var pool *x509.CertPool
+	if r.caFile != "" {
+		pem, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return fmt.Errorf("read client CA: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", r.caFile)
+		}
+	}
+	if r.cert != nil {
+		slog.Info("reloaded TLS certificates")
+	}
+	r.cert, r.pool, r.modTime = &cert, pool, mod
+	return nil
+}
+
+// current returns the loaded certificate and pool, reloading them first when
+// the files changed. A failed reload keeps serving the previous pair.
+func (r *certReloader) current() (*tls.Certificate, *x509.CertPool) {
+	if err := r.reload(); err != nil {
+		slog.Error("reload TLS certificates", "err", err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cert, r.pool
+}
+
+// config returns the server TLS configuration. Each handshake gets the
+// current certificate and pool from a single reload check. Client
+// certificates are verified when presented; the update endpoint rejects
+// requests without one when a client CA is configured, while health probes
+// stay reachable.
+func (r *certReloader) config() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, pool := r.current()
+			cfg := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+			}
+			if pool != nil {
+				cfg.ClientCAs = pool
+				cfg.ClientAuth = tls.VerifyClientCertIfGiven
+			}
+			return cfg, nil
+		},
+	}
+}
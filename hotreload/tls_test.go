@@ -0,0 +1,199 @@
+package hotreload
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/plark-inc/hostship/config"
+	"github.com/plark-inc/hostship/keys"
+)
+
+// testCert is a certificate with its key, issued by parent or self-signed
+// when parent is nil.
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCert(t *testing.T, name string, parent *testCert, usage x509.ExtKeyUsage) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{usage},
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// write stores the certificate and key as PEM files and returns their paths.
+func (c *testCert) write(t *testing.T, dir, name string) (string, string) {
+	t.Helper()
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPath, keyPath := filepath.Join(dir, name+".pem"), filepath.Join(dir, name+".key")
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certPath, keyPath
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+func TestCertReloaderReload(t *testing.T) {
+	dir := t.TempDir()
+	first := newTestCert(t, "first", nil, x509.ExtKeyUsageServerAuth)
+	certPath, keyPath := first.write(t, dir, "server")
+	r, err := newCertReloader(certPath, keyPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second := newTestCert(t, "second", nil, x509.ExtKeyUsageServerAuth)
+	second.write(t, dir, "server")
+	later := time.Now().Add(time.Minute)
+	for _, p := range []string{certPath, keyPath} {
+		if err := os.Chtimes(p, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if cert, _ := r.current(); cert.Leaf.Subject.CommonName != "first" {
+		t.Errorf("files checked again within %s: serving %s", reloadCheckInterval, cert.Leaf.Subject.CommonName)
+	}
+
+	r.mu.Lock()
+	r.checked = time.Time{}
+	r.mu.Unlock()
+	if cert, _ := r.current(); cert.Leaf.Subject.CommonName != "second" {
+		t.Errorf("serving %s after the files changed, want second", cert.Leaf.Subject.CommonName)
+	}
+
+	// A broken pair keeps the previous certificate in service.
+	if err := os.WriteFile(keyPath, []byte("not a key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	latest := later.Add(time.Minute)
+	if err := os.Chtimes(keyPath, latest, latest); err != nil {
+		t.Fatal(err)
+	}
+	r.mu.Lock()
+	r.checked = time.Time{}
+	r.mu.Unlock()
+	if cert, _ := r.current(); cert.Leaf.Subject.CommonName != "second" {
+		t.Errorf("serving %s after a broken reload, want second", cert.Leaf.Subject.CommonName)
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	t.Chdir(t.TempDir())
+	store, err := keys.Load(config.KeysPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := store.Create("viewer", []string{keys.ScopeStatus}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	ca := newTestCert(t, "clients", nil, x509.ExtKeyUsageClientAuth)
+	caPath, _ := ca.write(t, ".", "ca")
+	server := newTestCert(t, "server", nil, x509.ExtKeyUsageServerAuth)
+	certPath, keyPath := server.write(t, ".", "server")
+	r, err := newCertReloader(certPath, keyPath, caPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := New(nil)
+	u.tls = r
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(u.serve))
+	srv.TLS = r.config()
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server.cert)
+	// client presents cert whatever CAs the server asks for.
+	client := func(cert *tls.Certificate) *http.Client {
+		cfg := &tls.Config{RootCAs: roots}
+		if cert != nil {
+			cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) { return cert, nil }
+		}
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+	}
+	trusted := newTestCert(t, "ci", ca, x509.ExtKeyUsageClientAuth).tlsCertificate()
+	stranger := newTestCert(t, "stranger", nil, x509.ExtKeyUsageClientAuth).tlsCertificate()
+	events := srv.URL + "/jobs/unknown/events?key=" + secret
+
+	tests := []struct {
+		name   string
+		client *http.Client
+		url    string
+		status int
+	}{
+		{"health probe without a certificate", client(nil), srv.URL + "/healthz", http.StatusOK},
+		{"key without a certificate", client(nil), events, http.StatusUnauthorized},
+		{"key with a trusted certificate", client(&trusted), events, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		resp, err := tt.client.Get(tt.url)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, resp.StatusCode, tt.status)
+		}
+	}
+
+	// A certificate from another CA fails the handshake.
+	if resp, err := client(&stranger).Get(events); err == nil {
+		resp.Body.Close()
+		t.Errorf("certificate from another CA: status %d, want a handshake failure", resp.StatusCode)
+	}
+}
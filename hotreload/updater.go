@@ -5,67 +5,344 @@ package hotreload
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
+	"github.com/plark-inc/hostship/audit"
 	"github.com/plark-inc/hostship/config"
 	"github.com/plark-inc/hostship/docker"
+	"github.com/plark-inc/hostship/keys"
+	"github.com/plark-inc/hostship/logs"
+	"github.com/plark-inc/hostship/notify"
+	"github.com/plark-inc/hostship/systemd"
 )
 
+// project is the compose project name used for the stack.
+const project = "hostship"
+
+// defaultListenAddr is the address of the update endpoint unless
+// overridden with --listen-addr or HOSTSHIP_LISTEN_ADDR.
+const defaultListenAddr = config.DefaultListenAddr
+
+// Options configures the hot-reload listener.
+type Options struct {
+	// ListenAddr is the address of the update endpoint. Defaults to
+	// HOSTSHIP_LISTEN_ADDR, then :8080.
+	ListenAddr string
+	// AllowHostHooks permits hooks declared with "host": true to run
+	// commands directly on the host.
+	AllowHostHooks bool
+	// MetricsAddr enables the Prometheus /metrics endpoint. When it equals
+	// the update address the endpoint is served alongside /update.
+	MetricsAddr string
+	// TLSCert and TLSKey enable HTTPS on the update endpoint. They default to
+	// HOSTSHIP_TLS_CERT and HOSTSHIP_TLS_KEY from the environment or .env.
+	TLSCert string
+	TLSKey  string
+	// Guard configures rate limiting, lockouts and the source allowlist of
+	// the update endpoint.
+	Guard GuardOptions
+	// TLSClientCA requires update requests to present a client certificate
+	// signed by this CA (mTLS). Defaults to HOSTSHIP_TLS_CLIENT_CA.
+	TLSClientCA string
+	// WatchImages, when set, compares the image digests of the running
+	// services with their registry at this interval and redeploys the
+	// services whose image changed. Defaults to HOSTSHIP_WATCH_IMAGES.
+	WatchImages time.Duration
+	// ComposeURLs lists the URL prefixes update requests may deploy a
+	// compose file from with compose_url. Defaults to
+	// HOSTSHIP_COMPOSE_URL_PREFIXES; empty rejects compose_url.
+	ComposeURLs []string
+	// GracePeriod bounds how long a stopping listener waits for deploys in
+	// progress. Deploys still running afterwards are reported, and resumed
+	// when Resume is set, on the next start.
+	GracePeriod time.Duration
+	Resume      bool
+	// LogArchive, when positive, archives the logs of containers before a
+	// deploy recreates them, keeping this many files per service. Defaults
+	// to HOSTSHIP_LOG_ARCHIVE.
+	LogArchive int
+}
+
 // Load the configuration and starts the hot-reload HTTP server.
-// Docker must already be installed and the container running.
-func StartUpdateServer(verbose bool) error {
-	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+// Docker must already be installed and the container running. SIGINT and
+// SIGTERM stop the listener gracefully; SIGHUP reloads .env.
+func StartUpdateServer(opts Options) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
-	upd := New(docker.NewComposeClient(false, verbose), verbose)
+	// Tag every record of the listener with the compose project it manages.
+	slog.SetDefault(slog.Default().With("app", project))
+	upd := New(docker.NewComposeClient(false))
+	upd.allowHostHooks = opts.AllowHostHooks
+	upd.audit = audit.New(config.AuditPath)
+	upd.inflight = newInflight(config.InflightPath)
+	upd.metricsAddr = opts.MetricsAddr
+	upd.grace = opts.GracePeriod
+	upd.resume = opts.Resume
+	config.LoadEnv()
+	g, err := newGuard(opts.Guard)
+	if err != nil {
+		return err
+	}
+	upd.guard = g
+	upd.listenAddr = firstNonEmpty(opts.ListenAddr, os.Getenv("HOSTSHIP_LISTEN_ADDR"), defaultListenAddr)
+	if err := upd.applyEnv(opts); err != nil {
+		return err
+	}
+	keep := opts.LogArchive
+	if keep == 0 && os.Getenv("HOSTSHIP_LOG_ARCHIVE") != "" {
+		if keep, err = strconv.Atoi(os.Getenv("HOSTSHIP_LOG_ARCHIVE")); err != nil {
+			return fmt.Errorf("invalid HOSTSHIP_LOG_ARCHIVE: %w", err)
+		}
+	}
+	if keep > 0 {
+		upd.archive = &logs.Archive{Dir: config.LogArchiveDir, Keep: keep}
+	}
+	upd.watchImages = opts.WatchImages
+	if upd.watchImages == 0 && os.Getenv("HOSTSHIP_WATCH_IMAGES") != "" {
+		d, err := time.ParseDuration(os.Getenv("HOSTSHIP_WATCH_IMAGES"))
+		if err != nil {
+			return fmt.Errorf("invalid HOSTSHIP_WATCH_IMAGES: %w", err)
+		}
+		upd.watchImages = d
+	}
+	certFile := firstNonEmpty(opts.TLSCert, os.Getenv("HOSTSHIP_TLS_CERT"))
+	keyFile := firstNonEmpty(opts.TLSKey, os.Getenv("HOSTSHIP_TLS_KEY"))
+	caFile := firstNonEmpty(opts.TLSClientCA, os.Getenv("HOSTSHIP_TLS_CLIENT_CA"))
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return fmt.Errorf("TLS requires both a certificate and a key")
+		}
+		reloader, err := newCertReloader(certFile, keyFile, caFile)
+		if err != nil {
+			return err
+		}
+		upd.tls = reloader
+	} else if caFile != "" {
+		return fmt.Errorf("client certificate verification requires TLS")
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				upd.reload(opts)
+			}
+		}
+	}()
 	return upd.Start(ctx, config.Path)
 }
 
+// settings holds the configuration that can change at runtime through
+// SIGHUP.
+type settings struct {
+	notifier    *notify.Notifier
+	composeURLs []string
+}
+
+// current returns the settings in effect.
+func (u *Updater) current() *settings {
+	if s := u.settings.Load(); s != nil {
+		return s
+	}
+	return &settings{}
+}
+
+// applyEnv applies the options that fall back to .env: notification
+// targets, the source allowlist and the compose URL allowlist. Flags take
+// precedence over the environment.
+func (u *Updater) applyEnv(opts Options) error {
+	cidrs := opts.Guard.AllowCIDRs
+	if len(cidrs) == 0 && os.Getenv("HOSTSHIP_ALLOW_CIDRS") != "" {
+		cidrs = strings.Split(os.Getenv("HOSTSHIP_ALLOW_CIDRS"), ",")
+	}
+	allow, err := parseCIDRs(cidrs)
+	if err != nil {
+		return err
+	}
+	composeURLs := opts.ComposeURLs
+	if len(composeURLs) == 0 && os.Getenv("HOSTSHIP_COMPOSE_URL_PREFIXES") != "" {
+		composeURLs = strings.Split(os.Getenv("HOSTSHIP_COMPOSE_URL_PREFIXES"), ",")
+	}
+	u.guard.setAllow(allow)
+	u.settings.Store(&settings{notifier: notify.FromEnv(), composeURLs: composeURLs})
+	return nil
+}
+
+// reload reads .env again and applies it without dropping connections or
+// deploys in progress. Keys and webhook secrets are read per request and
+// TLS certificates reload when their files change, so they need nothing
+// here.
+func (u *Updater) reload(opts Options) {
+	_, _ = systemd.Notify("RELOADING=1")
+	defer func() { _, _ = systemd.Notify("READY=1") }()
+	config.ReloadEnv()
+	if err := u.applyEnv(opts); err != nil {
+		slog.Error("reload configuration", "err", err)
+		return
+	}
+	if u.tls != nil {
+		if err := u.tls.reload(); err != nil {
+			slog.Error("reload configuration", "err", err)
+			return
+		}
+	}
+	slog.Info("configuration reloaded")
+}
+
 type Updater struct {
-	compose *docker.ComposeClient
-	file    string
-	verbose bool
+	compose        *docker.ComposeClient
+	file           string
+	allowHostHooks bool
+	settings       atomic.Pointer[settings]
+	metrics        *updaterMetrics
+	listenAddr     string
+	metricsAddr    string
+	deploys        deployTracker
+	deploying      sync.Mutex
+	tls            *certReloader
+	audit          *audit.Log
+	guard          *guard
+	watchImages    time.Duration
+	jobs           jobStore
+	inflight       *inflight
+	archive        *logs.Archive
+	grace          time.Duration
+	resume         bool
+	stopping       chan struct{}
+	stopOnce       sync.Once
 }
 
 // New creates a new Updater instance using the provided Docker compose client.
 // The returned updater is ready to be started.
-func New(c *docker.ComposeClient, verbose bool) *Updater {
-	return &Updater{
-		compose: c,
-		verbose: verbose,
+func New(c *docker.ComposeClient) *Updater {
+	u := &Updater{
+		compose:    c,
+		listenAddr: defaultListenAddr,
+		stopping:   make(chan struct{}),
 	}
+	u.metrics = newUpdaterMetrics(u)
+	u.guard, _ = newGuard(DefaultGuardOptions())
+	return u
 }
 
-// Start launches the update HTTP server on port 8080
+// Start launches the update HTTP server on port 8080 and, when configured,
+// the metrics server on its own address.
 func (u *Updater) Start(ctx context.Context, cfgPath string) error {
 	u.file = cfgPath
+	if cfg, err := docker.Load(cfgPath); err == nil {
+		u.metrics.setVersion(cfg)
+	}
+	if u.inflight != nil {
+		u.recoverInterrupted(u.resume)
+	}
 
-	// Start the HTTP server in a goroutine and report any error via a channel
-	srv := &http.Server{Addr: ":8080", Handler: http.HandlerFunc(u.handle)}
-	errCh := make(chan error, 1)
-	go func() {
-		errCh <- srv.ListenAndServe()
-	}()
-	if u.verbose {
-		fmt.Println("listening on :8080")
+	updateSrv := &http.Server{Addr: u.listenAddr, Handler: http.HandlerFunc(u.serve)}
+	if u.tls != nil {
+		updateSrv.TLSConfig = u.tls.config()
+	}
+	if u.watchImages > 0 {
+		go u.watch(ctx, u.watchImages)
+	}
+
+	servers := []*http.Server{updateSrv}
+	if u.metricsAddr != "" && u.metricsAddr != u.listenAddr {
+		mux := http.NewServeMux()
+		mux.Handle("GET /metrics", u.metrics.registry.Handler())
+		servers = append(servers, &http.Server{Addr: u.metricsAddr, Handler: mux})
+	}
+
+	// Bind every address before serving so readiness is only reported once
+	// requests can be accepted.
+	listeners := make([]net.Listener, 0, len(servers))
+	for _, srv := range servers {
+		ln, err := net.Listen("tcp", srv.Addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return err
+		}
+		listeners = append(listeners, ln)
+	}
+
+	// Start the HTTP servers in goroutines and report any error via a channel
+	errCh := make(chan error, len(servers))
+	for i, srv := range servers {
+		go func() {
+			if srv.TLSConfig != nil {
+				errCh <- srv.ServeTLS(listeners[i], "", "")
+				return
+			}
+			errCh <- srv.Serve(listeners[i])
+		}()
+		slog.Debug("listening", "addr", srv.Addr)
+	}
+	if err := systemd.Ready(); err != nil {
+		slog.Warn("notify systemd", "err", err)
+	}
+	setStatus("idle")
+	// Ping the watchdog while the update listener answers /healthz, and
+	// keep pinging while deploys drain during shutdown.
+	stopWatchdog := make(chan struct{})
+	defer close(stopWatchdog)
+	go systemd.Watchdog(stopWatchdog, u.alive(listeners[0].Addr()))
+
+	// shutdown stops accepting requests, ends event streams and waits up to
+	// the grace period for requests and deploys in progress. Deploys that
+	// outlive it keep their checkpoint for the next start.
+	shutdown := func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), u.grace)
+		defer cancel()
+		_, _ = systemd.Notify("STOPPING=1")
+		u.stopOnce.Do(func() { close(u.stopping) })
+		var err error
+		for _, srv := range servers {
+			if sErr := srv.Shutdown(ctx); sErr != nil && err == nil {
+				err = sErr
+			}
+		}
+		if _, busy := u.deploys.oldest(); busy {
+			setStatus("stopping: waiting for deploys in progress")
+			slog.Info("waiting for deploys in progress")
+		}
+		if !u.deploys.wait(ctx) {
+			slog.Warn("grace period expired with deploys in progress; they will be reported on next start")
+		}
+		return err
 	}
 
 	select {
 	case err := <-errCh:
 		if err != nil && err != http.ErrServerClosed {
-			fmt.Println("listen error:", err)
+			slog.Error("listen", "err", err)
 		}
+		_ = shutdown()
 		return err
 	case <-ctx.Done():
-		err := srv.Shutdown(context.Background())
-		if srvErr := <-errCh; srvErr != nil && srvErr != http.ErrServerClosed {
-			fmt.Println("listen error:", srvErr)
-			return srvErr
+		err := shutdown()
+		for range servers {
+			if srvErr := <-errCh; srvErr != nil && srvErr != http.ErrServerClosed {
+				slog.Error("listen", "err", srvErr)
+				return srvErr
+			}
 		}
 		return err
 	}
@@ -73,101 +350,302 @@ func (u *Updater) Start(ctx context.Context, cfgPath string) error {
 
 // handle processes incoming update requests.
 func (u *Updater) handle(w http.ResponseWriter, r *http.Request) {
-	if u.verbose {
-		fmt.Printf("%s %s\n", r.Method, r.URL.Path)
+	slog.Debug("request", "method", r.Method, "path", redactPath(r.URL.Path))
+	if r.Method == http.MethodGet {
+		switch {
+		case r.URL.Path == "/healthz":
+			u.healthz(w)
+			return
+		case r.URL.Path == "/readyz":
+			u.readyz(w)
+			return
+		case r.URL.Path == "/metrics" && u.metricsAddr == u.listenAddr:
+			u.metrics.registry.Handler().ServeHTTP(w, r)
+			return
+		case strings.HasPrefix(r.URL.Path, "/jobs/"):
+			parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+			if len(parts) != 3 || parts[2] != "events" {
+				u.unknownEndpoint(w)
+				return
+			}
+			if !u.admit(w, r, true) {
+				return
+			}
+			secret := firstNonEmpty(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "), r.URL.Query().Get("key"))
+			// The key that triggered a deploy may follow it, so deploy
+			// keys are accepted as well as status keys.
+			if _, ok := u.authenticate(w, r, secret, keys.ScopeStatus, keys.ScopeDeploy); ok {
+				u.handleJobEvents(w, r, parts[1])
+			}
+			return
+		}
 	}
 	if r.Method != http.MethodPost {
 		u.unknownEndpoint(w)
 		return
 	}
 	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
-	if len(parts) != 2 || parts[0] != "update" {
-		if len(parts) == 1 && parts[0] == "update" {
+	if len(parts) == 2 && parts[0] == "webhooks" {
+		// Forges and registries call from the internet, so only the lockout
+		// and rate limit apply; the signature or token authenticates the
+		// request.
+		switch parts[1] {
+		case sourceGitHub, sourceGitLab:
+			if u.admit(w, r, false) {
+				u.handleWebhook(w, r, parts[1])
+			}
+			return
+		case sourceRegistry:
+			if u.admit(w, r, false) {
+				u.handleRegistryWebhook(w, r)
+			}
+			return
+		}
+	}
+	scope, ok := map[string]string{"update": keys.ScopeDeploy, "rollback": keys.ScopeRollback}[parts[0]]
+	if ok && !u.admit(w, r, true) {
+		return
+	}
+	if len(parts) != 2 || !ok {
+		if len(parts) == 1 && ok {
+			u.metrics.authFailures.Inc("missing_key")
+			u.failed(r)
 			u.missingKey(w)
 		} else {
 			u.unknownEndpoint(w)
 		}
 		return
 	}
-	key := parts[1]
-	loadEnv()
-	raw := os.Getenv("DEPLOY_URL")
-	if raw == "" {
-		u.deployURLError(w, fmt.Errorf("DEPLOY_URL not set"))
+	name, ok := u.authenticate(w, r, parts[1], scope)
+	if !ok {
+		return
+	}
+	if scope == keys.ScopeRollback {
+		u.handleRollback(w, r, name)
 		return
 	}
-	uParsed, err := url.Parse(raw)
+	u.handleUpdate(w, r, name)
+}
+
+// authenticate checks the client certificate when mTLS is configured and
+// the key secret against the key store for one of scopes. It writes the
+// rejection and returns false when the request must not proceed.
+func (u *Updater) authenticate(w http.ResponseWriter, r *http.Request, secret string, scopes ...string) (string, bool) {
+	if u.tls != nil && u.tls.caFile != "" && (r.TLS == nil || len(r.TLS.PeerCertificates) == 0) {
+		u.metrics.authFailures.Inc("missing_client_cert")
+		u.missingClientCert(w)
+		return "", false
+	}
+	if secret == "" {
+		u.metrics.authFailures.Inc("missing_key")
+		u.failed(r)
+		u.missingKey(w)
+		return "", false
+	}
+	config.LoadEnv()
+	store, err := keys.Load(config.KeysPath)
 	if err != nil {
 		u.deployURLError(w, err)
-		return
+		return "", false
 	}
-	envParts := strings.Split(strings.Trim(uParsed.Path, "/"), "/")
-	if len(envParts) != 2 || envParts[0] != "update" {
-		u.deployURLError(w, fmt.Errorf("unexpected path %q", uParsed.Path))
-		return
+	if len(store.Keys) == 0 {
+		u.deployURLError(w, fmt.Errorf("DEPLOY_URL not set"))
+		return "", false
 	}
-	if key != envParts[1] {
-		u.invalidKey(w)
-		return
+	name, err := store.Authenticate(secret, scopes...)
+	if err != nil {
+		info(r).key = name
+		u.metrics.authFailures.Inc(authFailureReason(err))
+		if errors.Is(err, keys.ErrInvalid) {
+			u.failed(r)
+		}
+		if name != "" {
+			slog.Debug("key rejected", "key", name, "err", err)
+		}
+		u.invalidKey(w, err)
+		return "", false
 	}
-	u.handleUpdate(w)
+	info(r).key = name
+	u.guard.succeed(remoteHost(r))
+	slog.Debug("authenticated", "key", name)
+	return name, true
 }
 
-// handleUpdate downloads the compose file from the URL specified in x-metadata
-// and restarts all services if updates are available.
-func (u *Updater) handleUpdate(w http.ResponseWriter) {
-	cfg, err := docker.Load(u.file)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+// authFailureReason maps a key error to the reason label of the
+// hostship_auth_failures_total metric.
+func authFailureReason(err error) string {
+	switch {
+	case errors.Is(err, keys.ErrExpired):
+		return "expired_key"
+	case errors.Is(err, keys.ErrScope):
+		return "scope"
 	}
-	url := docker.GetString(cfg, "x-metadata.url")
-	if url == "" {
-		http.Error(w, "missing x-metadata.url", http.StatusInternalServerError)
-		return
+	return "invalid_key"
+}
+
+// updateRequest is the optional JSON body accepted by the update endpoint.
+type updateRequest struct {
+	Services []string `json:"services"`
+	DryRun   bool     `json:"dry_run"`
+	// ComposeURL deploys a compose file other than x-metadata.url. It must
+	// be under one of the allowed prefixes and requires Version.
+	ComposeURL string `json:"compose_url"`
+	// Version must match x-metadata.version of the downloaded file.
+	Version string `json:"version"`
+	// Images overrides image tags by service, e.g. {"web": "1.4.2"}.
+	Images  map[string]string `json:"images"`
+	Message string            `json:"message"`
+	Commit  string            `json:"commit"`
+	// Async answers with the job as soon as the deploy is queued.
+	Async bool `json:"async"`
+}
+
+// maxMessageLen bounds the deploy message kept in the audit log.
+const maxMessageLen = 1024
+
+// validate checks the fields of the body that deploy does not.
+func (req updateRequest) validate() error {
+	if req.ComposeURL != "" && req.Version == "" {
+		return fmt.Errorf("version is required when compose_url is set")
 	}
-	req, err := http.NewRequest(http.MethodGet, url, nil)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	if len(req.Message) > maxMessageLen {
+		return fmt.Errorf("message longer than %d bytes", maxMessageLen)
+	}
+	if req.Commit != "" {
+		if len(req.Commit) > 64 || strings.Trim(req.Commit, "0123456789abcdefABCDEF") != "" {
+			return fmt.Errorf("commit must be a hexadecimal SHA")
+		}
+	}
+	return nil
+}
+
+// parseUpdateRequest reads the optional service selection and dry-run flag from
+// the query string (?services=a,b or repeated ?service=a, ?dry_run=true) or
+// from a JSON request body.
+func parseUpdateRequest(r *http.Request) (updateRequest, error) {
+	var req updateRequest
+	if r.Body != nil {
+		body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			return req, err
+		}
+		if len(strings.TrimSpace(string(body))) > 0 {
+			if err := json.Unmarshal(body, &req); err != nil {
+				return req, fmt.Errorf("invalid request body: %w", err)
+			}
+		}
+	}
+	q := r.URL.Query()
+	for _, v := range append(q["services"], q["service"]...) {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				req.Services = append(req.Services, name)
+			}
+		}
+	}
+	if v := q.Get("dry_run"); v != "" {
+		dry, err := strconv.ParseBool(v)
+		if err != nil {
+			return req, fmt.Errorf("invalid dry_run: %w", err)
+		}
+		req.DryRun = dry
+	}
+	return req, nil
+}
+
+// handleUpdate runs a deploy for an authenticated update request and
+// responds with its result. See deploy for the pipeline itself.
+func (u *Updater) handleUpdate(w http.ResponseWriter, r *http.Request, keyName string) {
+	upReq, err := parseUpdateRequest(r)
+	if err == nil {
+		err = upReq.validate()
 	}
-	req.Header.Set("Cache-Control", "no-cache")
-	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		u.badRequest(w, err)
 		return
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		http.Error(w, fmt.Sprintf("%s: %s", url, resp.Status), http.StatusInternalServerError)
+	if upReq.ComposeURL != "" && !urlAllowed(upReq.ComposeURL, u.current().composeURLs) {
+		info(r).outcome = "forbidden_compose_url"
+		u.forbiddenComposeURL(w)
 		return
 	}
-	data, err := io.ReadAll(resp.Body)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	res, err := u.deploy(deployRequest{
+		Services:   upReq.Services,
+		DryRun:     upReq.DryRun,
+		ComposeURL: upReq.ComposeURL,
+		Version:    upReq.Version,
+		Images:     upReq.Images,
+		Message:    upReq.Message,
+		Commit:     upReq.Commit,
+		Async:      upReq.Async,
+		Key:        keyName,
+		Source:     sourceAPI,
+	})
+	u.writeDeployResult(w, r, res, err)
+}
+
+// handleRollback deploys the compose file that was in place before the last
+// deploy for an authenticated rollback request. The body accepts the same
+// fields as an update except those selecting what to deploy.
+func (u *Updater) handleRollback(w http.ResponseWriter, r *http.Request, keyName string) {
+	req, err := parseUpdateRequest(r)
+	if err == nil {
+		err = req.validate()
 	}
-	if _, err := docker.ServiceNames(data); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err == nil && (req.ComposeURL != "" || req.Version != "" || len(req.Images) > 0) {
+		err = fmt.Errorf("compose_url, version and images cannot be used with a rollback")
+	}
+	if err != nil {
+		u.badRequest(w, err)
 		return
 	}
-	if err := docker.Save(u.file, data); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	res, err := u.deploy(deployRequest{
+		Services: req.Services,
+		DryRun:   req.DryRun,
+		Rollback: true,
+		Message:  firstNonEmpty(req.Message, "rollback"),
+		Commit:   req.Commit,
+		Async:    req.Async,
+		Key:      keyName,
+		Source:   sourceAPI,
+	})
+	u.writeDeployResult(w, r, res, err)
+}
+
+// writeDeployResult encodes the outcome of deploy as the JSON response and
+// records it for the audit log.
+func (u *Updater) writeDeployResult(w http.ResponseWriter, r *http.Request, res *deployResult, err error) {
+	var de *deployError
+	if errors.As(err, &de) {
+		info(r).outcome = de.outcome
+		info(r).version = de.version
+		if de.status == http.StatusBadRequest {
+			u.badRequest(w, de.err)
+			return
+		}
+		if de.outcome == outcomeRolledBack {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(de.status)
+			_ = json.NewEncoder(w).Encode(map[string]string{"status": outcomeRolledBack, "error": de.err.Error()})
+			return
+		}
+		http.Error(w, de.err.Error(), de.status)
 		return
 	}
-	if _, err := u.compose.Pull(u.file, "hostship"); err != nil {
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	go func() {
-		if err := u.compose.Up(u.file, "hostship"); err != nil {
-			if u.verbose {
-				fmt.Println("compose up:", err)
-			}
-		}
-	}()
+	info(r).outcome = res.Status
+	info(r).version = res.Version
 	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(map[string]string{"status": "updated"})
+	if res.Job != "" {
+		w.Header().Set("Location", "/jobs/"+res.Job+"/events")
+	}
+	if res.Status == "accepted" {
+		w.WriteHeader(http.StatusAccepted)
+	}
+	_ = json.NewEncoder(w).Encode(res)
 }
 
 func (u *Updater) unknownEndpoint(w http.ResponseWriter) {
@@ -182,10 +660,41 @@ func (u *Updater) missingKey(w http.ResponseWriter) {
 	_ = json.NewEncoder(w).Encode(map[string]string{"error": "missing key"})
 }
 
-func (u *Updater) invalidKey(w http.ResponseWriter) {
+func (u *Updater) badRequest(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
+
+func (u *Updater) forbiddenComposeURL(w http.ResponseWriter) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusForbidden)
-	_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid key"})
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": "compose_url not allowed"})
+}
+
+func (u *Updater) forbiddenSource(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": "source address not allowed"})
+}
+
+func (u *Updater) tooManyRequests(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds()+0.5)))
+	w.WriteHeader(http.StatusTooManyRequests)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": "too many requests"})
+}
+
+func (u *Updater) missingClientCert(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": "client certificate required"})
+}
+
+func (u *Updater) invalidKey(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
 }
 
 func (u *Updater) deployURLError(w http.ResponseWriter, err error) {
@@ -198,24 +707,35 @@ func (u *Updater) deployURLError(w http.ResponseWriter, err error) {
 	_ = json.NewEncoder(w).Encode(map[string]string{"error": msg})
 }
 
-func loadEnv() {
-	data, err := os.ReadFile(".env")
-	if err != nil {
-		return
+// urlAllowed reports whether raw lies under one of the allowed prefixes: the
+// scheme and host must be equal and the path must start with the prefix's
+// path at a segment boundary.
+func urlAllowed(raw string, prefixes []string) bool {
+	target, err := url.Parse(raw)
+	if err != nil || target.User != nil || strings.Contains(target.Path, "..") {
+		return false
 	}
-	for _, line := range strings.Split(string(data), "\n") {
-		line = strings.TrimSpace(line)
-		if line == "" || strings.HasPrefix(line, "#") {
+	for _, p := range prefixes {
+		prefix, err := url.Parse(strings.TrimSpace(p))
+		if err != nil || prefix.Host == "" {
 			continue
 		}
-		parts := strings.SplitN(line, "=", 2)
-		if len(parts) != 2 {
+		if !strings.EqualFold(target.Scheme, prefix.Scheme) || !strings.EqualFold(target.Host, prefix.Host) {
 			continue
 		}
-		key := strings.TrimSpace(parts[0])
-		value := strings.TrimSpace(parts[1])
-		if _, ok := os.LookupEnv(key); !ok {
-			_ = os.Setenv(key, value)
+		dir := strings.TrimSuffix(prefix.Path, "/")
+		if target.Path == dir || strings.HasPrefix(target.Path, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
 		}
 	}
+	return ""
 }
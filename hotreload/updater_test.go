@@ -0,0 +1,31 @@
+package hotreload
+
+import "testing"
+
+func TestURLAllowed(t *testing.T) {
+	prefixes := []string{"https://config.example.com/stacks/", "https://Other.example.com/a"}
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://config.example.com/stacks/web.json", true},
+		{"https://CONFIG.example.com/stacks/web.json", true},
+		{"https://config.example.com/stacks", true},
+		{"https://config.example.com/stacksx/web.json", false},
+		{"https://config.example.com/stacks/../secrets.json", false},
+		{"http://config.example.com/stacks/web.json", false},
+		{"https://config.example.com.evil.test/stacks/web.json", false},
+		{"https://user:pw@config.example.com/stacks/web.json", false},
+		{"https://other.example.com/a/b.json", true},
+		{"https://other.example.com/ab.json", false},
+		{"://bad", false},
+	}
+	for _, tt := range tests {
+		if got := urlAllowed(tt.url, prefixes); got != tt.want {
+			t.Errorf("urlAllowed(%q) = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+	if urlAllowed("https://config.example.com/stacks/web.json", nil) {
+		t.Error("an empty allowlist must reject every URL")
+	}
+}
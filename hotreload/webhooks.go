@@ -0,0 +1,224 @@
+package hotreload
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/plark-inc/hostship/config"
+	"github.com/tidwall/gjson"
+)
+
+// Deploy sources for forge webhooks.
+const (
+	sourceGitHub = "github"
+	sourceGitLab = "gitlab"
+)
+
+// maxWebhookBody bounds the size of webhook payloads.
+const maxWebhookBody = 5 << 20
+
+// forgeEvent is the part of a forge webhook that decides whether to deploy.
+type forgeEvent struct {
+	// Kind is "push", "tag", "release" or "package".
+	Kind string
+	// Name is the branch, tag or package name the event refers to.
+	Name string
+}
+
+// webhookFilters select which forge events trigger a deploy. Each list holds
+// path.Match patterns; an empty list ignores events of that kind.
+type webhookFilters struct {
+	Branches []string
+	Tags     []string
+	Packages []string
+}
+
+// webhookFiltersFromEnv reads HOSTSHIP_WEBHOOK_BRANCHES, HOSTSHIP_WEBHOOK_TAGS
+// and HOSTSHIP_WEBHOOK_PACKAGES as comma separated pattern lists.
+func webhookFiltersFromEnv() webhookFilters {
+	return webhookFilters{
+		Branches: splitList(os.Getenv("HOSTSHIP_WEBHOOK_BRANCHES")),
+		Tags:     splitList(os.Getenv("HOSTSHIP_WEBHOOK_TAGS")),
+		Packages: splitList(os.Getenv("HOSTSHIP_WEBHOOK_PACKAGES")),
+	}
+}
+
+// match reports whether the event passes the filters.
+func (f webhookFilters) match(e forgeEvent) bool {
+	var patterns []string
+	switch e.Kind {
+	case "push":
+		patterns = f.Branches
+	case "tag", "release":
+		patterns = f.Tags
+	case "package":
+		patterns = f.Packages
+	}
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, e.Name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// handleWebhook verifies a GitHub or GitLab webhook and starts a deploy when
+// the event passes the configured filters.
+func (u *Updater) handleWebhook(w http.ResponseWriter, r *http.Request, forge string) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBody))
+	if err != nil {
+		u.badRequest(w, err)
+		return
+	}
+	config.LoadEnv()
+	var ev *forgeEvent
+	switch forge {
+	case sourceGitHub:
+		if !verifyGitHub(os.Getenv("HOSTSHIP_GITHUB_SECRET"), r.Header.Get("X-Hub-Signature-256"), body) {
+			u.rejectWebhook(w, r, forge)
+			return
+		}
+		if r.Header.Get("X-GitHub-Event") == "ping" {
+			u.webhookPong(w)
+			return
+		}
+		ev = parseGitHubEvent(r.Header.Get("X-GitHub-Event"), body)
+	case sourceGitLab:
+		if !verifyGitLab(os.Getenv("HOSTSHIP_GITLAB_TOKEN"), r.Header.Get("X-Gitlab-Token")) {
+			u.rejectWebhook(w, r, forge)
+			return
+		}
+		ev = parseGitLabEvent(r.Header.Get("X-Gitlab-Event"), body)
+	}
+	info(r).key = forge
+	u.guard.succeed(remoteHost(r))
+	if ev == nil {
+		info(r).outcome = "ignored"
+		u.webhookIgnored(w, "unsupported event")
+		return
+	}
+	if !webhookFiltersFromEnv().match(*ev) {
+		info(r).outcome = "ignored"
+		u.webhookIgnored(w, fmt.Sprintf("%s %s does not match the configured filters", ev.Kind, ev.Name))
+		return
+	}
+	slog.Info("webhook triggers a deploy", "forge", forge, "kind", ev.Kind, "ref", ev.Name)
+	res, err := u.deploy(deployRequest{Key: forge, Source: forge})
+	u.writeDeployResult(w, r, res, err)
+}
+
+// rejectWebhook answers a webhook whose signature or token did not verify.
+func (u *Updater) rejectWebhook(w http.ResponseWriter, r *http.Request, forge string) {
+	u.metrics.authFailures.Inc("webhook_signature")
+	u.failed(r)
+	slog.Debug("webhook signature rejected", "forge", forge)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid signature"})
+}
+
+// verifyGitHub checks the X-Hub-Signature-256 header, an HMAC-SHA256 of the
+// body keyed with the webhook secret.
+func verifyGitHub(secret, header string, body []byte) bool {
+	sig, ok := strings.CutPrefix(header, "sha256=")
+	if secret == "" || !ok {
+		return false
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// verifyGitLab checks the X-Gitlab-Token header against the configured token.
+func verifyGitLab(token, header string) bool {
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(header)) == 1
+}
+
+// parseGitHubEvent extracts the deploy relevant part of push, release and
+// package events. Other events and actions return nil.
+func parseGitHubEvent(event string, body []byte) *forgeEvent {
+	switch event {
+	case "push":
+		if gjson.GetBytes(body, "deleted").Bool() {
+			return nil
+		}
+		return refEvent(gjson.GetBytes(body, "ref").String())
+	case "release":
+		if gjson.GetBytes(body, "action").String() != "published" {
+			return nil
+		}
+		return &forgeEvent{Kind: "release", Name: gjson.GetBytes(body, "release.tag_name").String()}
+	case "package", "registry_package":
+		if gjson.GetBytes(body, "action").String() != "published" {
+			return nil
+		}
+		name := gjson.GetBytes(body, event+".name").String()
+		return &forgeEvent{Kind: "package", Name: name}
+	}
+	return nil
+}
+
+// parseGitLabEvent extracts the deploy relevant part of push, tag push and
+// release hooks. Other events return nil.
+func parseGitLabEvent(event string, body []byte) *forgeEvent {
+	switch event {
+	case "Push Hook", "Tag Push Hook":
+		// A deleted ref is reported with an all-zero "after" commit.
+		if strings.Trim(gjson.GetBytes(body, "after").String(), "0") == "" {
+			return nil
+		}
+		return refEvent(gjson.GetBytes(body, "ref").String())
+	case "Release Hook":
+		if gjson.GetBytes(body, "action").String() != "create" {
+			return nil
+		}
+		return &forgeEvent{Kind: "release", Name: gjson.GetBytes(body, "tag").String()}
+	}
+	return nil
+}
+
+// refEvent turns a git ref into a push or tag event.
+func refEvent(ref string) *forgeEvent {
+	if name, ok := strings.CutPrefix(ref, "refs/heads/"); ok {
+		return &forgeEvent{Kind: "push", Name: name}
+	}
+	if name, ok := strings.CutPrefix(ref, "refs/tags/"); ok {
+		return &forgeEvent{Kind: "tag", Name: name}
+	}
+	return nil
+}
+
+func (u *Updater) webhookPong(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]string{"status": "pong"})
+}
+
+func (u *Updater) webhookIgnored(w http.ResponseWriter, reason string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(map[string]string{"status": "ignored", "reason": reason})
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,234 @@
+package hotreload
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/plark-inc/hostship/docker"
+)
+
+func readPayload(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// sign returns the X-Hub-Signature-256 header GitHub sends for body.
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestParseForgeEvents(t *testing.T) {
+	tests := []struct {
+		name    string
+		parse   func(string, []byte) *forgeEvent
+		event   string
+		payload string
+		edit    func(string) string
+		want    *forgeEvent
+	}{
+		{"github push", parseGitHubEvent, "push", "github_push.json", nil, &forgeEvent{Kind: "push", Name: "main"}},
+		{"github deleted branch", parseGitHubEvent, "push", "github_push.json",
+			func(s string) string { return strings.Replace(s, `"deleted": false`, `"deleted": true`, 1) }, nil},
+		{"github tag push", parseGitHubEvent, "push", "github_push.json",
+			func(s string) string { return strings.Replace(s, "refs/heads/main", "refs/tags/v2.0.0", 1) }, &forgeEvent{Kind: "tag", Name: "v2.0.0"}},
+		{"github release", parseGitHubEvent, "release", "github_release.json", nil, &forgeEvent{Kind: "release", Name: "v1.4.2"}},
+		{"github draft release", parseGitHubEvent, "release", "github_release.json",
+			func(s string) string { return strings.Replace(s, `"published"`, `"created"`, 1) }, nil},
+		{"github other event", parseGitHubEvent, "issues", "github_push.json", nil, nil},
+		{"gitlab push", parseGitLabEvent, "Push Hook", "gitlab_push.json", nil, &forgeEvent{Kind: "push", Name: "production"}},
+		{"gitlab deleted branch", parseGitLabEvent, "Push Hook", "gitlab_push.json",
+			func(s string) string {
+				return strings.Replace(s, `"after": "da1560886d4f094c3e6c9ef40349f7d38b5d27d7"`, `"after": "0000000000000000000000000000000000000000"`, 1)
+			}, nil},
+		{"gitlab tag", parseGitLabEvent, "Tag Push Hook", "gitlab_tag.json", nil, &forgeEvent{Kind: "tag", Name: "v1.4.2"}},
+		{"gitlab other event", parseGitLabEvent, "Issue Hook", "gitlab_push.json", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := string(readPayload(t, tt.payload))
+			if tt.edit != nil {
+				edited := tt.edit(body)
+				if edited == body {
+					t.Fatal("edit did not change the payload")
+				}
+				body = edited
+			}
+			got := tt.parse(tt.event, []byte(body))
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyGitHub(t *testing.T) {
+	body := readPayload(t, "github_push.json")
+	good := sign("s3cret", body)
+	tests := []struct {
+		name   string
+		secret string
+		header string
+		body   []byte
+		want   bool
+	}{
+		{"valid signature", "s3cret", good, body, true},
+		{"wrong secret", "other", good, body, false},
+		{"tampered body", "s3cret", good, bytes.Replace(body, []byte("main"), []byte("evil"), 1), false},
+		{"sha1 signature", "s3cret", strings.Replace(good, "sha256=", "sha1=", 1), body, false},
+		{"not hex", "s3cret", "sha256=zz", body, false},
+		{"missing header", "s3cret", "", body, false},
+		{"no secret configured", "", sign("", body), body, false},
+	}
+	for _, tt := range tests {
+		if got := verifyGitHub(tt.secret, tt.header, tt.body); got != tt.want {
+			t.Errorf("%s: verifyGitHub = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestVerifyGitLab(t *testing.T) {
+	tests := []struct {
+		token, header string
+		want          bool
+	}{
+		{"t0ken", "t0ken", true},
+		{"t0ken", "t0ke", false},
+		{"t0ken", "T0KEN", false},
+		{"t0ken", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		if got := verifyGitLab(tt.token, tt.header); got != tt.want {
+			t.Errorf("verifyGitLab(%q, %q) = %v, want %v", tt.token, tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestWebhookFilters(t *testing.T) {
+	f := webhookFilters{
+		Branches: []string{"main", "release/*"},
+		Tags:     []string{"v*"},
+		Packages: []string{"shop"},
+	}
+	tests := []struct {
+		event forgeEvent
+		want  bool
+	}{
+		{forgeEvent{Kind: "push", Name: "main"}, true},
+		{forgeEvent{Kind: "push", Name: "release/1.4"}, true},
+		{forgeEvent{Kind: "push", Name: "release/1.4/hotfix"}, false},
+		{forgeEvent{Kind: "push", Name: "feature"}, false},
+		{forgeEvent{Kind: "tag", Name: "v1.4.2"}, true},
+		{forgeEvent{Kind: "release", Name: "v1.4.2"}, true},
+		{forgeEvent{Kind: "tag", Name: "nightly"}, false},
+		{forgeEvent{Kind: "package", Name: "shop"}, true},
+		{forgeEvent{Kind: "package", Name: "other"}, false},
+		{forgeEvent{Kind: "unknown", Name: "main"}, false},
+	}
+	for _, tt := range tests {
+		if got := f.match(tt.event); got != tt.want {
+			t.Errorf("match(%+v) = %v, want %v", tt.event, got, tt.want)
+		}
+	}
+	if (webhookFilters{}).match(forgeEvent{Kind: "push", Name: "main"}) {
+		t.Error("empty filters must ignore every event")
+	}
+}
+
+func TestParseRegistryPush(t *testing.T) {
+	cfg := []byte(`{"services":{
+		"web":{"image":"acme/shop:1.4.2"},
+		"old":{"image":"acme/shop:1.4.1"},
+		"worker":{"image":"registry.example.com/acme/worker:1.4.2"},
+		"db":{"image":"postgres:16"}}}`)
+	tests := []struct {
+		payload  string
+		refs     []docker.Reference
+		services []string
+	}{
+		{"registry_push.json", []docker.Reference{{Registry: "docker.io", Repository: "acme/shop", Tag: "1.4.2"}}, []string{"web"}},
+		{"registry_notification.json", []docker.Reference{{Repository: "acme/worker", Tag: "1.4.2"}}, []string{"worker"}},
+		{"github_push.json", nil, nil},
+	}
+	for _, tt := range tests {
+		refs := parseRegistryPush(readPayload(t, tt.payload))
+		if !slices.Equal(refs, tt.refs) {
+			t.Errorf("%s: refs = %+v, want %+v", tt.payload, refs, tt.refs)
+		}
+		if services := servicesUsing(cfg, refs); !slices.Equal(services, tt.services) {
+			t.Errorf("%s: services = %q, want %q", tt.payload, services, tt.services)
+		}
+	}
+}
+
+func TestWebhookEndpoints(t *testing.T) {
+	push := readPayload(t, "github_push.json")
+	gitlabPush := readPayload(t, "gitlab_push.json")
+	gitlabTag := readPayload(t, "gitlab_tag.json")
+	registryPush := readPayload(t, "registry_push.json")
+	t.Chdir(t.TempDir())
+	t.Setenv("HOSTSHIP_GITHUB_SECRET", "gh-secret")
+	t.Setenv("HOSTSHIP_GITLAB_TOKEN", "gl-token")
+	t.Setenv("HOSTSHIP_REGISTRY_TOKEN", "reg-token")
+	t.Setenv("HOSTSHIP_WEBHOOK_BRANCHES", "release/*")
+	t.Setenv("HOSTSHIP_WEBHOOK_TAGS", "")
+	if err := os.WriteFile("compose.json", []byte(`{"services":{"db":{"image":"postgres:16"}}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	u := New(nil)
+	u.file = "compose.json"
+
+	tests := []struct {
+		name    string
+		path    string
+		headers map[string]string
+		body    []byte
+		status  int
+		reply   string
+	}{
+		{"github ping", "/webhooks/github", map[string]string{"X-GitHub-Event": "ping", "X-Hub-Signature-256": sign("gh-secret", []byte("{}"))},
+			[]byte("{}"), http.StatusOK, "pong"},
+		{"github bad signature", "/webhooks/github", map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": sign("other", push)},
+			push, http.StatusUnauthorized, "invalid signature"},
+		{"github branch not matching", "/webhooks/github", map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": sign("gh-secret", push)},
+			push, http.StatusAccepted, "push main does not match"},
+		{"github unsupported event", "/webhooks/github", map[string]string{"X-GitHub-Event": "issues", "X-Hub-Signature-256": sign("gh-secret", push)},
+			push, http.StatusAccepted, "unsupported event"},
+		{"gitlab token mismatch", "/webhooks/gitlab", map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": "wrong"},
+			gitlabPush, http.StatusUnauthorized, "invalid signature"},
+		{"gitlab tags disabled", "/webhooks/gitlab", map[string]string{"X-Gitlab-Event": "Tag Push Hook", "X-Gitlab-Token": "gl-token"},
+			gitlabTag, http.StatusAccepted, "tag v1.4.2 does not match"},
+		{"registry token mismatch", "/webhooks/registry?token=wrong", nil,
+			registryPush, http.StatusUnauthorized, "invalid signature"},
+		{"registry image unused", "/webhooks/registry", map[string]string{"Authorization": "Bearer reg-token"},
+			registryPush, http.StatusAccepted, "no service uses the pushed images"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, bytes.NewReader(tt.body))
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			u.serve(rec, req)
+			if rec.Code != tt.status || !strings.Contains(rec.Body.String(), tt.reply) {
+				t.Errorf("got %d %q, want %d %q", rec.Code, rec.Body.String(), tt.status, tt.reply)
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package keys
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/plark-inc/hostship/config"
+	"github.com/spf13/cobra"
+)
+
+// Command constructs the `keys` command group which manages the deploy keys
+// accepted by the hot-reload listener.
+func Command() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "keys",
+		Short: "Manage deploy keys",
+	}
+	cmd.AddCommand(listCmd())
+	cmd.AddCommand(createCmd())
+	cmd.AddCommand(revokeCmd())
+	cmd.AddCommand(rotateCmd())
+	return cmd
+}
+
+func listCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List deploy keys",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			s, err := load()
+			if err != nil {
+				return err
+			}
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "NAME\tSCOPES\tCREATED\tEXPIRES")
+			now := time.Now()
+			for _, k := range s.Keys {
+				expires := "never"
+				if !k.Expires.IsZero() {
+					expires = k.Expires.Format(time.RFC3339)
+					if k.Expired(now) {
+						expires += " (expired)"
+					}
+				}
+				created := "-"
+				if !k.Created.IsZero() {
+					created = k.Created.Format(time.RFC3339)
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", k.Name, strings.Join(k.Scopes, ","), created, expires)
+			}
+			return tw.Flush()
+		},
+	}
+}
+
+func createCmd() *cobra.Command {
+	var scopes []string
+	var expires string
+	c := &cobra.Command{
+		Use:   "create <name>",
+		Short: "Create a deploy key and print it once",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			exp, err := parseExpiry(expires)
+			if err != nil {
+				return err
+			}
+			s, err := load()
+			if err != nil {
+				return err
+			}
+			if _, ok := s.Get(args[0]); ok {
+				return fmt.Errorf("key %s already exists; use rotate to replace it", args[0])
+			}
+			secret, err := s.Create(args[0], scopes, exp)
+			if err != nil {
+				return err
+			}
+			if err := s.Save(); err != nil {
+				return err
+			}
+			return printSecret(args[0], secret)
+		},
+	}
+	c.Flags().StringSliceVar(&scopes, "scope", []string{ScopeDeploy}, "scopes granted to the key (deploy, rollback, status)")
+	c.Flags().StringVar(&expires, "expires", "", "expiry as a duration (e.g. 720h) or RFC 3339 time")
+	return c
+}
+
+func revokeCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "revoke <name>",
+		Short: "Revoke a deploy key",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			s, err := load()
+			if err != nil {
+				return err
+			}
+			if err := s.Revoke(args[0]); err != nil {
+				return err
+			}
+			if err := s.Save(); err != nil {
+				return err
+			}
+			fmt.Printf("revoked %s\n", args[0])
+			return nil
+		},
+	}
+}
+
+func rotateCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "rotate <name>",
+		Short: "Replace the secret of a deploy key",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			s, err := load()
+			if err != nil {
+				return err
+			}
+			secret, err := s.Rotate(args[0])
+			if err != nil {
+				return err
+			}
+			if err := s.Save(); err != nil {
+				return err
+			}
+			if args[0] == DefaultName {
+				path, err := updateDefaultKey(secret)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("updated the deploy key in %s\n", path)
+			}
+			return printSecret(args[0], secret)
+		},
+	}
+}
+
+func load() (*Store, error) {
+	config.LoadEnv()
+	return Load(config.KeysPath)
+}
+
+// printSecret shows a newly issued secret together with the URL to call.
+func printSecret(name, secret string) error {
+	fmt.Printf("key %s: %s\n", name, secret)
+	if base := os.Getenv("DEPLOY_URL"); base != "" {
+		if u, err := UpdateURL(base, secret); err == nil {
+			fmt.Printf("url: %s\n", u)
+		}
+	}
+	fmt.Println("store it now; it cannot be shown again")
+	return nil
+}
+
+// updateDefaultKey stores the new secret of the default key where
+// DEPLOY_URL takes it from: the URL itself, or the key file when the URL
+// ends in /update as written by `setup --key-file`.
+func updateDefaultKey(secret string) (string, error) {
+	base := os.Getenv("DEPLOY_URL")
+	if base == "" {
+		base = DefaultDeployURL
+	} else if !embedsKey(base) {
+		return config.DeployKeyPath, WriteDeployKey(secret)
+	}
+	u, err := UpdateURL(base, secret)
+	if err != nil {
+		return "", err
+	}
+	return config.EnvPath, config.SetEnv("DEPLOY_URL", u)
+}
+
+// parseExpiry accepts an empty string (no expiry), a duration from now or an
+// RFC 3339 timestamp.
+func parseExpiry(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(d).UTC(), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid expiry %q: use a duration like 720h or an RFC 3339 time", s)
+	}
+	return t, nil
+}
@@ -0,0 +1,42 @@
+package keys
+
+import (
+	"os"
+	"testing"
+
+	"github.com/plark-inc/hostship/config"
+)
+
+func TestUpdateDefaultKey(t *testing.T) {
+	tests := []struct {
+		name      string
+		deployURL string
+		path      string
+		env       string
+	}{
+		{"no DEPLOY_URL embeds the key", "", config.EnvPath, "DEPLOY_URL=http://172.17.0.1:8080/update/new\n"},
+		{"embedded key", "https://host:8443/update/old", config.EnvPath, "DEPLOY_URL=https://host:8443/update/new\n"},
+		{"key file", "http://172.17.0.1:8080/update", config.DeployKeyPath, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			t.Setenv("DEPLOY_URL", tt.deployURL)
+			path, err := updateDefaultKey("new")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if path != tt.path {
+				t.Errorf("updated %s, want %s", path, tt.path)
+			}
+			env, _ := os.ReadFile(config.EnvPath)
+			if string(env) != tt.env {
+				t.Errorf(".env = %q, want %q", env, tt.env)
+			}
+			_, err = os.Stat(config.DeployKeyPath)
+			if exists := err == nil; exists != (tt.path == config.DeployKeyPath) {
+				t.Errorf("deploy.key exists = %v", exists)
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+// Package keys manages the named deploy keys accepted by the hot-reload
+// listener. Keys are stored as SHA-256 hashes so the key file never holds a
+// usable secret.
+package keys
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/plark-inc/hostship/config"
+)
+
+// Scopes a key can be granted.
+const (
+	ScopeDeploy   = "deploy"
+	ScopeRollback = "rollback"
+	ScopeStatus   = "status"
+)
+
+// AllScopes lists every scope in display order.
+var AllScopes = []string{ScopeDeploy, ScopeRollback, ScopeStatus}
+
+// DefaultName is the key of DEPLOY_URL.
+const DefaultName = "default"
+
+// Errors returned by Authenticate.
+var (
+	ErrInvalid  = errors.New("invalid key")
+	ErrExpired  = errors.New("key expired")
+	ErrScope    = errors.New("key not allowed")
+	ErrNotFound = errors.New("key not found")
+)
+
+// Key is a named deploy key. Only the hash of the secret is kept.
+type Key struct {
+	Name    string    `json:"name"`
+	Hash    string    `json:"hash"`
+	Scopes  []string  `json:"scopes"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires,omitzero"`
+}
+
+// Expired reports whether the key has an expiry in the past.
+func (k Key) Expired(now time.Time) bool {
+	return !k.Expires.IsZero() && now.After(k.Expires)
+}
+
+// Allows reports whether the key was granted scope.
+func (k Key) Allows(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Store is the set of keys persisted in the key file.
+type Store struct {
+	path string
+	Keys []Key `json:"keys"`
+}
+
+// Load reads the key file at path. When the file does not exist yet the key
+// of DEPLOY_URL is imported as the "default" key with every scope,
+// so hosts set up before named keys keep working.
+func Load(path string) (*Store, error) {
+	s := &Store{path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		if raw := os.Getenv("DEPLOY_URL"); raw != "" {
+			secret, err := DeployKey(raw)
+			if err != nil {
+				return nil, err
+			}
+			s.Keys = append(s.Keys, Key{Name: DefaultName, Hash: Hash(secret), Scopes: AllScopes})
+		}
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read keys: %w", err)
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("parse keys: %w", err)
+	}
+	return s, nil
+}
+
+// Save writes the store back to its file with owner-only permissions.
+func (s *Store) Save() error {
+	sort.Slice(s.Keys, func(i, j int) bool { return s.Keys[i].Name < s.Keys[j].Name })
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, append(data, '\n'), 0600)
+}
+
+// Get returns the key called name.
+func (s *Store) Get(name string) (Key, bool) {
+	for _, k := range s.Keys {
+		if k.Name == name {
+			return k, true
+		}
+	}
+	return Key{}, false
+}
+
+// Create adds a key and returns its secret, which is not stored anywhere and
+// must be handed to the client. An existing key with the same name is
+// replaced.
+func (s *Store) Create(name string, scopes []string, expires time.Time) (string, error) {
+	if name == "" || strings.ContainsAny(name, " \t\n/") {
+		return "", fmt.Errorf("invalid key name %q", name)
+	}
+	for _, sc := range scopes {
+		if !validScope(sc) {
+			return "", fmt.Errorf("unknown scope %q (valid: %s)", sc, strings.Join(AllScopes, ", "))
+		}
+	}
+	if len(scopes) == 0 {
+		return "", fmt.Errorf("a key needs at least one scope")
+	}
+	secret := uuid.New().String()
+	_ = s.Revoke(name)
+	s.Keys = append(s.Keys, Key{
+		Name:    name,
+		Hash:    Hash(secret),
+		Scopes:  scopes,
+		Created: time.Now().UTC(),
+		Expires: expires,
+	})
+	return secret, nil
+}
+
+// Rotate replaces the secret of an existing key, keeping its scopes and
+// expiry, and returns the new secret.
+func (s *Store) Rotate(name string) (string, error) {
+	k, ok := s.Get(name)
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	return s.Create(name, k.Scopes, k.Expires)
+}
+
+// Revoke removes the key called name.
+func (s *Store) Revoke(name string) error {
+	for i, k := range s.Keys {
+		if k.Name == name {
+			s.Keys = append(s.Keys[:i], s.Keys[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrNotFound, name)
+}
+
+// Authenticate finds the key matching secret and checks it may be used for
+// one of scopes. The name of the matched key is returned even when it is
+// expired or lacks the scopes so callers can record who was rejected.
+func (s *Store) Authenticate(secret string, scopes ...string) (string, error) {
+	h := []byte(Hash(secret))
+	var match *Key
+	for i := range s.Keys {
+		// Compare against every key so timing does not reveal which one
+		// matched.
+		if subtle.ConstantTimeCompare(h, []byte(s.Keys[i].Hash)) == 1 {
+			match = &s.Keys[i]
+		}
+	}
+	if match == nil {
+		return "", ErrInvalid
+	}
+	if match.Expired(time.Now()) {
+		return match.Name, ErrExpired
+	}
+	if !slices.ContainsFunc(scopes, match.Allows) {
+		return match.Name, fmt.Errorf("%w to %s", ErrScope, strings.Join(scopes, " or "))
+	}
+	return match.Name, nil
+}
+
+// Hash returns the hex encoded SHA-256 of a key secret.
+func Hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// DeployKey returns the secret of the default key for DEPLOY_URL. A URL of
+// the form http://host:port/update/<KEY> embeds it, as written by earlier
+// setups; a URL ending in /update leaves it in config.DeployKeyPath.
+func DeployKey(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	switch {
+	case len(parts) == 2 && parts[0] == "update":
+		return parts[1], nil
+	case len(parts) == 1 && parts[0] == "update":
+		data, err := os.ReadFile(config.DeployKeyPath)
+		if err != nil {
+			return "", fmt.Errorf("read deploy key: %w", err)
+		}
+		secret := strings.TrimSpace(string(data))
+		if secret == "" {
+			return "", fmt.Errorf("%s is empty", config.DeployKeyPath)
+		}
+		return secret, nil
+	}
+	return "", fmt.Errorf("unexpected path %q", u.Path)
+}
+
+// DefaultDeployURL is the update endpoint of a new setup, without the key.
+// It reaches the listener from containers on the default bridge network.
+const DefaultDeployURL = "http://172.17.0.1:8080/update"
+
+// WriteDeployKey stores the secret of the default key in
+// config.DeployKeyPath with owner-only permissions.
+func WriteDeployKey(secret string) error {
+	return os.WriteFile(config.DeployKeyPath, []byte(secret+"\n"), 0600)
+}
+
+// UpdateURL returns the update endpoint of DEPLOY_URL for secret.
+func UpdateURL(raw, secret string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	u.Path = "/update/" + secret
+	return u.String(), nil
+}
+
+// embedsKey reports whether DEPLOY_URL carries the key in its path.
+func embedsKey(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && strings.Count(strings.Trim(u.Path, "/"), "/") == 1
+}
+
+func validScope(scope string) bool {
+	for _, s := range AllScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
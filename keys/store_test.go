@@ -0,0 +1,129 @@
+package keys
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/plark-inc/hostship/config"
+)
+
+func TestAuthenticate(t *testing.T) {
+	now := time.Now()
+	s := &Store{Keys: []Key{
+		{Name: "ci", Hash: Hash("ci-secret"), Scopes: []string{ScopeDeploy}},
+		{Name: "ops", Hash: Hash("ops-secret"), Scopes: AllScopes, Expires: now.Add(time.Hour)},
+		{Name: "old", Hash: Hash("old-secret"), Scopes: AllScopes, Expires: now.Add(-time.Minute)},
+		{Name: "viewer", Hash: Hash("viewer-secret"), Scopes: []string{ScopeStatus}},
+	}}
+	tests := []struct {
+		name   string
+		secret string
+		scope  string
+		key    string
+		err    error
+	}{
+		{"deploy key deploys", "ci-secret", ScopeDeploy, "ci", nil},
+		{"deploy key cannot roll back", "ci-secret", ScopeRollback, "ci", ErrScope},
+		{"unexpired key", "ops-secret", ScopeRollback, "ops", nil},
+		{"expired key", "old-secret", ScopeDeploy, "old", ErrExpired},
+		{"status only", "viewer-secret", ScopeDeploy, "viewer", ErrScope},
+		{"status key reads status", "viewer-secret", ScopeStatus, "viewer", nil},
+		{"unknown secret", "nope", ScopeDeploy, "", ErrInvalid},
+		{"hash instead of secret", Hash("ci-secret"), ScopeDeploy, "", ErrInvalid},
+		{"empty secret", "", ScopeStatus, "", ErrInvalid},
+		{"secret with suffix", "ci-secret ", ScopeDeploy, "", ErrInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, err := s.Authenticate(tt.secret, tt.scope)
+			if name != tt.key {
+				t.Errorf("key = %q, want %q", name, tt.key)
+			}
+			if !errors.Is(err, tt.err) || (tt.err == nil && err != nil) {
+				t.Errorf("err = %v, want %v", err, tt.err)
+			}
+		})
+	}
+	if name, err := s.Authenticate("ci-secret", ScopeStatus, ScopeDeploy); name != "ci" || err != nil {
+		t.Errorf("deploy key for status or deploy = %q, %v", name, err)
+	}
+	if _, err := s.Authenticate("ci-secret", ScopeStatus, ScopeRollback); !errors.Is(err, ErrScope) {
+		t.Errorf("deploy key for status or rollback: err = %v, want %v", err, ErrScope)
+	}
+}
+
+func TestCreateRotateRevoke(t *testing.T) {
+	s := &Store{path: filepath.Join(t.TempDir(), "keys.json")}
+	if _, err := s.Create("ci", []string{"admin"}, time.Time{}); err == nil {
+		t.Error("expected an error for an unknown scope")
+	}
+	if _, err := s.Create("a b", []string{ScopeDeploy}, time.Time{}); err == nil {
+		t.Error("expected an error for a name with a space")
+	}
+	first, err := s.Create("ci", []string{ScopeDeploy}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.Rotate("ci")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Authenticate(first, ScopeDeploy); !errors.Is(err, ErrInvalid) {
+		t.Errorf("rotated secret: %v, want %v", err, ErrInvalid)
+	}
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(s.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, err := loaded.Authenticate(second, ScopeDeploy); name != "ci" || err != nil {
+		t.Errorf("Authenticate after reload = %q, %v", name, err)
+	}
+	if fi, err := os.Stat(s.path); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("key file mode: %v %v", fi.Mode(), err)
+	}
+	if err := loaded.Revoke("ci"); err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.Revoke("ci"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second revoke: %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestDeployKey(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if _, err := DeployKey("http://172.17.0.1:8080/update"); err == nil {
+		t.Error("expected an error without a key file")
+	}
+	if err := WriteDeployKey("from-file"); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(config.DeployKeyPath); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("deploy key mode: %v %v", fi.Mode(), err)
+	}
+	tests := []struct {
+		url, key string
+		ok       bool
+	}{
+		{"http://172.17.0.1:8080/update/embedded", "embedded", true},
+		{"https://host/update/embedded/", "embedded", true},
+		{"http://172.17.0.1:8080/update", "from-file", true},
+		{"http://172.17.0.1:8080/update/", "from-file", true},
+		{"http://172.17.0.1:8080/deploy/x", "", false},
+		{"http://172.17.0.1:8080/update/a/b", "", false},
+	}
+	for _, tt := range tests {
+		key, err := DeployKey(tt.url)
+		if key != tt.key || (err == nil) != tt.ok {
+			t.Errorf("DeployKey(%q) = %q, %v; want %q", tt.url, key, err, tt.key)
+		}
+	}
+	if u, err := UpdateURL("https://host:8443/update", "k"); err != nil || u != "https://host:8443/update/k" {
+		t.Errorf("UpdateURL = %q, %v", u, err)
+	}
+}
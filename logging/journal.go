@@ -0,0 +1,178 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// journalSocket is where journald accepts entries in its native protocol.
+var journalSocket = "/run/systemd/journal/socket"
+
+// journalStream reports whether stderr is connected to the journal, which
+// systemd announces in $JOURNAL_STREAM as "<device>:<inode>" of the stream.
+// Output redirected elsewhere by the unit or a shell does not count.
+func journalStream() bool {
+	dev, ino, ok := strings.Cut(os.Getenv("JOURNAL_STREAM"), ":")
+	if !ok {
+		return false
+	}
+	fi, err := os.Stderr.Stat()
+	if err != nil {
+		return false
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false
+	}
+	return dev == fmt.Sprint(st.Dev) && ino == fmt.Sprint(st.Ino)
+}
+
+// journalHandler sends records to the journal with a syslog priority and
+// their attributes as upper case fields, e.g. job=... becomes JOB=... so
+// entries can be filtered with `journalctl JOB=...`. Records the journal
+// does not accept are written to fallback.
+type journalHandler struct {
+	level    slog.Leveler
+	attrs    []slog.Attr
+	prefix   string
+	fallback slog.Handler
+}
+
+func (h *journalHandler) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= h.level.Level()
+}
+
+func (h *journalHandler) Handle(ctx context.Context, r slog.Record) error {
+	fields := make(map[string]string)
+	for _, a := range h.attrs {
+		addField(fields, "", a)
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		addField(fields, h.prefix, a)
+		return true
+	})
+	if err := sendJournal(priority(r.Level), r.Message, fields); err != nil {
+		return h.fallback.Handle(ctx, r)
+	}
+	return nil
+}
+
+func (h *journalHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.attrs = append([]slog.Attr{}, h.attrs...)
+	for _, a := range attrs {
+		if h.prefix != "" {
+			a.Key = h.prefix + a.Key
+		}
+		c.attrs = append(c.attrs, a)
+	}
+	c.fallback = h.fallback.WithAttrs(attrs)
+	return &c
+}
+
+func (h *journalHandler) WithGroup(name string) slog.Handler {
+	c := *h
+	c.prefix = h.prefix + name + "_"
+	c.fallback = h.fallback.WithGroup(name)
+	return &c
+}
+
+// addField flattens a, including groups, into journal fields.
+func addField(fields map[string]string, prefix string, a slog.Attr) {
+	v := a.Value.Resolve()
+	if v.Kind() == slog.KindGroup {
+		for _, ga := range v.Group() {
+			addField(fields, prefix+a.Key+"_", ga)
+		}
+		return
+	}
+	name := fieldName(prefix + a.Key)
+	if name == "" {
+		return
+	}
+	if v.Kind() == slog.KindTime {
+		fields[name] = v.Time().Format(time.RFC3339Nano)
+		return
+	}
+	fields[name] = v.String()
+}
+
+// fieldName converts an attribute key into a valid journal field name: upper
+// case letters, digits and underscores, not starting with an underscore.
+func fieldName(key string) string {
+	name := strings.Map(func(c rune) rune {
+		switch {
+		case c >= 'a' && c <= 'z':
+			return c - 'a' + 'A'
+		case c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+			return c
+		default:
+			return '_'
+		}
+	}, key)
+	name = strings.TrimLeft(name, "_")
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	switch name {
+	case "", "MESSAGE", "PRIORITY", "SYSLOG_IDENTIFIER":
+		return ""
+	}
+	return name
+}
+
+// priority maps a slog level to a syslog priority.
+func priority(l slog.Level) int {
+	switch {
+	case l >= slog.LevelError:
+		return 3
+	case l >= slog.LevelWarn:
+		return 4
+	case l >= slog.LevelInfo:
+		return 6
+	default:
+		return 7
+	}
+}
+
+// sendJournal writes an entry to the journal.
+func sendJournal(pri int, message string, fields map[string]string) error {
+	var buf bytes.Buffer
+	writeField(&buf, "PRIORITY", fmt.Sprint(pri))
+	writeField(&buf, "SYSLOG_IDENTIFIER", "hostship")
+	writeField(&buf, "MESSAGE", message)
+	for name, value := range fields {
+		writeField(&buf, name, value)
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("journal: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("journal: %w", err)
+	}
+	return nil
+}
+
+// writeField appends a field in the journal's native format. Values with a
+// newline are sent length-prefixed instead of as NAME=value.
+func writeField(buf *bytes.Buffer, name, value string) {
+	if !strings.Contains(value, "\n") {
+		fmt.Fprintf(buf, "%s=%s\n", name, value)
+		return
+	}
+	buf.WriteString(name)
+	buf.WriteByte('\n')
+	_ = binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
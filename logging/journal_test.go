@@ -0,0 +1,142 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// listenJournal binds a journal socket in a temporary directory and returns
+// a function reading the next entry.
+func listenJournal(t *testing.T) func() []byte {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "journal.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	old := journalSocket
+	journalSocket = path
+	t.Cleanup(func() { journalSocket = old })
+	return func() []byte {
+		t.Helper()
+		buf := make([]byte, 64<<10)
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return buf[:n]
+	}
+}
+
+// parseEntry decodes an entry in the journal's native format.
+func parseEntry(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	fields := make(map[string]string)
+	for len(data) > 0 {
+		line, rest, ok := bytes.Cut(data, []byte("\n"))
+		if !ok {
+			t.Fatalf("unterminated field %q", data)
+		}
+		if name, value, ok := bytes.Cut(line, []byte("=")); ok {
+			fields[string(name)] = string(value)
+			data = rest
+			continue
+		}
+		if len(rest) < 8 {
+			t.Fatalf("field %s: missing length", line)
+		}
+		n := binary.LittleEndian.Uint64(rest[:8])
+		rest = rest[8:]
+		if uint64(len(rest)) < n+1 || rest[n] != '\n' {
+			t.Fatalf("field %s: bad length %d", line, n)
+		}
+		fields[string(line)] = string(rest[:n])
+		data = rest[n+1:]
+	}
+	return fields
+}
+
+func TestJournalHandler(t *testing.T) {
+	read := listenJournal(t)
+	var fallback bytes.Buffer
+	h := &journalHandler{level: slog.LevelDebug, fallback: slog.NewTextHandler(&fallback, nil)}
+	logger := slog.New(h).With("command", "listen")
+
+	logger.Warn("deploy failed",
+		"job", "b1946ac9",
+		"err", "pre_deploy hook failed:\nmigrate: exit status 1\n",
+		"priority", "ignored",
+		slog.Group("docker", "version", "27.3.1"),
+		slog.Time("at", time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)),
+	)
+	raw := read()
+	want := map[string]string{
+		"PRIORITY":          "4",
+		"SYSLOG_IDENTIFIER": "hostship",
+		"MESSAGE":           "deploy failed",
+		"COMMAND":           "listen",
+		"JOB":               "b1946ac9",
+		"ERR":               "pre_deploy hook failed:\nmigrate: exit status 1\n",
+		"DOCKER_VERSION":    "27.3.1",
+		"AT":                "2026-10-19T12:00:00Z",
+	}
+	got := parseEntry(t, raw)
+	if len(got) != len(want) {
+		t.Errorf("fields = %q, want %q", got, want)
+	}
+	for name, value := range want {
+		if got[name] != value {
+			t.Errorf("%s = %q, want %q", name, got[name], value)
+		}
+	}
+	// The multi-line value is length-prefixed, not written as ERR=...
+	if !bytes.Contains(raw, append([]byte("ERR\n"), 0x2f, 0, 0, 0, 0, 0, 0, 0)) {
+		t.Errorf("ERR is not length-prefixed in %q", raw)
+	}
+
+	logger.WithGroup("deploy").Error("multi\nline message", "service", "web")
+	got = parseEntry(t, read())
+	if got["PRIORITY"] != "3" || got["MESSAGE"] != "multi\nline message" || got["DEPLOY_SERVICE"] != "web" || got["COMMAND"] != "listen" {
+		t.Errorf("grouped entry = %q", got)
+	}
+
+	if fallback.Len() != 0 {
+		t.Errorf("fallback used while the journal accepts entries: %q", fallback.String())
+	}
+	journalSocket = filepath.Join(t.TempDir(), "missing.sock")
+	logger.Info("journal gone")
+	if !bytes.Contains(fallback.Bytes(), []byte("msg=\"journal gone\" command=listen")) {
+		t.Errorf("fallback output = %q", fallback.String())
+	}
+}
+
+func TestFieldName(t *testing.T) {
+	tests := map[string]string{
+		"job":               "JOB",
+		"remote.addr":       "REMOTE_ADDR",
+		"_private":          "PRIVATE",
+		"héllo":             "H_LLO",
+		"message":           "",
+		"syslog_identifier": "",
+		"___":               "",
+		"a234567890123456789012345678901234567890123456789012345678901234567890": "A234567890123456789012345678901234567890123456789012345678901234",
+	}
+	for key, want := range tests {
+		if got := fieldName(key); got != want {
+			t.Errorf("fieldName(%q) = %q, want %q", key, got, want)
+		}
+	}
+	ctx := context.Background()
+	h := &journalHandler{level: slog.LevelInfo}
+	if h.Enabled(ctx, slog.LevelDebug) || !h.Enabled(ctx, slog.LevelInfo) {
+		t.Error("Enabled does not follow the level")
+	}
+}
@@ -0,0 +1,61 @@
+// Package logging configures the structured logger shared by all commands.
+// Packages log through log/slog; the root command installs the handler
+// selected with --log-level and --log-format before any subcommand runs.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Formats accepted by Setup.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// level is shared by every handler so it can be lowered after Setup.
+var level slog.LevelVar
+
+// ParseLevel parses debug, info, warn or error.
+func ParseLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("invalid log level %q: use debug, info, warn or error", s)
+	}
+	return l, nil
+}
+
+// Setup installs the default logger writing records at or above lvl to w in
+// format. attrs, e.g. the command name, are added to every record. Text logs
+// go to the journal instead when stderr is connected to it.
+func Setup(w io.Writer, lvl, format string, attrs ...any) error {
+	l, err := ParseLevel(lvl)
+	if err != nil {
+		return err
+	}
+	level.Set(l)
+	opts := &slog.HandlerOptions{Level: &level}
+	var h slog.Handler
+	switch strings.ToLower(format) {
+	case FormatText:
+		h = slog.NewTextHandler(w, opts)
+		if journalStream() {
+			h = &journalHandler{level: &level, fallback: h}
+		}
+	case FormatJSON:
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("invalid log format %q: use text or json", format)
+	}
+	slog.SetDefault(slog.New(h).With(attrs...))
+	return nil
+}
+
+// Debug lowers the level to debug, as the --verbose flag of subcommands
+// does.
+func Debug() {
+	level.Set(slog.LevelDebug)
+}
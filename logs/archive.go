@@ -0,0 +1,152 @@
+package logs
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/plark-inc/hostship/docker"
+)
+
+// archiveStamp formats the time an archive file was taken. It sorts
+// lexically and contains no dash, which separates the parts of file names.
+const archiveStamp = "20060102T150405.000000000Z"
+
+// unsafeName matches the characters replaced in the job part of file names.
+var unsafeName = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// Archive keeps container logs as gzip files under Dir before deploys
+// recreate the containers and docker drops their logs. Each deploy writes one
+// file per container named "<time>-<job>-<container id>.log.gz" in the
+// directory of its service, holding the lines since the previous file of the
+// same container. Only the newest Keep files of each service are kept.
+type Archive struct {
+	Dir  string
+	Keep int
+}
+
+// Save archives the lines c logged since its previous archive file. Nothing
+// is written when there are none.
+func (a *Archive) Save(c docker.ContainerState, job string) error {
+	dir := filepath.Join(a.Dir, c.Service)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+	id := c.ID
+	if len(id) > 12 {
+		id = id[:12]
+	}
+	now := time.Now().UTC()
+	if job == "" {
+		job = "manual"
+	}
+	name := fmt.Sprintf("%s-%s-%s.log.gz", now.Format(archiveStamp), unsafeName.ReplaceAllString(job, "_"), id)
+
+	args := []string{"logs", "--timestamps"}
+	if since, ok := a.lastArchived(dir, id); ok {
+		args = append(args, "--since", since.Format(time.RFC3339Nano))
+	}
+	tmp, err := os.CreateTemp(dir, ".archive-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	zw := gzip.NewWriter(tmp)
+	zw.Name = strings.TrimSuffix(name, ".gz")
+	zw.ModTime = now
+	counter := &countingWriter{w: zw}
+	cmd := exec.Command("docker", append(args, c.ID)...)
+	cmd.Stdout = counter
+	cmd.Stderr = counter
+	runErr := cmd.Run()
+	if err := zw.Close(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if runErr != nil {
+		return fmt.Errorf("logs of %s: %w", c.Name, runErr)
+	}
+	if counter.n == 0 {
+		return nil
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, name)); err != nil {
+		return err
+	}
+	return a.prune(dir)
+}
+
+// lastArchived returns when the newest file of container id in dir was
+// taken.
+func (a *Archive) lastArchived(dir, id string) (time.Time, bool) {
+	files, _ := filepath.Glob(filepath.Join(dir, "*-"+id+".log.gz"))
+	if len(files) == 0 {
+		return time.Time{}, false
+	}
+	sort.Strings(files)
+	stamp, _, _ := strings.Cut(filepath.Base(files[len(files)-1]), "-")
+	t, err := time.Parse(archiveStamp, stamp)
+	return t, err == nil
+}
+
+// prune removes all but the newest Keep files in dir.
+func (a *Archive) prune(dir string) error {
+	if a.Keep <= 0 {
+		return nil
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.log.gz"))
+	if err != nil || len(files) <= a.Keep {
+		return err
+	}
+	sort.Strings(files)
+	for _, f := range files[:len(files)-a.Keep] {
+		if err := os.Remove(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Files returns the archive files taken since t, relative to Dir and
+// sorted by service and time.
+func (a *Archive) Files(since time.Time) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(a.Dir, "*", "*.log.gz"))
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, f := range files {
+		stamp, _, _ := strings.Cut(filepath.Base(f), "-")
+		if t, err := time.Parse(archiveStamp, stamp); err != nil || t.Before(since) {
+			continue
+		}
+		rel, err := filepath.Rel(a.Dir, f)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, rel)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
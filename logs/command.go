@@ -3,60 +3,108 @@ package logs
 import (
 	"fmt"
 	"os"
-	"os/exec"
+	"regexp"
+	"strconv"
+	"time"
 
 	"github.com/plark-inc/hostship/config"
-	"github.com/plark-inc/hostship/docker"
 	"github.com/spf13/cobra"
 )
 
 // Command creates the `logs` subcommand for displaying service logs.
 func Command() *cobra.Command {
-	var follow bool
+	var opts Options
+	var grep string
+	var noColor bool
 	cmd := &cobra.Command{
-		Use:   "logs [service]",
-		Short: "Show live logs for a service",
-		Args:  cobra.ExactArgs(1),
+		Use:   "logs [service...]",
+		Short: "Show the logs of all or selected services",
+		Long: `Show the logs of all or selected services. Lines of several containers are
+prefixed with the service, and the replica number for scaled services.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return runLogs(args[0], follow)
+			if opts.Tail != "all" {
+				if n, err := strconv.Atoi(opts.Tail); err != nil || n < 0 {
+					return fmt.Errorf("invalid --tail %q: use a number of lines or all", opts.Tail)
+				}
+			}
+			if grep != "" {
+				re, err := regexp.Compile(grep)
+				if err != nil {
+					return fmt.Errorf("invalid --grep: %w", err)
+				}
+				opts.Grep = re
+			}
+			opts.Color = !noColor && os.Getenv("NO_COLOR") == "" && isTerminal(os.Stdout)
+			return runLogs(os.Stdout, args, opts)
 		},
 	}
-	cmd.Flags().BoolVarP(&follow, "follow", "f", true, "follow log output")
+	cmd.Flags().BoolVarP(&opts.Follow, "follow", "f", false, "follow log output")
+	cmd.Flags().StringVar(&opts.Since, "since", "", "only lines since this time (duration like 10m or RFC 3339)")
+	cmd.Flags().StringVar(&opts.Until, "until", "", "only lines before this time (duration like 10m or RFC 3339)")
+	cmd.Flags().StringVarP(&opts.Tail, "tail", "n", "all", "number of lines to show from the end of each container's logs")
+	cmd.Flags().BoolVarP(&opts.Timestamps, "timestamps", "t", false, "show timestamps")
+	cmd.Flags().StringVar(&grep, "grep", "", "only lines matching this regular expression")
+	cmd.Flags().BoolVar(&noColor, "no-color", false, "do not color the prefixes")
+	cmd.AddCommand(exportCmd())
 	return cmd
 }
 
-func runLogs(service string, follow bool) error {
-	cfgPath := config.Path
-	cfg, err := docker.Load(cfgPath)
-	if err != nil {
-		return err
-	}
-	names, err := docker.ServiceNames(cfg)
-	if err != nil {
-		return err
-	}
-	found := false
-	for _, n := range names {
-		if n == service {
-			found = true
-			break
-		}
+func exportCmd() *cobra.Command {
+	var since, output string
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Write archived and current logs to a tarball, e.g. for a support ticket",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			t, err := parseTime(since)
+			if err != nil {
+				return err
+			}
+			a := &Archive{Dir: config.LogArchiveDir}
+			if output == "-" {
+				return Export(os.Stdout, a, t)
+			}
+			if output == "" {
+				output = fmt.Sprintf("hostship-logs-%s.tar.gz", time.Now().Format("20060102-150405"))
+			}
+			f, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+			if err != nil {
+				return err
+			}
+			if err := Export(f, a, t); err != nil {
+				f.Close()
+				os.Remove(output)
+				return err
+			}
+			if err := f.Close(); err != nil {
+				return err
+			}
+			fmt.Printf("logs written to %s\n", output)
+			return nil
+		},
 	}
-	if !found {
-		return fmt.Errorf("service %s not found", service)
+	cmd.Flags().StringVar(&since, "since", "", "only logs after this time (duration like 24h or RFC 3339; default everything)")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "tarball to write, - for stdout (default hostship-logs-<time>.tar.gz)")
+	return cmd
+}
+
+// parseTime accepts an empty string, a duration before now or an RFC 3339
+// timestamp.
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
 	}
-	container := docker.GetString(cfg, fmt.Sprintf("services.%s.container_name", service))
-	if container == "" {
-		container = fmt.Sprintf("hostship-%s-1", service)
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
 	}
-	args := []string{"logs"}
-	if follow {
-		args = append(args, "-f")
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: use a duration like 24h or an RFC 3339 time", s)
 	}
-	args = append(args, container)
-	c := exec.Command("docker", args...)
-	c.Stdout = os.Stdout
-	c.Stderr = os.Stderr
-	c.Stdin = os.Stdin
-	return c.Run()
+	return t, nil
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
 }
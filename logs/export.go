@@ -0,0 +1,167 @@
+package logs
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/plark-inc/hostship/config"
+	"github.com/plark-inc/hostship/docker"
+)
+
+// Export writes a gzipped tarball to w holding the archived lines logged
+// since the given time under archive/ and the logs every container of the
+// project and its blue/green projects wrote since then under
+// current/<service>/. A zero since exports everything.
+func Export(w io.Writer, a *Archive, since time.Time) error {
+	zw := gzip.NewWriter(w)
+	tw := tar.NewWriter(zw)
+
+	files, err := a.Files(since)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := addArchived(tw, filepath.Join(a.Dir, f), path.Join("archive", filepath.ToSlash(f)), since); err != nil {
+			return err
+		}
+	}
+
+	cfg, err := docker.Load(config.Path)
+	if err != nil {
+		return err
+	}
+	names, err := docker.ServiceNames(cfg)
+	if err != nil {
+		return err
+	}
+	containers, err := docker.NewRunner(false).ProjectContainers(docker.ColorProjects(project, names)...)
+	if err != nil {
+		return err
+	}
+	for _, c := range containers {
+		if err := addCurrent(tw, c, since); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// addArchived adds the archive file with the lines logged since the given
+// time. A file taken after since may start with older lines, logged since
+// the container's previous file, so its lines are filtered by their own
+// timestamps and the file is left out when none remain.
+func addArchived(tw *tar.Writer, file, name string, since time.Time) error {
+	if since.IsZero() {
+		return addFile(tw, file, name)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	tmp, err := os.CreateTemp("", "hostship-logs-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	zw := gzip.NewWriter(tmp)
+	zw.Header = zr.Header
+	kept, err := filterSince(zw, zr, since)
+	if err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if kept == 0 {
+		return nil
+	}
+	return addFile(tw, tmp.Name(), name)
+}
+
+// filterSince copies the lines of r logged at or after since to w and
+// returns how many it kept. Lines start with the timestamp docker printed;
+// a line without one belongs with the line before it.
+func filterSince(w io.Writer, r io.Reader, since time.Time) (int, error) {
+	br := bufio.NewReader(r)
+	kept, keep := 0, false
+	for {
+		l, err := br.ReadString('\n')
+		if l != "" {
+			ts, _, _ := strings.Cut(l, " ")
+			if t, perr := time.Parse(time.RFC3339Nano, ts); perr == nil {
+				keep = !t.Before(since)
+			}
+			if keep {
+				if _, werr := io.WriteString(w, l); werr != nil {
+					return kept, werr
+				}
+				kept++
+			}
+		}
+		if err == io.EOF {
+			return kept, nil
+		}
+		if err != nil {
+			return kept, err
+		}
+	}
+}
+
+// addCurrent adds the logs of c since the given time. They are buffered in a
+// temporary file since tar headers need the size up front.
+func addCurrent(tw *tar.Writer, c docker.ContainerState, since time.Time) error {
+	tmp, err := os.CreateTemp("", "hostship-logs-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	args := []string{"logs", "--timestamps"}
+	if !since.IsZero() {
+		args = append(args, "--since", since.Format(time.RFC3339Nano))
+	}
+	cmd := exec.Command("docker", append(args, c.ID)...)
+	cmd.Stdout = tmp
+	cmd.Stderr = tmp
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("logs of %s: %w", c.Name, err)
+	}
+	return addFile(tw, tmp.Name(), path.Join("current", c.Service, c.Name+".log"))
+}
+
+func addFile(tw *tar.Writer, file, name string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{Name: name, Mode: 0640, Size: fi.Size(), ModTime: fi.ModTime()}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
@@ -0,0 +1,129 @@
+package logs
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/plark-inc/hostship/config"
+)
+
+func TestFilterSince(t *testing.T) {
+	in := `2026-10-19T09:59:59.999999999Z old
+2026-10-19T10:00:00.000000000Z boundary
+panic: boom
+	goroutine 1
+2026-10-19T09:00:00.000000000Z out of order
+continued
+2026-10-19T11:00:00.000000000Z last`
+	var b strings.Builder
+	kept, err := filterSince(&b, strings.NewReader(in), time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `2026-10-19T10:00:00.000000000Z boundary
+panic: boom
+	goroutine 1
+2026-10-19T11:00:00.000000000Z last`
+	if b.String() != want || kept != 4 {
+		t.Errorf("kept %d lines:\n%s\nwant:\n%s", kept, b.String(), want)
+	}
+}
+
+func writeGzip(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, _ = zw.Write([]byte(content))
+	_ = zw.Close()
+	if err := os.WriteFile(path, buf.Bytes(), 0640); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExportSince(t *testing.T) {
+	t.Chdir(t.TempDir())
+	bin := t.TempDir()
+	script := `#!/bin/sh
+case "$1" in
+ps) printf 'c1\thostship-web-1\thostship\tweb\t1\trunning\n'
+    printf 'c2\tother-web-1\tother\tweb\t1\trunning\n' ;;
+logs) echo "2026-10-19T12:00:00.000000000Z current $*" ;;
+esac
+`
+	if err := os.WriteFile(filepath.Join(bin, "docker"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	if err := os.WriteFile(config.Path, []byte(`{"services":{"web":{"image":"web:1"}}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	a := &Archive{Dir: "logs"}
+	// Taken before since, so none of its lines can be newer.
+	writeGzip(t, "logs/web/20261019T070000.000000000Z-job1-c0.log.gz", "2026-10-19T06:00:00.000000000Z too old\n")
+	// Taken after since, holding lines from both sides of it.
+	writeGzip(t, "logs/web/20261019T110000.000000000Z-job2-c0.log.gz",
+		"2026-10-19T08:00:00.000000000Z before\n2026-10-19T10:30:00.000000000Z after\n")
+	// Taken after since, holding only older lines.
+	writeGzip(t, "logs/db/20261019T110000.000000000Z-job2-d0.log.gz", "2026-10-19T09:00:00.000000000Z idle\n")
+
+	var out bytes.Buffer
+	if err := Export(&out, a, time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	got := readTarball(t, &out)
+	want := map[string]string{
+		"archive/web/20261019T110000.000000000Z-job2-c0.log.gz": "2026-10-19T10:30:00.000000000Z after\n",
+		"current/web/hostship-web-1.log":                        "2026-10-19T12:00:00.000000000Z current logs --timestamps --since 2026-10-19T10:00:00Z c1\n",
+	}
+	if len(got) != len(want) {
+		t.Errorf("tarball holds %q", got)
+	}
+	for name, content := range want {
+		if got[name] != content {
+			t.Errorf("%s = %q, want %q", name, got[name], content)
+		}
+	}
+}
+
+// readTarball returns the files of a gzipped tarball, decompressing the
+// gzip files inside.
+func readTarball(t *testing.T, r io.Reader) map[string]string {
+	t.Helper()
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		var content io.Reader = tr
+		if strings.HasSuffix(hdr.Name, ".gz") {
+			if content, err = gzip.NewReader(tr); err != nil {
+				t.Fatal(err)
+			}
+		}
+		data, err := io.ReadAll(content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[hdr.Name] = string(data)
+	}
+}
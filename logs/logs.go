@@ -0,0 +1,302 @@
+// Package logs implements the `logs` subcommand which shows the output of the
+// compose services, interleaving several containers.
+package logs
+
+import (
+	"bufio"
+	"container/heap"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/plark-inc/hostship/config"
+	"github.com/plark-inc/hostship/docker"
+)
+
+// project is the compose project name used for the stack.
+const project = "hostship"
+
+// colors are the ANSI colors of the prefixes, assigned in turn.
+var colors = []int{36, 33, 32, 35, 34, 96, 93, 92, 95, 94}
+
+// Options select and filter the log lines.
+type Options struct {
+	Follow     bool
+	Since      string
+	Until      string
+	Tail       string
+	Timestamps bool
+	// Grep keeps only the lines whose message matches.
+	Grep  *regexp.Regexp
+	Color bool
+}
+
+// source is a container whose logs are shown.
+type source struct {
+	docker.ContainerState
+	prefix string
+}
+
+// line is a log line of a source with the timestamp docker printed.
+type line struct {
+	time    time.Time
+	stamp   string
+	source  *source
+	message string
+}
+
+func runLogs(w io.Writer, services []string, opts Options) error {
+	cfg, err := docker.Load(config.Path)
+	if err != nil {
+		return err
+	}
+	names, err := docker.ServiceNames(cfg)
+	if err != nil {
+		return err
+	}
+	for _, s := range services {
+		found := false
+		for _, n := range names {
+			found = found || n == s
+		}
+		if !found {
+			return fmt.Errorf("service %s not found", s)
+		}
+	}
+	containers, err := docker.NewRunner(false).ProjectContainers(docker.ColorProjects(project, names)...)
+	if err != nil {
+		return err
+	}
+	sources := selectSources(containers, services)
+	if len(sources) == 0 && len(services) == 0 {
+		return fmt.Errorf("no containers found for project %s", project)
+	}
+	if len(sources) == 0 {
+		return fmt.Errorf("no containers found for %s", strings.Join(services, ", "))
+	}
+	if len(sources) > 1 {
+		setPrefixes(sources, opts.Color)
+	}
+
+	errs := make([]error, len(sources))
+	var wg sync.WaitGroup
+	if opts.Follow {
+		var mu sync.Mutex
+		emit := func(l line) {
+			mu.Lock()
+			defer mu.Unlock()
+			writeLine(w, l, opts.Timestamps)
+		}
+		for i, s := range sources {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs[i] = stream(s, opts, emit)
+			}()
+		}
+		wg.Wait()
+		return errors.Join(errs...)
+	}
+	// Without --follow the lines of all containers are merged by time as
+	// they are read, holding a few lines per container.
+	streams := make([]<-chan line, len(sources))
+	for i, s := range sources {
+		ch := make(chan line, 64)
+		streams[i] = ch
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(ch)
+			errs[i] = stream(s, opts, func(l line) { ch <- l })
+		}()
+	}
+	merge(w, streams, opts.Timestamps)
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// heads is a min-heap of the next line of each stream, ordered by time and
+// then by stream so lines logged at the same time keep the source order.
+type heads []head
+
+type head struct {
+	line   line
+	stream int
+}
+
+func (h heads) Len() int { return len(h) }
+func (h heads) Less(i, j int) bool {
+	if !h[i].line.time.Equal(h[j].line.time) {
+		return h[i].line.time.Before(h[j].line.time)
+	}
+	return h[i].stream < h[j].stream
+}
+func (h heads) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *heads) Push(x any)   { *h = append(*h, x.(head)) }
+func (h *heads) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// merge writes the lines of streams, each already in time order, in time
+// order. It waits for the next line of every stream still open before
+// writing the earliest one.
+func merge(w io.Writer, streams []<-chan line, timestamps bool) {
+	h := make(heads, 0, len(streams))
+	for i, ch := range streams {
+		if l, ok := <-ch; ok {
+			h = append(h, head{l, i})
+		}
+	}
+	heap.Init(&h)
+	for h.Len() > 0 {
+		next := h[0]
+		writeLine(w, next.line, timestamps)
+		if l, ok := <-streams[next.stream]; ok {
+			h[0].line = l
+			heap.Fix(&h, 0)
+		} else {
+			heap.Pop(&h)
+		}
+	}
+}
+
+// selectSources returns the containers of services, or of all services when
+// none are given. A service's stopped containers are only shown when none of
+// them runs, e.g. to see why it keeps crashing.
+func selectSources(containers []docker.ContainerState, services []string) []*source {
+	type group struct{ project, service string }
+	running := make(map[group]bool)
+	for _, c := range containers {
+		running[group{c.Project, c.Service}] = running[group{c.Project, c.Service}] || c.State == "running"
+	}
+	var sources []*source
+	for _, c := range containers {
+		if len(services) > 0 && !contains(services, c.Service) {
+			continue
+		}
+		if running[group{c.Project, c.Service}] && c.State != "running" {
+			continue
+		}
+		sources = append(sources, &source{ContainerState: c})
+	}
+	sort.Slice(sources, func(i, j int) bool {
+		a, b := sources[i], sources[j]
+		if a.Service != b.Service {
+			return a.Service < b.Service
+		}
+		if a.Project != b.Project {
+			return a.Project < b.Project
+		}
+		return a.Number < b.Number
+	})
+	return sources
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// setPrefixes names sources after their service, the blue/green color of
+// their project and, for scaled services, their replica number, padded to
+// the same width.
+func setPrefixes(sources []*source, color bool) {
+	labels := make([]string, len(sources))
+	count := make(map[string]int)
+	for i, s := range sources {
+		labels[i] = s.Service
+		if s.Project != project {
+			labels[i] = strings.TrimPrefix(s.Project, project+"-")
+		}
+		count[labels[i]]++
+	}
+	width := 0
+	for i, s := range sources {
+		if count[labels[i]] > 1 {
+			labels[i] += "-" + strconv.Itoa(s.Number)
+		}
+		width = max(width, len(labels[i]))
+	}
+	for i, s := range sources {
+		s.prefix = fmt.Sprintf("%-*s | ", width, labels[i])
+		if color {
+			s.prefix = fmt.Sprintf("\x1b[%dm%s\x1b[0m", colors[i%len(colors)], s.prefix)
+		}
+	}
+}
+
+// stream runs `docker logs` for s and passes its lines to emit in order.
+// Docker is always asked for timestamps so lines of several containers can
+// be merged.
+func stream(s *source, opts Options, emit func(line)) error {
+	args := []string{"logs", "--timestamps"}
+	if opts.Follow {
+		args = append(args, "--follow")
+	}
+	if opts.Since != "" {
+		args = append(args, "--since", opts.Since)
+	}
+	if opts.Until != "" {
+		args = append(args, "--until", opts.Until)
+	}
+	if opts.Tail != "" {
+		args = append(args, "--tail", opts.Tail)
+	}
+	cmd := exec.Command("docker", append(args, s.ID)...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	// Both streams share the pipe so the lines stay in the order docker
+	// printed them.
+	cmd.Stderr = cmd.Stdout
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	sc := bufio.NewScanner(stdout)
+	sc.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for sc.Scan() {
+		l := parseLine(s, sc.Text())
+		if opts.Grep == nil || opts.Grep.MatchString(l.message) {
+			emit(l)
+		}
+	}
+	_, _ = io.Copy(io.Discard, stdout)
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("logs of %s: %w", s.Name, err)
+	}
+	return nil
+}
+
+// parseLine splits the timestamp docker prefixes each line with.
+func parseLine(s *source, text string) line {
+	ts, msg, ok := strings.Cut(text, " ")
+	if ok {
+		if t, err := time.Parse(time.RFC3339Nano, ts); err == nil {
+			return line{time: t, stamp: ts, source: s, message: msg}
+		}
+	}
+	return line{source: s, message: text}
+}
+
+func writeLine(w io.Writer, l line, timestamps bool) {
+	ts := ""
+	if timestamps && l.stamp != "" {
+		ts = l.stamp + " "
+	}
+	fmt.Fprintf(w, "%s%s%s\n", l.source.prefix, ts, l.message)
+}
@@ -0,0 +1,79 @@
+package logs
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/plark-inc/hostship/docker"
+)
+
+func TestMerge(t *testing.T) {
+	web := &source{prefix: "web | "}
+	db := &source{prefix: "db  | "}
+	at := func(s *source, sec int, msg string) line {
+		ts := time.Date(2026, 10, 19, 12, 0, sec, 0, time.UTC)
+		return line{time: ts, stamp: ts.Format(time.RFC3339Nano), source: s, message: msg}
+	}
+	send := func(lines ...line) <-chan line {
+		ch := make(chan line)
+		go func() {
+			defer close(ch)
+			for _, l := range lines {
+				ch <- l
+			}
+		}()
+		return ch
+	}
+	var b strings.Builder
+	merge(&b, []<-chan line{
+		send(at(web, 1, "GET /"), at(web, 3, "GET /a"), at(web, 3, "GET /b"), at(web, 9, "GET /c")),
+		send(at(db, 0, "ready"), at(db, 3, "checkpoint"), at(db, 4, "vacuum")),
+		send(),
+	}, false)
+	want := []string{
+		"db  | ready",
+		"web | GET /",
+		"web | GET /a",
+		"web | GET /b",
+		"db  | checkpoint",
+		"db  | vacuum",
+		"web | GET /c",
+	}
+	if got := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n"); !slices.Equal(got, want) {
+		t.Errorf("merged:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestStreamKeepsOrder(t *testing.T) {
+	dir := t.TempDir()
+	script := `#!/bin/sh
+echo "$@" > "$(dirname "$0")/args"
+echo "2026-10-19T12:00:01.000000000Z starting"
+echo "2026-10-19T12:00:02.000000000Z warning: low memory" >&2
+echo "2026-10-19T12:00:03.000000000Z listening"
+echo "2026-10-19T12:00:04.000000000Z error: oom" >&2
+`
+	if err := os.WriteFile(filepath.Join(dir, "docker"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	s := &source{ContainerState: docker.ContainerState{ID: "a1", Name: "hostship-web-1"}}
+	var got []string
+	err := stream(s, Options{Tail: "100", Since: "1h"}, func(l line) { got = append(got, l.message) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"starting", "warning: low memory", "listening", "error: oom"}
+	if !slices.Equal(got, want) {
+		t.Errorf("lines = %q, want %q", got, want)
+	}
+	args, _ := os.ReadFile(filepath.Join(dir, "args"))
+	if want := "logs --timestamps --since 1h --tail 100 a1\n"; string(args) != want {
+		t.Errorf("docker args = %q, want %q", args, want)
+	}
+}
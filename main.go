@@ -10,9 +10,16 @@ import (
 
 	"github.com/spf13/cobra"
 
+	"github.com/plark-inc/hostship/audit"
+	"github.com/plark-inc/hostship/deploy"
+	"github.com/plark-inc/hostship/diff"
+	"github.com/plark-inc/hostship/doctor"
 	"github.com/plark-inc/hostship/hotreload"
+	"github.com/plark-inc/hostship/keys"
+	"github.com/plark-inc/hostship/logging"
 	"github.com/plark-inc/hostship/logs"
 	"github.com/plark-inc/hostship/selfupdate"
+	"github.com/plark-inc/hostship/service"
 	"github.com/plark-inc/hostship/setup"
 	"github.com/plark-inc/hostship/start"
 	"github.com/plark-inc/hostship/systemd"
@@ -31,16 +38,26 @@ var channel = "dev"
 // actual work.
 func main() {
 	var showVersion bool
+	logLevel := firstNonEmpty(os.Getenv("HOSTSHIP_LOG_LEVEL"), "info")
+	logFormat := firstNonEmpty(os.Getenv("HOSTSHIP_LOG_FORMAT"), logging.FormatText)
 
 	root := &cobra.Command{
 		Use:          "hostship",
 		Short:        "Docker Service Manager",
 		SilenceUsage: true,
-		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
 			if showVersion {
 				fmt.Printf("%s %s\n", channel, version)
 				os.Exit(0)
 			}
+			if err := logging.Setup(os.Stderr, logLevel, logFormat, "command", cmd.Name()); err != nil {
+				return err
+			}
+			// The --verbose flag of subcommands is shorthand for debug logs.
+			if v := cmd.Flags().Lookup("verbose"); v != nil && v.Value.String() == "true" && !cmd.Flags().Changed("log-level") {
+				logging.Debug()
+			}
+			return nil
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return cmd.Help()
@@ -48,12 +65,20 @@ func main() {
 	}
 
 	root.Flags().BoolVarP(&showVersion, "version", "v", false, "print version and exit")
+	root.PersistentFlags().StringVar(&logLevel, "log-level", logLevel, "log level: debug, info, warn or error (env HOSTSHIP_LOG_LEVEL)")
+	root.PersistentFlags().StringVar(&logFormat, "log-format", logFormat, "log format: text or json (env HOSTSHIP_LOG_FORMAT)")
 
 	root.AddCommand(systemd.Command())
+	root.AddCommand(service.Command())
 	root.AddCommand(setup.Command())
 	root.AddCommand(start.Command())
 	root.AddCommand(hotreload.Command())
 	root.AddCommand(logs.Command())
+	root.AddCommand(diff.Command())
+	root.AddCommand(deploy.Command())
+	root.AddCommand(doctor.Command())
+	root.AddCommand(keys.Command())
+	root.AddCommand(audit.Command())
 	root.AddCommand(selfupdate.Command(&version, &channel))
 
 	// Hide the default 'help' subcommand to keep the usage output concise.
@@ -65,3 +90,12 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,264 @@
+// Package metrics implements the small subset of Prometheus metric types used
+// by the hot-reload listener and renders them in the text exposition format.
+// It has no dependencies so the output can be checked without a Prometheus
+// server.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Collector writes one metric family in the text exposition format.
+type Collector interface {
+	Write(w io.Writer)
+}
+
+// CollectorFunc adapts a function into a Collector. It is used for values
+// that are computed at scrape time such as container states.
+type CollectorFunc func(w io.Writer)
+
+// Write calls f(w).
+func (f CollectorFunc) Write(w io.Writer) { f(w) }
+
+// Registry holds the collectors exposed by Handler in registration order.
+type Registry struct {
+	mu         sync.Mutex
+	collectors []Collector
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry { return &Registry{} }
+
+// Register adds collectors to the registry.
+func (r *Registry) Register(cs ...Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, cs...)
+}
+
+// Write renders every registered collector.
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	cs := append([]Collector(nil), r.collectors...)
+	r.mu.Unlock()
+	for _, c := range cs {
+		c.Write(w)
+	}
+}
+
+// Handler serves the registry in the Prometheus text format.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Write(w)
+	})
+}
+
+// vec stores one value per label combination.
+type vec struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+	mu     sync.Mutex
+	values map[string]float64
+	keys   map[string][]string
+}
+
+func newVec(name, help, typ string, labels []string) *vec {
+	return &vec{name: name, help: help, typ: typ, labels: labels, values: map[string]float64{}, keys: map[string][]string{}}
+}
+
+func (v *vec) key(lvs []string) string {
+	if len(lvs) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labels), len(lvs)))
+	}
+	k := strings.Join(lvs, "\xff")
+	if _, ok := v.keys[k]; !ok {
+		v.keys[k] = append([]string(nil), lvs...)
+	}
+	return k
+}
+
+func (v *vec) Write(w io.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	writeHeader(w, v.name, v.help, v.typ)
+	keys := make([]string, 0, len(v.values))
+	for k := range v.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", v.name, labelString(v.labels, v.keys[k]), formatFloat(v.values[k]))
+	}
+}
+
+// CounterVec is a monotonically increasing value partitioned by labels.
+type CounterVec struct{ *vec }
+
+// NewCounterVec creates a counter with the given label names.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{newVec(name, help, "counter", labels)}
+}
+
+// Inc adds one to the counter for the label values.
+func (c *CounterVec) Inc(lvs ...string) { c.Add(1, lvs...) }
+
+// Add adds delta to the counter for the label values.
+func (c *CounterVec) Add(delta float64, lvs ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[c.key(lvs)] += delta
+}
+
+// GaugeVec is a value that can go up and down, partitioned by labels.
+type GaugeVec struct{ *vec }
+
+// NewGaugeVec creates a gauge with the given label names.
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{newVec(name, help, "gauge", labels)}
+}
+
+// Set sets the gauge for the label values.
+func (g *GaugeVec) Set(value float64, lvs ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.values[g.key(lvs)] = value
+}
+
+// Reset removes all label combinations, e.g. before recording an info metric
+// whose label changed.
+func (g *GaugeVec) Reset() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.values = map[string]float64{}
+	g.keys = map[string][]string{}
+}
+
+// DefBuckets are the default histogram buckets in seconds, suited to deploy
+// phases that take from milliseconds to several minutes.
+var DefBuckets = []float64{0.1, 0.5, 1, 5, 10, 30, 60, 120, 300, 600}
+
+// HistogramVec samples observations into buckets, partitioned by labels.
+type HistogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogram
+}
+
+type histogram struct {
+	lvs    []string
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewHistogramVec creates a histogram with the given buckets and label names.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return &HistogramVec{name: name, help: help, labels: labels, buckets: buckets, series: map[string]*histogram{}}
+}
+
+// Observe records value for the label values.
+func (h *HistogramVec) Observe(value float64, lvs ...string) {
+	if len(lvs) != len(h.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", h.name, len(h.labels), len(lvs)))
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	k := strings.Join(lvs, "\xff")
+	s, ok := h.series[k]
+	if !ok {
+		s = &histogram{lvs: append([]string(nil), lvs...), counts: make([]uint64, len(h.buckets))}
+		h.series[k] = s
+	}
+	for i, b := range h.buckets {
+		if value <= b {
+			s.counts[i]++
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+// Write renders the histogram.
+func (h *HistogramVec) Write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	writeHeader(w, h.name, h.help, "histogram")
+	keys := make([]string, 0, len(h.series))
+	for k := range h.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := h.series[k]
+		names := append(append([]string(nil), h.labels...), "le")
+		for i, b := range h.buckets {
+			lvs := append(append([]string(nil), s.lvs...), formatFloat(b))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelString(names, lvs), s.counts[i])
+		}
+		lvs := append(append([]string(nil), s.lvs...), "+Inf")
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelString(names, lvs), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labelString(h.labels, s.lvs), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labelString(h.labels, s.lvs), s.count)
+	}
+}
+
+// WriteGauge renders a gauge family with the given samples. It is meant for
+// CollectorFunc implementations.
+func WriteGauge(w io.Writer, name, help string, samples []Sample) {
+	writeHeader(w, name, help, "gauge")
+	for _, s := range samples {
+		fmt.Fprintf(w, "%s%s %s\n", name, labelString(s.Labels, s.Values), formatFloat(s.Value))
+	}
+}
+
+// Sample is one value of a metric computed at scrape time.
+type Sample struct {
+	Labels []string
+	Values []string
+	Value  float64
+}
+
+func writeHeader(w io.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, helpEscaper.Replace(help), name, typ)
+}
+
+func labelString(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	parts := make([]string, len(names))
+	for i, n := range names {
+		parts[i] = fmt.Sprintf(`%s="%s"`, n, labelEscaper.Replace(values[i]))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// labelEscaper and helpEscaper escape label values and help texts as
+// required by the exposition format.
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRegistryExposition(t *testing.T) {
+	deploys := NewCounterVec("hostship_deploys_total", "Deploys handled by the listener by outcome.", "outcome")
+	requests := NewCounterVec("hostship_requests_total", "Requests by path\nand status code \\ class.", "path", "code")
+	lastSuccess := NewGaugeVec("hostship_last_successful_deploy_timestamp_seconds", "Unix time of the last successful deploy.")
+	info := NewGaugeVec("hostship_compose_info", "Version of the compose file currently deployed.", "version")
+	phases := NewHistogramVec("hostship_deploy_phase_duration_seconds", "Duration of each deploy phase.", []float64{0.5, 1, 60}, "phase")
+
+	deploys.Inc("succeeded")
+	deploys.Inc("succeeded")
+	deploys.Inc("failed")
+	requests.Add(3, "/update", "2xx")
+	requests.Inc(`/jobs/"x"`, "4xx")
+	requests.Inc("a\\b\nc", "5xx")
+	lastSuccess.Set(1.7e9)
+	info.Set(1, "1.0")
+	info.Reset()
+	info.Set(1, "2.0")
+	phases.Observe(0.25, "pull")
+	phases.Observe(1, "pull")
+	phases.Observe(90, "pull")
+	phases.Observe(0.5, "up")
+
+	r := NewRegistry()
+	r.Register(deploys, requests, lastSuccess, info, phases, CollectorFunc(func(w io.Writer) {
+		WriteGauge(w, "hostship_containers", "Containers by state.", []Sample{
+			{Labels: []string{"service", "state"}, Values: []string{"web", "running"}, Value: 2},
+		})
+	}))
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	if ct := rec.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	want := `# HELP hostship_deploys_total Deploys handled by the listener by outcome.
+# TYPE hostship_deploys_total counter
+hostship_deploys_total{outcome="failed"} 1
+hostship_deploys_total{outcome="succeeded"} 2
+# HELP hostship_requests_total Requests by path\nand status code \\ class.
+# TYPE hostship_requests_total counter
+hostship_requests_total{path="/jobs/\"x\"",code="4xx"} 1
+hostship_requests_total{path="/update",code="2xx"} 3
+hostship_requests_total{path="a\\b\nc",code="5xx"} 1
+# HELP hostship_last_successful_deploy_timestamp_seconds Unix time of the last successful deploy.
+# TYPE hostship_last_successful_deploy_timestamp_seconds gauge
+hostship_last_successful_deploy_timestamp_seconds 1.7e+09
+# HELP hostship_compose_info Version of the compose file currently deployed.
+# TYPE hostship_compose_info gauge
+hostship_compose_info{version="2.0"} 1
+# HELP hostship_deploy_phase_duration_seconds Duration of each deploy phase.
+# TYPE hostship_deploy_phase_duration_seconds histogram
+hostship_deploy_phase_duration_seconds_bucket{phase="pull",le="0.5"} 1
+hostship_deploy_phase_duration_seconds_bucket{phase="pull",le="1"} 2
+hostship_deploy_phase_duration_seconds_bucket{phase="pull",le="60"} 2
+hostship_deploy_phase_duration_seconds_bucket{phase="pull",le="+Inf"} 3
+hostship_deploy_phase_duration_seconds_sum{phase="pull"} 91.25
+hostship_deploy_phase_duration_seconds_count{phase="pull"} 3
+hostship_deploy_phase_duration_seconds_bucket{phase="up",le="0.5"} 1
+hostship_deploy_phase_duration_seconds_bucket{phase="up",le="1"} 1
+hostship_deploy_phase_duration_seconds_bucket{phase="up",le="60"} 1
+hostship_deploy_phase_duration_seconds_bucket{phase="up",le="+Inf"} 1
+hostship_deploy_phase_duration_seconds_sum{phase="up"} 0.5
+hostship_deploy_phase_duration_seconds_count{phase="up"} 1
+# HELP hostship_containers Containers by state.
+# TYPE hostship_containers gauge
+hostship_containers{service="web",state="running"} 2
+`
+	if got := rec.Body.String(); got != want {
+		t.Errorf("exposition mismatch\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestLabelCountMismatchPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic")
+		}
+	}()
+	NewCounterVec("c", "help", "a", "b").Inc("only one")
+}
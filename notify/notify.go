@@ -0,0 +1,244 @@
+// Package notify sends outbound notifications about deploys and self-updates
+// to generic JSON, Slack-compatible and Discord-compatible webhooks.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Event types sent to the configured webhooks.
+const (
+	DeployStarted    = "deploy_started"
+	DeploySucceeded  = "deploy_succeeded"
+	DeployFailed     = "deploy_failed"
+	DeployRolledBack = "deploy_rolled_back"
+	// DeployInterrupted is sent on start for a deploy the listener was
+	// stopped in the middle of.
+	DeployInterrupted = "deploy_interrupted"
+	SelfUpdated       = "self_updated"
+)
+
+// Payload formats understood by Target.
+const (
+	FormatJSON    = "json"
+	FormatSlack   = "slack"
+	FormatDiscord = "discord"
+)
+
+// Event describes something that happened on the host.
+type Event struct {
+	Type     string    `json:"type"`
+	Host     string    `json:"host"`
+	Version  string    `json:"version,omitempty"`
+	Services []string  `json:"services,omitempty"`
+	Key      string    `json:"key,omitempty"`
+	Source   string    `json:"source,omitempty"`
+	Message  string    `json:"message,omitempty"`
+	Commit   string    `json:"commit,omitempty"`
+	Job      string    `json:"job,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// Target is a webhook URL together with the payload format it expects.
+type Target struct {
+	URL    string
+	Format string
+}
+
+// Notifier delivers events to its targets, retrying failed deliveries.
+type Notifier struct {
+	Targets []Target
+	Client  *http.Client
+	Retries int
+	Backoff time.Duration
+}
+
+// New creates a Notifier for the given targets with default retry settings.
+func New(targets []Target) *Notifier {
+	return &Notifier{
+		Targets: targets,
+		Client:  &http.Client{Timeout: 10 * time.Second},
+		Retries: 3,
+		Backoff: time.Second,
+	}
+}
+
+// FromEnv builds a Notifier from the NOTIFY_WEBHOOK_URL, NOTIFY_SLACK_URL and
+// NOTIFY_DISCORD_URL environment variables. Each may hold a comma separated
+// list of URLs. A Notifier without targets sends nothing.
+func FromEnv() *Notifier {
+	var targets []Target
+	for _, src := range []struct{ env, format string }{
+		{"NOTIFY_WEBHOOK_URL", FormatJSON},
+		{"NOTIFY_SLACK_URL", FormatSlack},
+		{"NOTIFY_DISCORD_URL", FormatDiscord},
+	} {
+		for _, u := range strings.Split(os.Getenv(src.env), ",") {
+			if u = strings.TrimSpace(u); u != "" {
+				targets = append(targets, Target{URL: u, Format: src.format})
+			}
+		}
+	}
+	return New(targets)
+}
+
+// Notify sends e to every target. Missing Host and Time fields are filled in.
+// Errors from all targets are joined. A nil Notifier does nothing.
+func (n *Notifier) Notify(ctx context.Context, e Event) error {
+	if n == nil || len(n.Targets) == 0 {
+		return nil
+	}
+	if e.Host == "" {
+		e.Host, _ = os.Hostname()
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	var errs []error
+	for _, t := range n.Targets {
+		if err := n.send(ctx, t, e); err != nil {
+			slog.Warn("notification failed", "format", t.Format, "err", err)
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Go sends e in the background so callers are never delayed by slow webhooks.
+func (n *Notifier) Go(e Event) {
+	if n == nil || len(n.Targets) == 0 {
+		return
+	}
+	go func() { _ = n.Notify(context.Background(), e) }()
+}
+
+func (n *Notifier) send(ctx context.Context, t Target, e Event) error {
+	body, err := payload(t.Format, e)
+	if err != nil {
+		return err
+	}
+	var lastErr error
+	for attempt := 0; attempt <= n.Retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(n.Backoff * time.Duration(1<<(attempt-1))):
+			}
+		}
+		lastErr = n.post(ctx, t.URL, body)
+		if lastErr == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s after %d attempts: %w", redact(t.URL), n.Retries+1, lastErr)
+}
+
+// post sends body to target. Errors never contain the full URL.
+func (n *Notifier) post(ctx context.Context, target string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return redactError(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return redactError(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// payload encodes e in the format expected by the target.
+func payload(format string, e Event) ([]byte, error) {
+	switch format {
+	case FormatJSON, "":
+		return json.Marshal(e)
+	case FormatSlack:
+		return json.Marshal(map[string]string{"text": Summary(e)})
+	case FormatDiscord:
+		return json.Marshal(map[string]string{"content": Summary(e)})
+	}
+	return nil, fmt.Errorf("unknown notification format %q", format)
+}
+
+// Summary renders e as a single human readable line.
+func Summary(e Event) string {
+	var b strings.Builder
+	titles := map[string]string{
+		DeployStarted:     "Deploy started",
+		DeploySucceeded:   "Deploy succeeded",
+		DeployFailed:      "Deploy failed",
+		DeployRolledBack:  "Deploy rolled back",
+		DeployInterrupted: "Deploy interrupted",
+		SelfUpdated:       "hostship updated",
+	}
+	title, ok := titles[e.Type]
+	if !ok {
+		title = e.Type
+	}
+	fmt.Fprintf(&b, "%s on %s", title, e.Host)
+	if e.Version != "" {
+		fmt.Fprintf(&b, " (version %s)", e.Version)
+	}
+	if len(e.Services) > 0 {
+		fmt.Fprintf(&b, ": %s", strings.Join(e.Services, ", "))
+	}
+	if e.Key != "" {
+		fmt.Fprintf(&b, " [key %s]", e.Key)
+	}
+	if e.Commit != "" {
+		fmt.Fprintf(&b, " @ %s", shortCommit(e.Commit))
+	}
+	if e.Message != "" {
+		fmt.Fprintf(&b, " - %s", e.Message)
+	}
+	if e.Error != "" {
+		fmt.Fprintf(&b, "\nerror: %s", e.Error)
+	}
+	return b.String()
+}
+
+// shortCommit abbreviates a commit SHA the way git does.
+func shortCommit(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
+
+// redact strips the userinfo, path and query of a webhook URL, which
+// usually embed a secret.
+func redact(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return "(invalid URL)"
+	}
+	if u.User == nil && (u.Path == "" || u.Path == "/") && u.RawQuery == "" {
+		return u.Scheme + "://" + u.Host
+	}
+	return u.Scheme + "://" + u.Host + "/..."
+}
+
+// redactError replaces the URL that net/http includes in its errors.
+func redactError(err error) error {
+	var uerr *url.Error
+	if errors.As(err, &uerr) {
+		return fmt.Errorf("%s %s: %w", uerr.Op, redact(uerr.URL), uerr.Err)
+	}
+	return err
+}
@@ -1,6 +1,10 @@
 package selfupdate
 
 import (
+	"fmt"
+	"time"
+
+	"github.com/plark-inc/hostship/systemd"
 	"github.com/spf13/cobra"
 )
 
@@ -8,14 +12,25 @@ import (
 // It keeps the update channel ("prod" or "dev") consistent with the current
 // binary so updates do not switch environments.
 func Command(current, channel *string) *cobra.Command {
-	var verbose bool
+	var window string
 	cmd := &cobra.Command{
 		Use:   "update",
 		Short: "Check for updates and replace the hostship binary",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return Update(*current, *channel, verbose)
+			if window != "" {
+				w, err := systemd.ParseWindow(window)
+				if err != nil {
+					return err
+				}
+				if !w.Contains(time.Now()) {
+					fmt.Printf("outside the maintenance window %s; skipping update\n", w)
+					return nil
+				}
+			}
+			return Update(*current, *channel)
 		},
 	}
-	cmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "verbose output")
+	cmd.Flags().StringVar(&window, "window", "", "only update within this maintenance window, e.g. \"22:00-06:00\"")
+	cmd.Flags().BoolP("verbose", "v", false, "verbose output (same as --log-level debug)")
 	return cmd
 }
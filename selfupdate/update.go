@@ -3,9 +3,11 @@ package selfupdate
 import (
 	"archive/tar"
 	"compress/gzip"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/exec"
@@ -14,6 +16,8 @@ import (
 	"strings"
 
 	semver "github.com/Masterminds/semver/v3"
+	"github.com/plark-inc/hostship/config"
+	"github.com/plark-inc/hostship/notify"
 	"github.com/plark-inc/hostship/systemd"
 )
 
@@ -26,11 +30,9 @@ type releaseInfo struct {
 
 // Update checks the latest release info for the given channel and replaces the
 // current executable if a newer version is available.
-func Update(current, channel string, verbose bool) error {
+func Update(current, channel string) error {
 	infoURL := fmt.Sprintf("%s/%s/metadata.json", baseURL, channel)
-	if verbose {
-		fmt.Printf("fetching release info from %s\n", infoURL)
-	}
+	slog.Debug("fetching release info", "url", infoURL)
 	info, err := fetchInfo(infoURL)
 	if err != nil {
 		return err
@@ -44,9 +46,7 @@ func Update(current, channel string, verbose bool) error {
 	if err != nil {
 		return err
 	}
-	if verbose {
-		fmt.Printf("current version: %s, latest: %s\n", curV, latestV)
-	}
+	slog.Debug("checked release", "current", curV.String(), "latest", latestV.String())
 	if !latestV.GreaterThan(curV) {
 		fmt.Println("hostship is up to date")
 		return nil
@@ -55,7 +55,7 @@ func Update(current, channel string, verbose bool) error {
 	file := fmt.Sprintf("hostship_%s_%s.tar.gz", runtime.GOOS, runtime.GOARCH)
 	url := fmt.Sprintf("%s/%s/%s", baseURL, channel, file)
 
-	tmpBin, err := downloadBinary(url, verbose)
+	tmpBin, err := downloadBinary(url)
 	if err != nil {
 		return err
 	}
@@ -63,25 +63,25 @@ func Update(current, channel string, verbose bool) error {
 	if err != nil {
 		return err
 	}
-	if err := replaceBinary(exe, tmpBin, verbose); err != nil {
+	if err := replaceBinary(exe, tmpBin); err != nil {
 		return err
 	}
-	reinstallServiceIfActive(exe, verbose)
+	reinstallServiceIfActive(exe)
+	config.LoadEnv()
+	ev := notify.Event{Type: notify.SelfUpdated, Version: latestV.String(), Message: fmt.Sprintf("from %s (%s)", curV, channel)}
+	// Failed deliveries are logged by the notifier.
+	_ = notify.FromEnv().Notify(context.Background(), ev)
 	return nil
 }
 
-func downloadBinary(url string, verbose bool) (string, error) {
+func downloadBinary(url string) (string, error) {
 	tmpArchive := filepath.Join(os.TempDir(), filepath.Base(url))
-	if verbose {
-		fmt.Printf("downloading %s to %s\n", url, tmpArchive)
-	}
+	slog.Debug("downloading release", "url", url, "path", tmpArchive)
 	if err := download(url, tmpArchive); err != nil {
 		return "", err
 	}
 	tmpBin := filepath.Join(os.TempDir(), "hostship.new")
-	if verbose {
-		fmt.Printf("extracting binary to %s\n", tmpBin)
-	}
+	slog.Debug("extracting binary", "path", tmpBin)
 	if err := extractBinary(tmpArchive, tmpBin); err != nil {
 		return "", err
 	}
@@ -91,12 +91,10 @@ func downloadBinary(url string, verbose bool) (string, error) {
 	return tmpBin, nil
 }
 
-func replaceBinary(exe, newBin string, verbose bool) error {
+func replaceBinary(exe, newBin string) error {
 	backup := exe + ".old"
 	_ = os.Remove(backup)
-	if verbose {
-		fmt.Printf("replacing %s (backup %s)\n", exe, backup)
-	}
+	slog.Debug("replacing binary", "path", exe, "backup", backup)
 	if err := os.Rename(exe, backup); err != nil {
 		return err
 	}
@@ -105,9 +103,7 @@ func replaceBinary(exe, newBin string, verbose bool) error {
 		return err
 	}
 
-	if verbose {
-		fmt.Printf("running %s -v\n", exe)
-	}
+	slog.Debug("running command", "cmd", exe+" -v")
 	out, err := exec.Command(exe, "-v").CombinedOutput()
 	if err != nil {
 		_ = os.Rename(backup, exe)
@@ -199,25 +195,48 @@ func extractBinary(archive, dest string) error {
 }
 
 // reinstallServiceIfActive checks if the hostship systemd service is active and
-// re-installs it so the updated binary takes effect. Any errors are ignored.
-func reinstallServiceIfActive(bin string, verbose bool) {
+// re-installs it so the updated binary takes effect, keeping the options the
+// unit was installed with. When not running as root the user's own unit is
+// checked as well. Any errors are ignored.
+func reinstallServiceIfActive(bin string) {
 	if _, err := exec.LookPath("systemctl"); err != nil {
-		if verbose {
-			fmt.Println("systemctl not found; skipping service reinstall")
-		}
+		slog.Debug("systemctl not found; skipping service reinstall")
 		return
 	}
-	check := exec.Command("systemctl", "is-active", "--quiet", "hostship")
-	if err := check.Run(); err != nil {
-		if verbose {
-			fmt.Println("hostship service not active; skipping reinstall")
+	for _, userUnit := range []bool{false, true} {
+		if userUnit && os.Geteuid() == 0 {
+			break
+		}
+		args := []string{"is-active", "--quiet", "hostship"}
+		if userUnit {
+			args = append([]string{"--user"}, args...)
+		}
+		if err := exec.Command("systemctl", args...).Run(); err != nil {
+			slog.Debug("hostship service not active; skipping reinstall", "user", userUnit)
+			continue
+		}
+		opts, ok, err := systemd.InstalledOptions(userUnit)
+		if err != nil {
+			slog.Warn("failed to read installed unit; skipping reinstall", "err", err)
+			return
+		}
+		if !ok {
+			opts = systemd.DefaultUnitOptions()
+			opts.UserMode = userUnit
+			if userUnit {
+				if opts.StateDir, err = os.UserHomeDir(); err != nil {
+					slog.Warn("failed to find home directory; skipping reinstall", "err", err)
+					return
+				}
+			}
+		}
+		opts.Binary = bin
+		if err := systemd.Remove(userUnit, false); err != nil {
+			slog.Warn("failed to remove service", "err", err)
+		}
+		if err := systemd.Install(opts, false); err != nil {
+			slog.Warn("failed to install service", "err", err)
 		}
 		return
 	}
-	if err := systemd.Remove(false, verbose); err != nil && verbose {
-		fmt.Printf("failed to remove service: %v\n", err)
-	}
-	if err := systemd.Install(bin, false, verbose); err != nil && verbose {
-		fmt.Printf("failed to install service: %v\n", err)
-	}
 }
@@ -0,0 +1,140 @@
+package service
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// Command constructs the `service` command group which installs hostship with
+// whichever service manager runs the host.
+func Command() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "service",
+		Short: "Manage the hostship service with systemd, OpenRC, runit, supervisord or sysvinit",
+	}
+	cmd.AddCommand(installCmd())
+	cmd.AddCommand(removeCmd())
+	cmd.AddCommand(statusCmd())
+	cmd.AddCommand(showCmd())
+	return cmd
+}
+
+func initFlag(c *cobra.Command, name *string) {
+	c.Flags().StringVar(name, "init", "", "service manager: "+names()+" (default detected)")
+}
+
+// optionFlags registers the flags that customize the installed service.
+func optionFlags(c *cobra.Command, opts *Options) {
+	c.Flags().StringVar(&opts.User, "run-as-user", opts.User, "run the listener as this user")
+	c.Flags().StringVar(&opts.Group, "run-as-group", opts.Group, "run the listener with this group")
+	c.Flags().StringVar(&opts.StateDir, "state-dir", opts.StateDir, "working directory holding compose.json and .env")
+	c.Flags().StringVar(&opts.ListenAddr, "listen-addr", opts.ListenAddr, "address of the update endpoint (default :8080)")
+	c.Flags().StringToStringVar(&opts.Environment, "env", nil, "environment variable set for the listener (KEY=VALUE)")
+}
+
+func installCmd() *cobra.Command {
+	var dryRun bool
+	var init string
+	opts := DefaultOptions()
+	c := &cobra.Command{
+		Use:   "install",
+		Short: "Install hostship as a service",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			m, err := ByName(init)
+			if err != nil {
+				return err
+			}
+			bin, err := os.Executable()
+			if err != nil {
+				return err
+			}
+			opts.Binary = bin
+			if err := m.Install(opts, dryRun); err != nil {
+				return err
+			}
+			if !dryRun {
+				fmt.Printf("hostship installed as a %s service\n", m.Name())
+			}
+			return nil
+		},
+	}
+	optionFlags(c, &opts)
+	initFlag(c, &init)
+	c.Flags().BoolVar(&dryRun, "dry-run", false, "print commands without executing")
+	c.Flags().BoolP("verbose", "v", false, "verbose output (same as --log-level debug)")
+	return c
+}
+
+func removeCmd() *cobra.Command {
+	var dryRun bool
+	var init string
+	c := &cobra.Command{
+		Use:   "remove",
+		Short: "Remove the hostship service",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			m, err := ByName(init)
+			if err != nil {
+				return err
+			}
+			return m.Remove(dryRun)
+		},
+	}
+	initFlag(c, &init)
+	c.Flags().BoolVar(&dryRun, "dry-run", false, "print commands without executing")
+	c.Flags().BoolP("verbose", "v", false, "verbose output (same as --log-level debug)")
+	return c
+}
+
+func statusCmd() *cobra.Command {
+	var init string
+	c := &cobra.Command{
+		Use:   "status",
+		Short: "Show the status of the hostship service",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			m, err := ByName(init)
+			if err != nil {
+				return err
+			}
+			return m.Status()
+		},
+	}
+	initFlag(c, &init)
+	c.Flags().BoolP("verbose", "v", false, "verbose output (same as --log-level debug)")
+	return c
+}
+
+func showCmd() *cobra.Command {
+	var init string
+	opts := DefaultOptions()
+	c := &cobra.Command{
+		Use:   "show",
+		Short: "Print the files install would write",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			m, err := ByName(init)
+			if err != nil {
+				return err
+			}
+			bin, err := os.Executable()
+			if err != nil {
+				return err
+			}
+			opts.Binary = bin
+			files, err := m.Files(opts)
+			if err != nil {
+				return err
+			}
+			for i, p := range sortedPaths(files) {
+				if i > 0 {
+					fmt.Println()
+				}
+				fmt.Printf("# %s\n%s", p, files[p])
+			}
+			return nil
+		},
+	}
+	optionFlags(c, &opts)
+	initFlag(c, &init)
+	return c
+}
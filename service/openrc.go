@@ -0,0 +1,66 @@
+package service
+
+import (
+	_ "embed"
+	"strings"
+	"text/template"
+)
+
+// openrcScript is where the OpenRC init script is installed.
+const openrcScript = "/etc/init.d/hostship"
+
+//go:embed openrc.tmpl
+var openrcSource string
+
+var openrcTemplate = template.Must(template.New("openrc").Funcs(template.FuncMap{"shquote": shquote, "shjoin": shjoin}).Parse(openrcSource))
+
+// openrcManager installs an init script run by supervise-daemon, which
+// restarts the listener when it exits and forwards SIGHUP on reload.
+type openrcManager struct{}
+
+func (openrcManager) Name() string { return "openrc" }
+
+func (openrcManager) Files(opts Options) (map[string]string, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	err := openrcTemplate.Execute(&b, struct {
+		Options
+		Args  []string
+		Owner string
+	}{opts, opts.args(), opts.owner()})
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{openrcScript: b.String()}, nil
+}
+
+func (m openrcManager) Install(opts Options, dryRun bool) error {
+	files, err := m.Files(opts)
+	if err != nil {
+		return err
+	}
+	if err := writeFiles(files, 0755, dryRun); err != nil {
+		return err
+	}
+	return runCommands([][]string{
+		{"rc-update", "add", "hostship", "default"},
+		{"rc-service", "hostship", "restart"},
+	}, dryRun)
+}
+
+func (openrcManager) Remove(dryRun bool) error {
+	if !dryRun && !exists(openrcScript) {
+		return nil
+	}
+	return runCommands([][]string{
+		{"rc-service", "hostship", "stop"},
+		{"rc-update", "del", "hostship", "default"},
+		{"rm", "-f", openrcScript},
+	}, dryRun)
+}
+
+func (openrcManager) Status() error {
+	return runCommands([][]string{{"rc-service", "hostship", "status"}}, false)
+}
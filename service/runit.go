@@ -0,0 +1,101 @@
+package service
+
+import (
+	_ "embed"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// runitDir holds the run scripts of the hostship service. It is linked into
+// the service directory watched by runsvdir.
+const runitDir = "/etc/sv/hostship"
+
+//go:embed runit.tmpl
+var runitSource string
+
+var runitTemplate = template.Must(template.New("runit").Funcs(template.FuncMap{"shquote": shquote, "shjoin": shjoin}).Parse(runitSource))
+
+// runitServiceDir returns the directory runsvdir supervises: /var/service on
+// Void Linux, /etc/service elsewhere, or "" when neither exists.
+func runitServiceDir() string {
+	for _, dir := range []string{"/var/service", "/etc/service"} {
+		if exists(dir) {
+			return dir
+		}
+	}
+	return ""
+}
+
+// runitLink returns the link to runitDir in the service directory. sv is
+// given this path since its default directory differs between distributions.
+func runitLink() string {
+	dir := runitServiceDir()
+	if dir == "" {
+		dir = "/etc/service"
+	}
+	return filepath.Join(dir, "hostship")
+}
+
+// runitManager installs a runit service, which also suits plain supervision
+// setups with runsvdir. Output is kept by svlogd in /var/log/hostship.
+type runitManager struct{}
+
+func (runitManager) Name() string { return "runit" }
+
+func (runitManager) Files(opts Options) (map[string]string, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	data := struct {
+		Options
+		Args  []string
+		Owner string
+	}{opts, opts.args(), opts.owner()}
+	files := make(map[string]string)
+	for name, path := range map[string]string{"run": "run", "log": "log/run"} {
+		var b strings.Builder
+		if err := runitTemplate.ExecuteTemplate(&b, name, data); err != nil {
+			return nil, err
+		}
+		files[filepath.Join(runitDir, path)] = b.String()
+	}
+	return files, nil
+}
+
+func (m runitManager) Install(opts Options, dryRun bool) error {
+	files, err := m.Files(opts)
+	if err != nil {
+		return err
+	}
+	link := runitLink()
+	// runsvdir starts a newly linked service by itself; an installed one
+	// is restarted to pick up the new run script.
+	_, err = os.Lstat(link)
+	installed := err == nil
+	if err := writeFiles(files, 0755, dryRun); err != nil {
+		return err
+	}
+	if installed {
+		return runCommands([][]string{{"sv", "restart", link}}, dryRun)
+	}
+	return runCommands([][]string{{"ln", "-s", runitDir, link}}, dryRun)
+}
+
+func (runitManager) Remove(dryRun bool) error {
+	link := runitLink()
+	var cmds [][]string
+	if _, err := os.Lstat(link); err == nil || dryRun {
+		// Wait as long as in-flight deploys may take to drain.
+		cmds = append(cmds, []string{"sv", "-w", "90", "down", link}, []string{"rm", "-f", link})
+	}
+	if exists(runitDir) || dryRun {
+		cmds = append(cmds, []string{"rm", "-rf", runitDir})
+	}
+	return runCommands(cmds, dryRun)
+}
+
+func (runitManager) Status() error {
+	return runCommands([][]string{{"sv", "status", runitLink()}}, false)
+}
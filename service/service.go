@@ -0,0 +1,241 @@
+// Package service installs the hostship listener with the host's service
+// manager: systemd, OpenRC, runit, supervisord or sysvinit, detected at
+// install time.
+package service
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Options configure the installed service. Every backend supports them; use
+// `hostship systemd install` for systemd-only settings such as sandboxing.
+type Options struct {
+	// Binary is the hostship executable started by the service.
+	Binary string
+	// User and Group run the listener as an unprivileged account, which
+	// must be allowed to use the docker socket.
+	User  string
+	Group string
+	// StateDir is the working directory holding compose.json and .env.
+	StateDir string
+	// ListenAddr overrides the address of the update endpoint.
+	ListenAddr  string
+	Environment map[string]string
+}
+
+// DefaultOptions returns the options of a plain install.
+func DefaultOptions() Options {
+	return Options{StateDir: "/root"}
+}
+
+// envName matches the environment variable names accepted by --env.
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func (o Options) validate() error {
+	if o.Binary == "" {
+		return fmt.Errorf("missing binary path")
+	}
+	if !strings.HasPrefix(o.StateDir, "/") {
+		return fmt.Errorf("state dir must be an absolute path: %q", o.StateDir)
+	}
+	if o.Group != "" && o.User == "" {
+		return fmt.Errorf("--run-as-group needs --run-as-user")
+	}
+	values := []string{o.Binary, o.User, o.Group, o.StateDir, o.ListenAddr}
+	for k, v := range o.Environment {
+		if !envName.MatchString(k) {
+			return fmt.Errorf("invalid environment variable name %q", k)
+		}
+		values = append(values, v)
+	}
+	for _, v := range values {
+		if strings.ContainsAny(v, "\n\r") {
+			return fmt.Errorf("service values cannot contain newlines: %q", v)
+		}
+	}
+	if strings.ContainsAny(o.User+o.Group, " \t:") {
+		return fmt.Errorf("user and group cannot contain spaces or colons")
+	}
+	return nil
+}
+
+// owner returns "user[:group]" as chpst, OpenRC and start-stop-daemon expect
+// it.
+func (o Options) owner() string {
+	if o.Group == "" {
+		return o.User
+	}
+	return o.User + ":" + o.Group
+}
+
+// args returns the arguments the service starts hostship with.
+func (o Options) args() []string {
+	args := []string{"hotreload"}
+	if o.ListenAddr != "" {
+		args = append(args, "--listen-addr", o.ListenAddr)
+	}
+	return args
+}
+
+// Manager installs, removes and reports on the hostship service.
+type Manager interface {
+	// Name is the value of --init selecting the manager.
+	Name() string
+	// Files renders the files Install writes, keyed by path.
+	Files(opts Options) (map[string]string, error)
+	Install(opts Options, dryRun bool) error
+	Remove(dryRun bool) error
+	Status() error
+}
+
+// managers lists the supported service managers in detection order.
+var managers = []struct {
+	m        Manager
+	detected func() bool
+}{
+	{systemdManager{}, func() bool { return exists("/run/systemd/system") }},
+	{openrcManager{}, func() bool { return exists("/run/openrc") || onPath("openrc-run") }},
+	{runitManager{}, func() bool { return onPath("runsvdir") && runitServiceDir() != "" }},
+	{supervisordManager{}, func() bool { return onPath("supervisorctl") && onPath("supervisord") }},
+	// sysvinit comes last: OpenRC and supervisord hosts often have
+	// /etc/init.d and update-rc.d too.
+	{sysvinitManager{}, func() bool { return onPath("update-rc.d") && onPath("start-stop-daemon") && exists("/etc/init.d") }},
+}
+
+// Detect returns the service manager running the host.
+func Detect() (Manager, error) {
+	for _, d := range managers {
+		if d.detected() {
+			slog.Debug("detected service manager", "init", d.m.Name())
+			return d.m, nil
+		}
+	}
+	return nil, fmt.Errorf("no supported service manager found; use --init with %s", names())
+}
+
+// ByName returns the service manager selected with --init, detecting it when
+// name is empty.
+func ByName(name string) (Manager, error) {
+	if name == "" {
+		return Detect()
+	}
+	for _, d := range managers {
+		if d.m.Name() == name {
+			return d.m, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown service manager %q: use %s", name, names())
+}
+
+func names() string {
+	var n []string
+	for _, d := range managers {
+		n = append(n, d.m.Name())
+	}
+	return strings.Join(n, ", ")
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func onPath(name string) bool {
+	_, err := exec.LookPath(name)
+	return err == nil
+}
+
+// runCommands runs cmds with sudo when not root. When dryRun is true the
+// commands are printed without executing.
+func runCommands(cmds [][]string, dryRun bool) error {
+	for _, args := range cmds {
+		if os.Geteuid() != 0 {
+			args = append([]string{"sudo"}, args...)
+		}
+		if dryRun {
+			fmt.Println(strings.Join(args, " "))
+			continue
+		}
+		slog.Debug("running command", "cmd", strings.Join(args, " "))
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%s: %w", strings.Join(args, " "), err)
+		}
+	}
+	return nil
+}
+
+// writeFiles writes files with mode, creating their directories. When running
+// as non-root the files are copied using sudo.
+func writeFiles(files map[string]string, mode os.FileMode, dryRun bool) error {
+	for _, path := range sortedPaths(files) {
+		content := files[path]
+		if dryRun {
+			fmt.Printf("installing %s\n", path)
+			continue
+		}
+		slog.Debug("installing service file", "path", path)
+		if os.Geteuid() == 0 {
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			if err := os.WriteFile(path, []byte(content), mode); err != nil {
+				return fmt.Errorf("install %s: %w", path, err)
+			}
+			continue
+		}
+		tmp, err := os.CreateTemp("", "hostship-service-")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		if _, err := tmp.WriteString(content); err != nil {
+			tmp.Close()
+			return err
+		}
+		tmp.Close()
+		cmds := [][]string{
+			{"mkdir", "-p", filepath.Dir(path)},
+			{"install", "-m", fmt.Sprintf("%o", mode), tmp.Name(), path},
+		}
+		if err := runCommands(cmds, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sortedPaths(files map[string]string) []string {
+	paths := make([]string, 0, len(files))
+	for p := range files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// shquote quotes s for a POSIX shell.
+func shquote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./:=@,+") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// shjoin quotes and joins args for a POSIX shell.
+func shjoin(args []string) string {
+	q := make([]string, len(args))
+	for i, a := range args {
+		q[i] = shquote(a)
+	}
+	return strings.Join(q, " ")
+}
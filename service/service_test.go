@@ -0,0 +1,96 @@
+package service
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// testOptions exercise quoting with shell and supervisord metacharacters in
+// the environment.
+func testOptions() Options {
+	return Options{
+		Binary:     "/usr/local/bin/hostship",
+		User:       "deploy",
+		Group:      "docker",
+		StateDir:   "/srv/hostship",
+		ListenAddr: "127.0.0.1:9090",
+		Environment: map[string]string{
+			"HOSTSHIP_LOG_LEVEL": "debug",
+			"GREETING":           `it's 100% "done"`,
+		},
+	}
+}
+
+func TestFilesGolden(t *testing.T) {
+	noGroup := testOptions()
+	noGroup.Group = ""
+	tests := []struct {
+		m    Manager
+		opts Options
+	}{
+		{systemdManager{}, testOptions()},
+		{openrcManager{}, testOptions()},
+		{runitManager{}, testOptions()},
+		{supervisordManager{}, noGroup},
+		{sysvinitManager{}, testOptions()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.m.Name(), func(t *testing.T) {
+			files, err := tt.m.Files(tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var b strings.Builder
+			for i, p := range sortedPaths(files) {
+				if i > 0 {
+					b.WriteString("\n")
+				}
+				b.WriteString("# " + p + "\n" + files[p])
+			}
+			golden := filepath.Join("testdata", tt.m.Name()+".golden")
+			if *update {
+				if err := os.WriteFile(golden, []byte(b.String()), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b.String() != string(want) {
+				t.Errorf("%s differs from the rendered files (go test ./service -update rewrites it):\n%s", golden, b.String())
+			}
+		})
+	}
+}
+
+func TestFilesRejectInvalidOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(*Options)
+		err  string
+	}{
+		{"relative state dir", func(o *Options) { o.StateDir = "srv" }, "absolute path"},
+		{"group without user", func(o *Options) { o.User = "" }, "--run-as-group needs --run-as-user"},
+		{"newline in value", func(o *Options) { o.Environment["A"] = "1\nB=2" }, "newlines"},
+		{"bad variable name", func(o *Options) { o.Environment["1A"] = "x" }, "invalid environment variable name"},
+		{"colon in user", func(o *Options) { o.User = "deploy:root" }, "colons"},
+	}
+	for _, tt := range tests {
+		for _, d := range managers {
+			opts := testOptions()
+			tt.edit(&opts)
+			if _, err := d.m.Files(opts); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s with %s: error = %v, want %q", d.m.Name(), tt.name, err, tt.err)
+			}
+		}
+	}
+	if _, err := (supervisordManager{}).Files(testOptions()); err == nil || !strings.Contains(err.Error(), "--run-as-group") {
+		t.Errorf("supervisord with a group: error = %v", err)
+	}
+}
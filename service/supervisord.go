@@ -0,0 +1,109 @@
+package service
+
+import (
+	_ "embed"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+//go:embed supervisord.tmpl
+var supervisordSource string
+
+var supervisordTemplate = template.Must(template.New("supervisord").Funcs(template.FuncMap{
+	"shjoin":  shjoin,
+	"percent": percent,
+	"envlist": envlist,
+}).Parse(supervisordSource))
+
+// supervisordConfig returns where the program section is installed: the
+// include directory of Debian's package, or of the RHEL one, which only
+// includes *.ini files.
+func supervisordConfig() string {
+	if !exists("/etc/supervisor/conf.d") && exists("/etc/supervisord.d") {
+		return "/etc/supervisord.d/hostship.ini"
+	}
+	return "/etc/supervisor/conf.d/hostship.conf"
+}
+
+// percent escapes s for supervisord, which expands %(name)s in values.
+func percent(s string) string {
+	return strings.ReplaceAll(s, "%", "%%")
+}
+
+// envlist renders env as the KEY="value",... list of supervisord's
+// environment setting.
+func envlist(env map[string]string) string {
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "%", "%%")
+	for i, k := range keys {
+		pairs[i] = k + `="` + escaper.Replace(env[k]) + `"`
+	}
+	return strings.Join(pairs, ",")
+}
+
+// supervisordManager installs a program section for supervisord, which
+// restarts the listener when it exits and logs to /var/log/hostship.log.
+type supervisordManager struct{}
+
+func (supervisordManager) Name() string { return "supervisord" }
+
+func (supervisordManager) Files(opts Options) (map[string]string, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	// supervisord only switches the user and keeps its primary group.
+	if opts.Group != "" {
+		return nil, fmt.Errorf("supervisord runs the listener with the primary group of --run-as-user; drop --run-as-group")
+	}
+	var b strings.Builder
+	err := supervisordTemplate.Execute(&b, struct {
+		Options
+		Command []string
+	}{opts, append([]string{opts.Binary}, opts.args()...)})
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{supervisordConfig(): b.String()}, nil
+}
+
+func (m supervisordManager) Install(opts Options, dryRun bool) error {
+	files, err := m.Files(opts)
+	if err != nil {
+		return err
+	}
+	// update starts a new program and restarts a changed one; an unchanged
+	// one is restarted to pick up a new binary.
+	installed := exists(supervisordConfig())
+	if err := writeFiles(files, 0644, dryRun); err != nil {
+		return err
+	}
+	cmds := [][]string{{"supervisorctl", "reread"}, {"supervisorctl", "update", "hostship"}}
+	if installed {
+		cmds = append(cmds, []string{"supervisorctl", "restart", "hostship"})
+	}
+	return runCommands(cmds, dryRun)
+}
+
+func (supervisordManager) Remove(dryRun bool) error {
+	config := supervisordConfig()
+	if !dryRun && !exists(config) {
+		return nil
+	}
+	return runCommands([][]string{
+		{"supervisorctl", "stop", "hostship"},
+		{"rm", "-f", config},
+		{"supervisorctl", "reread"},
+		{"supervisorctl", "update", "hostship"},
+	}, dryRun)
+}
+
+func (supervisordManager) Status() error {
+	return runCommands([][]string{{"supervisorctl", "status", "hostship"}}, false)
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"os"
+
+	"github.com/plark-inc/hostship/systemd"
+)
+
+// systemdManager installs the unit rendered by the systemd package.
+type systemdManager struct{}
+
+func (systemdManager) Name() string { return "systemd" }
+
+// unitOptions converts opts, keeping systemd-only settings such as --harden
+// or --auto-update of an installed unit.
+func (systemdManager) unitOptions(opts Options) (systemd.UnitOptions, error) {
+	u, ok, err := systemd.InstalledOptions(false)
+	if err != nil {
+		return u, err
+	}
+	if !ok {
+		u = systemd.DefaultUnitOptions()
+	}
+	u.Binary = opts.Binary
+	u.User = opts.User
+	u.Group = opts.Group
+	u.StateDir = opts.StateDir
+	u.ListenAddr = opts.ListenAddr
+	u.Environment = opts.Environment
+	return u, nil
+}
+
+func (m systemdManager) Files(opts Options) (map[string]string, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	u, err := m.unitOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	unit, err := systemd.RenderUnit(u)
+	if err != nil {
+		return nil, err
+	}
+	path, err := systemd.UnitPath(false)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{path: unit}, nil
+}
+
+func (m systemdManager) Install(opts Options, dryRun bool) error {
+	if err := opts.validate(); err != nil {
+		return err
+	}
+	u, err := m.unitOptions(opts)
+	if err != nil {
+		return err
+	}
+	return systemd.Install(u, dryRun)
+}
+
+func (systemdManager) Remove(dryRun bool) error { return systemd.Remove(false, dryRun) }
+
+func (systemdManager) Status() error {
+	return systemd.Status(os.Stdout, false, 10, systemd.OutputText)
+}
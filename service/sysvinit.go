@@ -0,0 +1,67 @@
+package service
+
+import (
+	_ "embed"
+	"strings"
+	"text/template"
+)
+
+// sysvinitScript is where the LSB init script is installed.
+const sysvinitScript = "/etc/init.d/hostship"
+
+//go:embed sysvinit.tmpl
+var sysvinitSource string
+
+var sysvinitTemplate = template.Must(template.New("sysvinit").Funcs(template.FuncMap{"shquote": shquote, "shjoin": shjoin}).Parse(sysvinitSource))
+
+// sysvinitManager installs an LSB init script for Debian-style sysvinit
+// hosts such as Devuan. start-stop-daemon runs the listener in the
+// background; unlike the other backends nothing restarts it when it exits.
+type sysvinitManager struct{}
+
+func (sysvinitManager) Name() string { return "sysvinit" }
+
+func (sysvinitManager) Files(opts Options) (map[string]string, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	err := sysvinitTemplate.Execute(&b, struct {
+		Options
+		Args  []string
+		Owner string
+	}{opts, opts.args(), opts.owner()})
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{sysvinitScript: b.String()}, nil
+}
+
+func (m sysvinitManager) Install(opts Options, dryRun bool) error {
+	files, err := m.Files(opts)
+	if err != nil {
+		return err
+	}
+	if err := writeFiles(files, 0755, dryRun); err != nil {
+		return err
+	}
+	return runCommands([][]string{
+		{"update-rc.d", "hostship", "defaults"},
+		{sysvinitScript, "restart"},
+	}, dryRun)
+}
+
+func (sysvinitManager) Remove(dryRun bool) error {
+	if !dryRun && !exists(sysvinitScript) {
+		return nil
+	}
+	return runCommands([][]string{
+		{sysvinitScript, "stop"},
+		{"rm", "-f", sysvinitScript},
+		{"update-rc.d", "hostship", "remove"},
+	}, dryRun)
+}
+
+func (sysvinitManager) Status() error {
+	return runCommands([][]string{{sysvinitScript, "status"}}, false)
+}
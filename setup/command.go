@@ -7,12 +7,14 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"os"
+	"time"
 
-	"github.com/google/uuid"
 	"github.com/plark-inc/hostship/config"
 	"github.com/plark-inc/hostship/docker"
+	"github.com/plark-inc/hostship/keys"
 	"github.com/spf13/cobra"
 )
 
@@ -25,7 +27,8 @@ const defaultComposeURL = "https://cli.plark.com/compose.json"
 // behave the same as in other commands.
 func Command() *cobra.Command {
 	var dryRun bool
-	var verbose bool
+	var withTLS bool
+	var keyFile bool
 	cmd := &cobra.Command{
 		Use:   "setup [compose_url]",
 		Short: "Install Docker and download the compose configuration",
@@ -37,21 +40,23 @@ func Command() *cobra.Command {
 			if len(args) == 1 {
 				composeURL = args[0]
 			}
-			return runSetup(dryRun, verbose, composeURL)
+			return runSetup(dryRun, withTLS, keyFile, composeURL)
 		},
 	}
 	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "print commands without executing")
-	cmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "verbose output")
+	cmd.Flags().BoolP("verbose", "v", false, "verbose output (same as --log-level debug)")
+	cmd.Flags().BoolVar(&withTLS, "tls", false, "generate a self-signed certificate and serve the listener over HTTPS")
+	cmd.Flags().BoolVar(&keyFile, "key-file", false, "keep the deploy key in deploy.key instead of embedding it in DEPLOY_URL")
 	return cmd
 }
 
 // runSetup installs Docker if required and downloads the compose file,
-// overwriting any existing configuration.
-func runSetup(dryRun, verbose bool, composeURL string) error {
+// overwriting any existing configuration. With withTLS a self-signed key pair
+// is generated for the listener and referenced from .env. With keyFile the
+// default key is written to deploy.key and DEPLOY_URL ends in /update.
+func runSetup(dryRun, withTLS, keyFile bool, composeURL string) error {
 	cfgPath := config.Path
-	if verbose {
-		fmt.Printf("downloading compose file to %s\n", cfgPath)
-	}
+	slog.Debug("downloading compose file", "url", composeURL, "path", cfgPath)
 	resp, err := http.Get(composeURL)
 	if err != nil {
 		return err
@@ -67,22 +72,51 @@ func runSetup(dryRun, verbose bool, composeURL string) error {
 	if err := os.WriteFile(cfgPath, data, 0644); err != nil {
 		return err
 	}
-	if err := docker.EnsureInstalled(dryRun, verbose); err != nil {
+	if err := docker.EnsureInstalled(dryRun); err != nil {
 		return err
 	}
-	if err := docker.EnsureComposeInstalled(dryRun, verbose); err != nil {
+	if err := docker.EnsureComposeInstalled(dryRun); err != nil {
 		return err
 	}
 	if _, err := os.Stat(".env"); errors.Is(err, os.ErrNotExist) {
-		id := uuid.New().String()
-		deployURL := fmt.Sprintf("http://172.17.0.1:8080/update/%s", id)
-		content := fmt.Sprintf("DEPLOY_URL=%s\n", deployURL)
-		if verbose {
-			fmt.Printf("creating .env with DEPLOY_URL=%s\n", deployURL)
+		store, err := keys.Load(config.KeysPath)
+		if err != nil {
+			return err
+		}
+		if _, ok := store.Get(keys.DefaultName); ok {
+			slog.Warn("replacing deploy key since .env is missing", "key", keys.DefaultName)
+		}
+		id, err := store.Create(keys.DefaultName, keys.AllScopes, time.Time{})
+		if err != nil {
+			return err
+		}
+		if err := store.Save(); err != nil {
+			return err
 		}
+		deployURL, err := keys.UpdateURL(keys.DefaultDeployURL, id)
+		if err != nil {
+			return err
+		}
+		if keyFile {
+			// The secret goes to its own file so .env, which compose reads
+			// for variable substitution, holds no usable key.
+			slog.Debug("writing deploy key", "path", config.DeployKeyPath)
+			if err := keys.WriteDeployKey(id); err != nil {
+				return err
+			}
+			deployURL = keys.DefaultDeployURL
+		}
+		content := fmt.Sprintf("DEPLOY_URL=%s\n", deployURL)
+		slog.Debug("creating .env")
 		if err := os.WriteFile(".env", []byte(content), 0600); err != nil {
 			return err
 		}
 	}
+	if withTLS {
+		if err := generateSelfSigned(tlsCertPath, tlsKeyPath); err != nil {
+			return err
+		}
+		return enableTLSEnv(tlsCertPath, tlsKeyPath)
+	}
 	return nil
 }
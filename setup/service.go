@@ -11,23 +11,23 @@ import (
 // Docker and Docker Compose are verified to be installed before the containers
 // are started. When dryRun is true Docker commands are printed but not
 // executed.
-func StartService(dryRun, verbose bool) error {
+func StartService(dryRun bool) error {
 	cfgPath := config.Path
 
-	if err := ensureDockerAvailable(dryRun, verbose); err != nil {
+	if err := ensureDockerAvailable(dryRun); err != nil {
 		return err
 	}
 
-	c := docker.NewComposeClient(dryRun, verbose)
+	c := docker.NewComposeClient(dryRun)
 	if _, err := c.Pull(cfgPath, "hostship"); err != nil {
 		return err
 	}
 	return c.Up(cfgPath, "hostship")
 }
 
-func ensureDockerAvailable(dryRun, verbose bool) error {
-	if err := docker.EnsureInstalled(dryRun, verbose); err != nil {
+func ensureDockerAvailable(dryRun bool) error {
+	if err := docker.EnsureInstalled(dryRun); err != nil {
 		return err
 	}
-	return docker.EnsureComposeInstalled(dryRun, verbose)
+	return docker.EnsureComposeInstalled(dryRun)
 }
@@ -0,0 +1,104 @@
+// Helpers for bootstrapping TLS on the hot-reload listener with a self-signed
+// certificate.
+package setup
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Default locations of the self-signed key pair generated by setup --tls.
+const (
+	tlsCertPath = "tls/cert.pem"
+	tlsKeyPath  = "tls/key.pem"
+)
+
+// generateSelfSigned writes a self-signed ECDSA certificate valid for the
+// docker bridge address, localhost and the host name. Existing files are kept
+// so re-running setup does not invalidate certificates pinned by clients.
+func generateSelfSigned(certPath, keyPath string) error {
+	if _, err := os.Stat(certPath); err == nil {
+		slog.Debug("keeping existing certificate", "path", certPath)
+		return nil
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+	host, _ := os.Hostname()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "hostship"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(2, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("172.17.0.1"), net.ParseIP("127.0.0.1")},
+	}
+	if host != "" && host != "localhost" {
+		tmpl.DNSNames = append(tmpl.DNSNames, host)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(certPath), 0700); err != nil {
+		return err
+	}
+	slog.Debug("writing self-signed certificate", "path", certPath)
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+// enableTLSEnv points the listener at the key pair in .env and switches
+// DEPLOY_URL to https. Variables already present are left untouched.
+func enableTLSEnv(certPath, keyPath string) error {
+	data, err := os.ReadFile(".env")
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	has := map[string]bool{}
+	for i, line := range lines {
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		key = strings.TrimSpace(key)
+		has[key] = true
+		if key == "DEPLOY_URL" {
+			lines[i] = "DEPLOY_URL=" + strings.Replace(strings.TrimSpace(value), "http://", "https://", 1)
+		}
+	}
+	if !has["HOSTSHIP_TLS_CERT"] {
+		lines = append(lines, "HOSTSHIP_TLS_CERT="+certPath)
+	}
+	if !has["HOSTSHIP_TLS_KEY"] {
+		lines = append(lines, "HOSTSHIP_TLS_KEY="+keyPath)
+	}
+	return os.WriteFile(".env", []byte(strings.Join(lines, "\n")+"\n"), 0600)
+}
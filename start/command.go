@@ -9,15 +9,14 @@ import (
 // defined in the compose configuration.
 func Command() *cobra.Command {
 	var dryRun bool
-	var verbose bool
 	cmd := &cobra.Command{
 		Use:   "start",
 		Short: "Start the Docker compose services",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return setup.StartService(dryRun, verbose)
+			return setup.StartService(dryRun)
 		},
 	}
 	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "print commands without executing")
-	cmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "verbose output")
+	cmd.Flags().BoolP("verbose", "v", false, "verbose output (same as --log-level debug)")
 	return cmd
 }
@@ -16,52 +16,113 @@ func Command() *cobra.Command {
 	cmd.AddCommand(installCmd())
 	cmd.AddCommand(removeCmd())
 	cmd.AddCommand(statusCmd())
+	cmd.AddCommand(showCmd())
 	return cmd
 }
 
+// userFlag registers --user, which selects the user's own service manager
+// like `systemctl --user`.
+func userFlag(c *cobra.Command, userUnit *bool) {
+	c.Flags().BoolVar(userUnit, "user", false, "manage a user unit in ~/.config/systemd/user with systemctl --user (rootless Docker)")
+}
+
+// unitFlags registers the flags that customize the rendered unit.
+func unitFlags(c *cobra.Command, opts *UnitOptions) {
+	userFlag(c, &opts.UserMode)
+	c.Flags().StringVar(&opts.User, "run-as-user", opts.User, "run the listener as this user")
+	c.Flags().StringVar(&opts.Group, "run-as-group", opts.Group, "run the listener with this group")
+	c.Flags().StringVar(&opts.StateDir, "state-dir", opts.StateDir, "working directory holding compose.json and .env (default /root, home directory with --user)")
+	c.Flags().StringVar(&opts.ListenAddr, "listen-addr", opts.ListenAddr, "address of the update endpoint (default :8080)")
+	c.Flags().StringVar(&opts.EnvironmentFile, "environment-file", opts.EnvironmentFile, "file with environment variables loaded by systemd")
+	c.Flags().StringToStringVar(&opts.Environment, "env", nil, "environment variable set in the unit (KEY=VALUE)")
+	c.Flags().StringVar(&opts.Restart, "restart", opts.Restart, "restart policy (no, always, on-failure, ...)")
+	c.Flags().BoolVar(&opts.Harden, "harden", false, "enable systemd sandboxing (read-only system, no new privileges)")
+	c.Flags().StringVar(&opts.AutoUpdate, "auto-update", "", "run hostship update hourly, daily, weekly or on a systemd calendar expression")
+	c.Flags().StringVar(&opts.UpdateWindow, "update-window", "", "only update within this maintenance window, e.g. \"22:00-06:00\" or \"Sat,Sun 02:00-08:00\"")
+}
+
+// completeOptions fills in the binary and, for user units, a state dir in
+// the home directory unless one was given.
+func completeOptions(cmd *cobra.Command, opts *UnitOptions) error {
+	bin, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	opts.Binary = bin
+	if opts.UserMode && !cmd.Flags().Changed("state-dir") {
+		if opts.StateDir, err = os.UserHomeDir(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func installCmd() *cobra.Command {
 	var dryRun bool
-	var verbose bool
+	opts := DefaultUnitOptions()
 	c := &cobra.Command{
 		Use:   "install",
 		Short: "Install hostship as a systemd service",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			bin, err := os.Executable()
-			if err != nil {
+			if err := completeOptions(cmd, &opts); err != nil {
 				return err
 			}
-			return Install(bin, dryRun, verbose)
+			return Install(opts, dryRun)
 		},
 	}
+	unitFlags(c, &opts)
 	c.Flags().BoolVar(&dryRun, "dry-run", false, "print commands without executing")
-	c.Flags().BoolVarP(&verbose, "verbose", "v", false, "verbose output")
+	c.Flags().BoolP("verbose", "v", false, "verbose output (same as --log-level debug)")
 	return c
 }
 
 func removeCmd() *cobra.Command {
-	var dryRun bool
-	var verbose bool
+	var dryRun, userUnit bool
 	c := &cobra.Command{
 		Use:   "remove",
 		Short: "Remove the hostship systemd service",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return Remove(dryRun, verbose)
+			return Remove(userUnit, dryRun)
 		},
 	}
+	userFlag(c, &userUnit)
 	c.Flags().BoolVar(&dryRun, "dry-run", false, "print commands without executing")
-	c.Flags().BoolVarP(&verbose, "verbose", "v", false, "verbose output")
+	c.Flags().BoolP("verbose", "v", false, "verbose output (same as --log-level debug)")
+	return c
+}
+
+func showCmd() *cobra.Command {
+	var diff bool
+	opts := DefaultUnitOptions()
+	c := &cobra.Command{
+		Use:   "show",
+		Short: "Print the unit install would write",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := completeOptions(cmd, &opts); err != nil {
+				return err
+			}
+			return Show(os.Stdout, opts, diff)
+		},
+	}
+	unitFlags(c, &opts)
+	c.Flags().BoolVar(&diff, "diff", false, "show the changes from the installed unit")
 	return c
 }
 
 func statusCmd() *cobra.Command {
-	var verbose bool
+	var userUnit bool
+	var lines int
+	var output string
 	c := &cobra.Command{
 		Use:   "status",
 		Short: "Show the status of the systemd service",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return Status(verbose)
+			return Status(os.Stdout, userUnit, lines, output)
 		},
 	}
-	c.Flags().BoolVarP(&verbose, "verbose", "v", false, "verbose output")
+	userFlag(c, &userUnit)
+	c.Flags().IntVarP(&lines, "lines", "n", 10, "number of recent journal lines to show")
+	c.Flags().StringVarP(&output, "output", "o", OutputText, "output format: text or json")
+	c.Flags().BoolP("verbose", "v", false, "verbose output (same as --log-level debug)")
 	return c
 }
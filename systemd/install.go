@@ -3,44 +3,57 @@
 package systemd
 
 import (
-	_ "embed"
 	"fmt"
+	"log/slog"
 	"os"
 	"os/exec"
+	"os/user"
 	"path/filepath"
 	"strings"
 )
 
-//go:embed hostship.service
-var unitTemplate string
-
-// Install writes the systemd unit file and enables it so the hostship update
-// listener starts automatically on boot. The provided binary and configuration
-// paths are embedded into the unit file. When dryRun is true the steps are only
-// printed.
-func Install(binPath string, dryRun, verbose bool) error {
-	path := "/etc/systemd/system/hostship.service"
-	unit := strings.ReplaceAll(unitTemplate, "/usr/local/bin/hostship", binPath)
-
-	if verbose || dryRun {
-		fmt.Printf("installing unit file to %s\n", path)
+// Install renders the systemd unit for opts, writes it and enables it so the
+// hostship update listener starts automatically on boot. With AutoUpdate the
+// update service and timer are installed too, otherwise ones left by an
+// earlier install are removed. User units are installed for the user's own
+// service manager, with lingering enabled so they run without a login
+// session. When dryRun is true the steps are only printed.
+func Install(opts UnitOptions, dryRun bool) error {
+	files, err := renderUnits(opts)
+	if err != nil {
+		return err
 	}
 
-	if !dryRun {
-		if err := writeUnitFile(path, unit); err != nil {
+	for _, f := range files {
+		if dryRun {
+			fmt.Printf("installing unit file to %s\n", f.path)
+			continue
+		}
+		slog.Debug("installing unit file", "path", f.path)
+		if err := writeUnitFile(f.path, f.content, opts.UserMode); err != nil {
+			return err
+		}
+	}
+	if opts.AutoUpdate == "" {
+		if err := removeUpdateUnits(opts.UserMode, dryRun); err != nil {
 			return err
 		}
 	}
 
-	return enableService(dryRun, verbose)
+	return enableService(opts.UserMode, opts.AutoUpdate != "", dryRun)
 }
 
-// writeUnitFile writes the hostship systemd unit file to the given path. When
-// running as non-root the file is copied using sudo.
-func writeUnitFile(path, unit string) error {
+// writeUnitFile writes a systemd unit file to the given path. When installing
+// a system unit as non-root the file is copied using sudo.
+func writeUnitFile(path, unit string, userUnit bool) error {
+	if userUnit {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return fmt.Errorf("install unit: %w", err)
+		}
+	}
 	if err := os.WriteFile(path, []byte(unit), 0644); err != nil {
-		if os.Geteuid() != 0 {
-			tmp := filepath.Join(os.TempDir(), "hostship.service")
+		if !userUnit && os.Geteuid() != 0 {
+			tmp := filepath.Join(os.TempDir(), filepath.Base(path))
 			if err2 := os.WriteFile(tmp, []byte(unit), 0644); err2 != nil {
 				return fmt.Errorf("write temp unit: %w", err2)
 			}
@@ -54,24 +67,45 @@ func writeUnitFile(path, unit string) error {
 	return nil
 }
 
-// enableService reloads systemd and enables the hostship service. When dryRun is
-// true, the commands are printed without executing.
-func enableService(dryRun, verbose bool) error {
-	cmds := [][]string{
-		{"systemctl", "daemon-reload"},
-		{"systemctl", "enable", "--now", "hostship"},
-		{"systemctl", "restart", "hostship"},
+// systemctl returns the systemctl command line for args. User units are
+// managed with --user; system units need sudo when not running as root.
+func systemctl(userUnit bool, args ...string) []string {
+	if userUnit {
+		return append([]string{"systemctl", "--user"}, args...)
 	}
-	for _, args := range cmds {
-		if os.Geteuid() != 0 {
-			args = append([]string{"sudo"}, args...)
-		}
-		if verbose || dryRun {
-			fmt.Println(strings.Join(args, " "))
+	args = append([]string{"systemctl"}, args...)
+	if os.Geteuid() != 0 {
+		args = append([]string{"sudo"}, args...)
+	}
+	return args
+}
+
+// enableService reloads systemd and enables the hostship service and, with
+// updateTimer, the update timer. For user units lingering is enabled first.
+// When dryRun is true, the commands are printed without executing.
+func enableService(userUnit, updateTimer, dryRun bool) error {
+	var cmds [][]string
+	if userUnit {
+		u, err := user.Current()
+		if err != nil {
+			return err
 		}
+		cmds = append(cmds, []string{"loginctl", "enable-linger", u.Username})
+	}
+	cmds = append(cmds,
+		systemctl(userUnit, "daemon-reload"),
+		systemctl(userUnit, "enable", "--now", "hostship"),
+		systemctl(userUnit, "restart", "hostship"),
+	)
+	if updateTimer {
+		cmds = append(cmds, systemctl(userUnit, "enable", "--now", "hostship-update.timer"))
+	}
+	for _, args := range cmds {
 		if dryRun {
+			fmt.Println(strings.Join(args, " "))
 			continue
 		}
+		slog.Debug("running command", "cmd", strings.Join(args, " "))
 		cmd := exec.Command(args[0], args[1:]...)
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
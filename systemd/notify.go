@@ -0,0 +1,83 @@
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Notify sends state to the service manager over $NOTIFY_SOCKET, e.g.
+// "READY=1" or "STATUS=idle". Several assignments may be separated by
+// newlines. It reports false without error when the process was not started
+// by systemd with Type=notify.
+func Notify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+	// A leading @ names a socket in the abstract namespace.
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, fmt.Errorf("notify: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, fmt.Errorf("notify: %w", err)
+	}
+	return true, nil
+}
+
+// Ready tells the service manager that startup has finished.
+func Ready() error {
+	_, err := Notify("READY=1")
+	return err
+}
+
+// SetStatus updates the status line shown by `systemctl status`.
+func SetStatus(status string) error {
+	// A newline would start a new assignment.
+	_, err := Notify("STATUS=" + strings.ReplaceAll(status, "\n", " "))
+	return err
+}
+
+// WatchdogInterval returns how often the service must ping the watchdog, or
+// 0 when WatchdogSec is not set for this process. Pings are due at half the
+// configured timeout so a late one does not trip it.
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// Watchdog pings the service manager's watchdog every WatchdogInterval while
+// alive reports true, until stop is closed. It returns immediately when the
+// watchdog is disabled.
+func Watchdog(stop <-chan struct{}, alive func() bool) {
+	interval := WatchdogInterval()
+	if interval == 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		if alive == nil || alive() {
+			_, _ = Notify("WATCHDOG=1")
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package systemd
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// listen binds a notify socket in a temporary directory, points
+// NOTIFY_SOCKET at it and returns a function reading the next message.
+func listen(t *testing.T) func() (string, bool) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", path)
+	return func() (string, bool) {
+		buf := make([]byte, 4096)
+		_ = conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, err := conn.Read(buf)
+		if err != nil {
+			return "", false
+		}
+		return string(buf[:n]), true
+	}
+}
+
+func TestNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if sent, err := Notify("READY=1"); sent || err != nil {
+		t.Errorf("Notify without a socket = %v, %v", sent, err)
+	}
+
+	read := listen(t)
+	tests := []struct {
+		name string
+		send func() error
+		want string
+	}{
+		{"ready", Ready, "READY=1"},
+		{"stopping", func() error { _, err := Notify("STOPPING=1"); return err }, "STOPPING=1"},
+		{"status", func() error { return SetStatus("deploying web") }, "STATUS=deploying web"},
+		{"status with newline", func() error { return SetStatus("failed:\nREADY=1") }, "STATUS=failed: READY=1"},
+		{"several assignments", func() error { _, err := Notify("STATUS=idle\nREADY=1"); return err }, "STATUS=idle\nREADY=1"},
+	}
+	for _, tt := range tests {
+		if err := tt.send(); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got, ok := read(); !ok || got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	t.Setenv("NOTIFY_SOCKET", filepath.Join(t.TempDir(), "missing.sock"))
+	if sent, err := Notify("READY=1"); sent || err == nil {
+		t.Errorf("Notify to a missing socket = %v, %v", sent, err)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+	tests := []struct {
+		usec, pid string
+		want      time.Duration
+	}{
+		{"", "", 0},
+		{"30000000", "", 15 * time.Second},
+		{"30000000", pid, 15 * time.Second},
+		{"30000000", "1", 0},
+		{"0", "", 0},
+		{"junk", "", 0},
+	}
+	for _, tt := range tests {
+		t.Setenv("WATCHDOG_USEC", tt.usec)
+		t.Setenv("WATCHDOG_PID", tt.pid)
+		if got := WatchdogInterval(); got != tt.want {
+			t.Errorf("WATCHDOG_USEC=%q WATCHDOG_PID=%q: %v, want %v", tt.usec, tt.pid, got, tt.want)
+		}
+	}
+}
+
+func TestWatchdog(t *testing.T) {
+	read := listen(t)
+	t.Setenv("WATCHDOG_USEC", "40000")
+	t.Setenv("WATCHDOG_PID", "")
+
+	var alive atomic.Bool
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		Watchdog(stop, alive.Load)
+		close(done)
+	}()
+
+	if got, ok := read(); ok {
+		t.Errorf("pinged while not alive: %q", got)
+	}
+	alive.Store(true)
+	if got, ok := read(); !ok || got != "WATCHDOG=1" {
+		t.Errorf("got %q, want WATCHDOG=1", got)
+	}
+	close(stop)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Watchdog did not return after stop")
+	}
+}
@@ -2,36 +2,65 @@ package systemd
 
 import (
 	"fmt"
+	"log/slog"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 )
 
-// Remove stops and disables the hostship service and removes the systemd unit.
-// When dryRun is true the actions are only printed.
-func Remove(dryRun, verbose bool) error {
-	path := "/etc/systemd/system/hostship.service"
+// Remove stops and disables the hostship service and removes the systemd unit
+// along with the update service and timer. Lingering of user units is left
+// enabled since other user services, such as rootless Docker, may rely on
+// it. When dryRun is true the actions are only printed.
+func Remove(userUnit, dryRun bool) error {
+	if err := removeUpdateUnits(userUnit, dryRun); err != nil {
+		return err
+	}
+	path, err := UnitPath(userUnit)
+	if err != nil {
+		return err
+	}
 	cmds := [][]string{
-		{"systemctl", "disable", "--now", "hostship"},
-		{"systemctl", "daemon-reload"},
+		systemctl(userUnit, "disable", "--now", "hostship"),
+		systemctl(userUnit, "daemon-reload"),
 	}
-	if err := runCommands(cmds, dryRun, verbose); err != nil {
+	if err := runCommands(cmds, dryRun); err != nil {
 		return err
 	}
-	return removeUnitFile(path, dryRun, verbose)
+	return removeUnitFile(path, userUnit, dryRun)
 }
 
-func runCommands(cmds [][]string, dryRun, verbose bool) error {
-	for _, args := range cmds {
-		if os.Geteuid() != 0 {
-			args = append([]string{"sudo"}, args...)
-		}
-		if verbose || dryRun {
-			fmt.Println(strings.Join(args, " "))
+// removeUpdateUnits disables the update timer and removes its units, if
+// installed.
+func removeUpdateUnits(userUnit, dryRun bool) error {
+	dir, err := UnitDir(userUnit)
+	if err != nil {
+		return err
+	}
+	timer := filepath.Join(dir, updateTimerName)
+	if _, err := os.Stat(timer); os.IsNotExist(err) {
+		return nil
+	}
+	cmds := [][]string{systemctl(userUnit, "disable", "--now", updateTimerName)}
+	if err := runCommands(cmds, dryRun); err != nil {
+		return err
+	}
+	for _, path := range []string{timer, filepath.Join(dir, updateServiceName)} {
+		if err := removeUnitFile(path, userUnit, dryRun); err != nil {
+			return err
 		}
+	}
+	return nil
+}
+
+func runCommands(cmds [][]string, dryRun bool) error {
+	for _, args := range cmds {
 		if dryRun {
+			fmt.Println(strings.Join(args, " "))
 			continue
 		}
+		slog.Debug("running command", "cmd", strings.Join(args, " "))
 		cmd := exec.Command(args[0], args[1:]...)
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
@@ -45,18 +74,18 @@ func runCommands(cmds [][]string, dryRun, verbose bool) error {
 	return nil
 }
 
-func removeUnitFile(path string, dryRun, verbose bool) error {
-	if verbose || dryRun {
-		if os.Geteuid() != 0 {
+func removeUnitFile(path string, userUnit, dryRun bool) error {
+	sudo := !userUnit && os.Geteuid() != 0
+	if dryRun {
+		if sudo {
 			fmt.Printf("sudo rm -f %s\n", path)
 		} else {
 			fmt.Printf("rm -f %s\n", path)
 		}
-	}
-	if dryRun {
 		return nil
 	}
-	if os.Geteuid() != 0 {
+	slog.Debug("removing unit file", "path", path)
+	if sudo {
 		return exec.Command("sudo", "rm", "-f", path).Run()
 	}
 	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
@@ -0,0 +1,101 @@
+package systemd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Show prints the units rendered for opts. When there are several, each is
+// preceded by a comment with its path. With diff it prints the changes from
+// the installed units instead.
+func Show(w io.Writer, opts UnitOptions, diff bool) error {
+	files, err := renderUnits(opts)
+	if err != nil {
+		return err
+	}
+	for i, f := range files {
+		if diff {
+			if err := showDiff(w, f); err != nil {
+				return err
+			}
+			continue
+		}
+		if len(files) > 1 {
+			if i > 0 {
+				fmt.Fprintln(w)
+			}
+			fmt.Fprintf(w, "# %s\n", f.path)
+		}
+		if _, err := io.WriteString(w, f.content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func showDiff(w io.Writer, f unitFile) error {
+	installed, err := installedFile(f.path)
+	if err != nil {
+		return err
+	}
+	if installed == "" {
+		fmt.Fprintf(w, "%s is not installed\n", f.path)
+		return nil
+	}
+	if installed == f.content {
+		fmt.Fprintf(w, "%s is up to date\n", f.path)
+		return nil
+	}
+	fmt.Fprintf(w, "--- %s\n+++ rendered\n", f.path)
+	for _, line := range diffLines(splitLines(installed), splitLines(f.content)) {
+		fmt.Fprintln(w, line)
+	}
+	return nil
+}
+
+func splitLines(s string) []string {
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// diffLines returns a line diff of a and b: unchanged lines prefixed with a
+// space, removed ones with "-" and added ones with "+". Units are short, so
+// the longest common subsequence is computed directly.
+func diffLines(a, b []string) []string {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var out []string
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			out = append(out, " "+a[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			out = append(out, "-"+a[i])
+			i++
+		default:
+			out = append(out, "+"+b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		out = append(out, "-"+a[i])
+	}
+	for ; j < len(b); j++ {
+		out = append(out, "+"+b[j])
+	}
+	return out
+}
@@ -1,23 +1,276 @@
 package systemd
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
+	"time"
 )
 
-// Status prints the systemctl status for the hostship service.
-func Status(verbose bool) error {
-	args := []string{"systemctl", "status", "hostship"}
-	if os.Geteuid() != 0 {
-		args = append([]string{"sudo"}, args...)
+// Output formats of Status.
+const (
+	OutputText = "text"
+	OutputJSON = "json"
+)
+
+// statusProperties are the unit properties queried with `systemctl show`.
+var statusProperties = []string{"LoadState", "ActiveState", "SubState", "MainPID", "ExecMainStartTimestamp", "NRestarts", "MemoryCurrent", "StatusText"}
+
+// ServiceStatus describes the state of the hostship unit.
+type ServiceStatus struct {
+	Unit        string     `json:"unit"`
+	Installed   bool       `json:"installed"`
+	ActiveState string     `json:"active_state"`
+	SubState    string     `json:"sub_state"`
+	MainPID     int        `json:"main_pid,omitempty"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	Restarts    int        `json:"restarts"`
+	// MemoryBytes is the memory used by the unit's cgroup, 0 when unknown.
+	MemoryBytes uint64 `json:"memory_bytes,omitempty"`
+	// StatusText is the status the listener reports over sd_notify, such as
+	// the phase of the deploy in progress.
+	StatusText string        `json:"status_text,omitempty"`
+	Journal    []JournalLine `json:"journal,omitempty"`
+	// JournalError says why the journal could not be read.
+	JournalError string `json:"journal_error,omitempty"`
+}
+
+// JournalLine is an entry of the unit's journal.
+type JournalLine struct {
+	Time     time.Time `json:"time"`
+	Priority int       `json:"priority"`
+	Message  string    `json:"message"`
+}
+
+// Active reports whether the service is running or starting.
+func (s *ServiceStatus) Active() bool {
+	return s.ActiveState == "active" || s.ActiveState == "activating" || s.ActiveState == "reloading"
+}
+
+// QueryStatus returns the state of the hostship unit and up to lines of its
+// most recent journal entries.
+func QueryStatus(userUnit bool, lines int) (*ServiceStatus, error) {
+	args := []string{"systemctl"}
+	if userUnit {
+		args = append(args, "--user")
+	}
+	args = append(args, "show", unitName, "--property="+strings.Join(statusProperties, ","))
+	// Unix timestamps do not depend on the time zone systemctl prints in.
+	// systemd before 248 lacks the option and only loses the start time.
+	out, err := output(append(args, "--timestamp=unix"))
+	if err != nil {
+		if out, err = output(args); err != nil {
+			return nil, err
+		}
+	}
+	props := make(map[string]string)
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		if k, v, ok := strings.Cut(sc.Text(), "="); ok {
+			props[k] = v
+		}
+	}
+	s := &ServiceStatus{
+		Unit:        unitName,
+		Installed:   props["LoadState"] != "" && props["LoadState"] != "not-found",
+		ActiveState: props["ActiveState"],
+		SubState:    props["SubState"],
+		StatusText:  props["StatusText"],
+	}
+	s.MainPID, _ = strconv.Atoi(props["MainPID"])
+	s.Restarts, _ = strconv.Atoi(props["NRestarts"])
+	// An unset memory accounting reads "[not set]" or the maximum uint64.
+	if mem, err := strconv.ParseUint(props["MemoryCurrent"], 10, 64); err == nil && mem != ^uint64(0) {
+		s.MemoryBytes = mem
+	}
+	if t, ok := parseTimestamp(props["ExecMainStartTimestamp"]); ok && s.Active() {
+		s.StartedAt = &t
 	}
-	if verbose {
-		fmt.Println(strings.Join(args, " "))
+	if s.Installed && lines > 0 {
+		if s.Journal, err = journal(userUnit, lines); err != nil {
+			s.JournalError = err.Error()
+		}
 	}
+	return s, nil
+}
+
+// parseTimestamp parses a timestamp as printed by `systemctl show
+// --timestamp=unix`, e.g. "@1760868000".
+func parseTimestamp(s string) (time.Time, bool) {
+	sec, err := strconv.ParseInt(strings.TrimPrefix(s, "@"), 10, 64)
+	if !strings.HasPrefix(s, "@") || err != nil || sec <= 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(sec, 0), true
+}
+
+// geteuid is os.Geteuid, replaced in tests.
+var geteuid = os.Geteuid
+
+// journal returns the last lines entries of the unit's journal. Outside
+// the systemd-journal and adm groups journalctl shows a system unit's
+// entries to root only, so the read is retried with sudo, without
+// prompting for a password.
+func journal(userUnit bool, lines int) ([]JournalLine, error) {
+	args := []string{"journalctl", "--unit", unitName}
+	if userUnit {
+		args = []string{"journalctl", "--user-unit", unitName}
+	}
+	args = append(args, "--lines", strconv.Itoa(lines), "--no-pager", "--output", "json")
+	entries, err := readJournal(args)
+	if userUnit || geteuid() == 0 || (err == nil && len(entries) > 0) {
+		return entries, err
+	}
+	slog.Debug("retrying journal read with sudo", "err", err)
+	entries, sudoErr := readJournal(append([]string{"sudo", "-n"}, args...))
+	if sudoErr != nil {
+		if err == nil {
+			err = errors.New("no entries readable without root")
+		}
+		return nil, fmt.Errorf("%w (sudo: %v); add the user to the systemd-journal group", err, sudoErr)
+	}
+	return entries, nil
+}
+
+// readJournal runs journalctl with args and decodes its JSON output.
+func readJournal(args []string) ([]JournalLine, error) {
+	out, err := output(args)
+	if err != nil {
+		return nil, err
+	}
+	var entries []JournalLine
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	sc.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for sc.Scan() {
+		var e struct {
+			Realtime string          `json:"__REALTIME_TIMESTAMP"`
+			Priority string          `json:"PRIORITY"`
+			Message  json.RawMessage `json:"MESSAGE"`
+		}
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			continue
+		}
+		usec, _ := strconv.ParseInt(e.Realtime, 10, 64)
+		pri, err := strconv.Atoi(e.Priority)
+		if err != nil {
+			pri = 6
+		}
+		entries = append(entries, JournalLine{Time: time.UnixMicro(usec), Priority: pri, Message: journalMessage(e.Message)})
+	}
+	return entries, sc.Err()
+}
+
+// journalMessage decodes MESSAGE, which journalctl prints as an array of
+// bytes when it is not valid UTF-8.
+func journalMessage(raw json.RawMessage) string {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	var b []int
+	if json.Unmarshal(raw, &b) == nil {
+		buf := make([]byte, len(b))
+		for i, c := range b {
+			buf[i] = byte(c)
+		}
+		return strings.ToValidUTF8(string(buf), "?")
+	}
+	return ""
+}
+
+func output(args []string) ([]byte, error) {
+	slog.Debug("running command", "cmd", strings.Join(args, " "))
+	var stderr bytes.Buffer
 	cmd := exec.Command(args[0], args[1:]...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// Status prints the status of the hostship service to w as text or JSON,
+// followed by the last lines of its journal. It returns an error after
+// printing when the service is not active, so scripts can check the exit
+// code.
+func Status(w io.Writer, userUnit bool, lines int, format string) error {
+	if format != OutputText && format != OutputJSON {
+		return fmt.Errorf("invalid output %q: use text or json", format)
+	}
+	s, err := QueryStatus(userUnit, lines)
+	if err != nil {
+		return err
+	}
+	if format == OutputJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(s); err != nil {
+			return err
+		}
+	} else {
+		s.Print(w)
+	}
+	if !s.Installed {
+		return fmt.Errorf("%s is not installed", s.Unit)
+	}
+	if !s.Active() {
+		return fmt.Errorf("%s is %s", s.Unit, s.ActiveState)
+	}
+	return nil
+}
+
+// Print writes a summary of s.
+func (s *ServiceStatus) Print(w io.Writer) {
+	if !s.Installed {
+		fmt.Fprintf(w, "%s: not installed\n", s.Unit)
+		return
+	}
+	state := fmt.Sprintf("%s (%s)", s.ActiveState, s.SubState)
+	if s.StartedAt != nil {
+		state += fmt.Sprintf(" since %s (%s ago)", s.StartedAt.Local().Format(time.DateTime), time.Since(*s.StartedAt).Round(time.Second))
+	}
+	fmt.Fprintf(w, "%s: %s\n", s.Unit, state)
+	if s.StatusText != "" {
+		fmt.Fprintf(w, "  Status:   %s\n", s.StatusText)
+	}
+	if s.MainPID != 0 {
+		fmt.Fprintf(w, "  Main PID: %d\n", s.MainPID)
+	}
+	fmt.Fprintf(w, "  Restarts: %d\n", s.Restarts)
+	if s.MemoryBytes != 0 {
+		fmt.Fprintf(w, "  Memory:   %s\n", formatBytes(s.MemoryBytes))
+	}
+	if s.JournalError != "" {
+		fmt.Fprintf(w, "\nRecent logs unavailable: %s\n", s.JournalError)
+	}
+	if len(s.Journal) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "\nRecent logs:")
+	for _, l := range s.Journal {
+		fmt.Fprintf(w, "  %s %s\n", l.Time.Local().Format(time.DateTime), l.Message)
+	}
+}
+
+// formatBytes formats n with a binary unit, e.g. 23.4M.
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%c", float64(n)/float64(div), "KMGTPE"[exp])
 }
@@ -0,0 +1,106 @@
+package systemd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Time
+		ok   bool
+	}{
+		{"@1760868000", time.Date(2025, 10, 19, 10, 0, 0, 0, time.UTC), true},
+		{"", time.Time{}, false},
+		{"n/a", time.Time{}, false},
+		{"@0", time.Time{}, false},
+		{"1760868000", time.Time{}, false},
+		{"Sun 2025-10-19 12:00:00 CEST", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseTimestamp(tt.in)
+		if ok != tt.ok || !got.Equal(tt.want) {
+			t.Errorf("parseTimestamp(%q) = %v, %v; want %v, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+// fakeCommands puts scripts named after the keys of scripts first on PATH.
+func fakeCommands(t *testing.T, scripts map[string]string) {
+	t.Helper()
+	dir := t.TempDir()
+	for name, body := range scripts {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+body), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+const journalEntry = `{"__REALTIME_TIMESTAMP":"1760868000000000","PRIORITY":"3","MESSAGE":"deploy failed"}`
+
+func TestQueryStatus(t *testing.T) {
+	fakeCommands(t, map[string]string{
+		"systemctl": `case "$*" in *--timestamp=unix*) ts=@1760868000 ;; *) exit 1 ;; esac
+printf 'LoadState=loaded\nActiveState=active\nSubState=running\nMainPID=42\nExecMainStartTimestamp=%s\nNRestarts=2\nMemoryCurrent=[not set]\nStatusText=idle\n' "$ts"
+`,
+		// journalctl shows the entries to root only, like a user outside
+		// the systemd-journal group sees them.
+		"journalctl": `[ -n "$FAKE_ROOT" ] && echo '` + journalEntry + `'
+exit 0
+`,
+		"sudo": `[ "$1" = -n ] || exit 1
+shift
+[ -n "$SUDO_DENIED" ] && { echo "sudo: a password is required" >&2; exit 1; }
+FAKE_ROOT=1 exec "$@"
+`,
+	})
+	euid := 1000
+	geteuid = func() int { return euid }
+	t.Cleanup(func() { geteuid = os.Geteuid })
+
+	tests := []struct {
+		name    string
+		euid    int
+		user    bool
+		denied  bool
+		entries int
+		err     string
+	}{
+		{"root reads the journal itself", 0, false, false, 0, ""},
+		{"sudo fallback", 1000, false, false, 1, ""},
+		{"sudo denied", 1000, false, true, 0, "add the user to the systemd-journal group"},
+		{"user unit without sudo", 1000, true, false, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			euid = tt.euid
+			if tt.denied {
+				t.Setenv("SUDO_DENIED", "1")
+			}
+			s, err := QueryStatus(tt.user, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !s.Installed || !s.Active() || s.MainPID != 42 || s.Restarts != 2 || s.MemoryBytes != 0 || s.StatusText != "idle" {
+				t.Errorf("status = %+v", s)
+			}
+			if s.StartedAt == nil || s.StartedAt.Unix() != 1760868000 {
+				t.Errorf("StartedAt = %v", s.StartedAt)
+			}
+			if len(s.Journal) != tt.entries {
+				t.Errorf("journal = %+v, want %d entries", s.Journal, tt.entries)
+			}
+			if tt.entries > 0 && (s.Journal[0].Priority != 3 || s.Journal[0].Message != "deploy failed") {
+				t.Errorf("journal entry = %+v", s.Journal[0])
+			}
+			if (tt.err == "") != (s.JournalError == "") || !strings.Contains(s.JournalError, tt.err) {
+				t.Errorf("JournalError = %q, want %q", s.JournalError, tt.err)
+			}
+		})
+	}
+}
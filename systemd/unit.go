@@ -0,0 +1,306 @@
+package systemd
+
+import (
+	"bufio"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Names of the hostship unit and of the units running the self-updater.
+const (
+	unitName          = "hostship.service"
+	updateServiceName = "hostship-update.service"
+	updateTimerName   = "hostship-update.timer"
+)
+
+// systemUnitDir is where system units are installed.
+const systemUnitDir = "/etc/systemd/system"
+
+// UnitDir returns the directory units are installed in: /etc/systemd/system,
+// or ~/.config/systemd/user for units of the user's own service manager.
+func UnitDir(user bool) (string, error) {
+	if !user {
+		return systemUnitDir, nil
+	}
+	config, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(config, "systemd", "user"), nil
+}
+
+// UnitPath returns the path of the hostship unit.
+func UnitPath(user bool) (string, error) {
+	dir, err := UnitDir(user)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, unitName), nil
+}
+
+// optionsPrefix starts the comment line of a rendered unit that records the
+// options it was rendered with.
+const optionsPrefix = "# hostship-options: "
+
+//go:embed hostship.service.tmpl
+var unitSource string
+
+//go:embed hostship-update.service.tmpl
+var updateServiceSource string
+
+//go:embed hostship-update.timer.tmpl
+var updateTimerSource string
+
+var (
+	unitTemplate          = template.Must(template.New("hostship.service").Funcs(template.FuncMap{"quote": quote}).Parse(unitSource))
+	updateServiceTemplate = template.Must(template.New("hostship-update.service").Funcs(template.FuncMap{"quote": quote}).Parse(updateServiceSource))
+	updateTimerTemplate   = template.Must(template.New("hostship-update.timer").Parse(updateTimerSource))
+)
+
+// defaultUpdateDelay spreads updates of hosts without a maintenance window.
+const defaultUpdateDelay = time.Hour
+
+// restartPolicies are the values systemd accepts for Restart=.
+var restartPolicies = []string{"no", "always", "on-success", "on-failure", "on-abnormal", "on-abort", "on-watchdog"}
+
+// envName matches the environment variable names accepted by --env.
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// UnitOptions configure the rendered hostship unit.
+type UnitOptions struct {
+	// Binary is the hostship executable started by the unit.
+	Binary string `json:"-"`
+	// User and Group run the listener as an unprivileged account, which
+	// must be allowed to use the docker socket.
+	User  string `json:"user,omitempty"`
+	Group string `json:"group,omitempty"`
+	// StateDir is the working directory holding compose.json, .env and the
+	// listener's state.
+	StateDir string `json:"state_dir,omitempty"`
+	// ListenAddr overrides the address of the update endpoint.
+	ListenAddr string `json:"listen_addr,omitempty"`
+	// EnvironmentFile is loaded by systemd before the listener starts.
+	EnvironmentFile string            `json:"environment_file,omitempty"`
+	Environment     map[string]string `json:"environment,omitempty"`
+	Restart         string            `json:"restart,omitempty"`
+	// Harden enables systemd sandboxing: a read-only file system except for
+	// StateDir, no privilege escalation and only unix and IP sockets.
+	Harden bool `json:"harden,omitempty"`
+	// AutoUpdate installs a timer running `hostship update`: hourly, daily,
+	// weekly or a systemd calendar expression. Empty disables it.
+	AutoUpdate string `json:"auto_update,omitempty"`
+	// UpdateWindow restricts automatic updates to a maintenance window, see
+	// ParseWindow.
+	UpdateWindow string `json:"update_window,omitempty"`
+	// UserMode installs the units for the user's own service manager,
+	// talking to rootless Docker. It follows from where a unit is
+	// installed, so it is not recorded.
+	UserMode bool `json:"-"`
+}
+
+// unitFile is a rendered unit and where it is installed.
+type unitFile struct {
+	path    string
+	content string
+}
+
+// DefaultUnitOptions returns the options of a plain install.
+func DefaultUnitOptions() UnitOptions {
+	return UnitOptions{StateDir: "/root", Restart: "on-failure"}
+}
+
+func (o UnitOptions) validate() error {
+	if o.Binary == "" {
+		return fmt.Errorf("missing binary path")
+	}
+	if !strings.HasPrefix(o.StateDir, "/") {
+		return fmt.Errorf("state dir must be an absolute path: %q", o.StateDir)
+	}
+	if o.EnvironmentFile != "" && !strings.HasPrefix(o.EnvironmentFile, "/") {
+		return fmt.Errorf("environment file must be an absolute path: %q", o.EnvironmentFile)
+	}
+	valid := false
+	for _, p := range restartPolicies {
+		valid = valid || o.Restart == p
+	}
+	if !valid {
+		return fmt.Errorf("invalid restart policy %q: use one of %s", o.Restart, strings.Join(restartPolicies, ", "))
+	}
+	if o.UserMode && (o.User != "" || o.Group != "") {
+		return fmt.Errorf("user units run as the installing user; drop --run-as-user and --run-as-group")
+	}
+	if o.UpdateWindow != "" {
+		if o.AutoUpdate == "" {
+			return fmt.Errorf("a maintenance window needs --auto-update")
+		}
+		if _, err := o.updateSchedule(); err != nil {
+			return err
+		}
+	}
+	values := []string{o.Binary, o.User, o.Group, o.StateDir, o.ListenAddr, o.EnvironmentFile, o.AutoUpdate, o.UpdateWindow}
+	for k, v := range o.Environment {
+		if !envName.MatchString(k) {
+			return fmt.Errorf("invalid environment variable name %q", k)
+		}
+		values = append(values, v)
+	}
+	for _, v := range values {
+		if strings.ContainsAny(v, "\n\r") {
+			return fmt.Errorf("unit values cannot contain newlines: %q", v)
+		}
+	}
+	// Path settings are not unquoted by systemd.
+	if strings.ContainsAny(o.User+o.Group+o.StateDir+o.EnvironmentFile, " \t%") {
+		return fmt.Errorf("user, group, state dir and environment file cannot contain spaces or %%")
+	}
+	return nil
+}
+
+// RenderUnit renders the hostship unit for opts.
+func RenderUnit(opts UnitOptions) (string, error) {
+	if err := opts.validate(); err != nil {
+		return "", err
+	}
+	recorded, err := json.Marshal(opts)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	err = unitTemplate.Execute(&b, struct {
+		UnitOptions
+		Options string
+	}{opts, string(recorded)})
+	return b.String(), err
+}
+
+// updateTimer holds the values of the rendered update timer.
+type updateTimer struct {
+	UnitOptions
+	OnCalendar string
+	Delay      string
+}
+
+// updateSchedule returns when the update timer fires. Without a maintenance
+// window updates are spread over an hour after the calendar event; with one
+// they start at its beginning and are spread over its length.
+func (o UnitOptions) updateSchedule() (updateTimer, error) {
+	t := updateTimer{UnitOptions: o, OnCalendar: o.AutoUpdate, Delay: timeSpan(defaultUpdateDelay)}
+	if o.UpdateWindow == "" {
+		return t, nil
+	}
+	w, err := ParseWindow(o.UpdateWindow)
+	if err != nil {
+		return t, err
+	}
+	if t.OnCalendar, err = w.OnCalendar(o.AutoUpdate); err != nil {
+		return t, err
+	}
+	t.Delay = timeSpan(w.Length())
+	return t, nil
+}
+
+// timeSpan formats d as a systemd time span such as "1h" or "7h30min".
+func timeSpan(d time.Duration) string {
+	h, m := int(d.Hours()), int(d.Minutes())%60
+	switch {
+	case m == 0:
+		return fmt.Sprintf("%dh", h)
+	case h == 0:
+		return fmt.Sprintf("%dmin", m)
+	default:
+		return fmt.Sprintf("%dh%dmin", h, m)
+	}
+}
+
+// renderUnits renders the hostship unit and, with AutoUpdate, the update
+// service and timer.
+func renderUnits(opts UnitOptions) ([]unitFile, error) {
+	unit, err := RenderUnit(opts)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := UnitDir(opts.UserMode)
+	if err != nil {
+		return nil, err
+	}
+	files := []unitFile{{filepath.Join(dir, unitName), unit}}
+	if opts.AutoUpdate == "" {
+		return files, nil
+	}
+	t, err := opts.updateSchedule()
+	if err != nil {
+		return nil, err
+	}
+	var service, timer strings.Builder
+	if err := updateServiceTemplate.Execute(&service, opts); err != nil {
+		return nil, err
+	}
+	if err := updateTimerTemplate.Execute(&timer, t); err != nil {
+		return nil, err
+	}
+	return append(files,
+		unitFile{filepath.Join(dir, updateServiceName), service.String()},
+		unitFile{filepath.Join(dir, updateTimerName), timer.String()},
+	), nil
+}
+
+// InstalledUnit returns the content of the installed unit, or "" when there
+// is none.
+func InstalledUnit(user bool) (string, error) {
+	path, err := UnitPath(user)
+	if err != nil {
+		return "", err
+	}
+	return installedFile(path)
+}
+
+func installedFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	return string(data), err
+}
+
+// InstalledOptions returns the options the installed unit was rendered
+// with, so a reinstall keeps them. It reports false when no unit rendered
+// by hostship is installed.
+func InstalledOptions(user bool) (UnitOptions, bool, error) {
+	unit, err := InstalledUnit(user)
+	if err != nil || unit == "" {
+		return UnitOptions{}, false, err
+	}
+	sc := bufio.NewScanner(strings.NewReader(unit))
+	for sc.Scan() {
+		line := sc.Text()
+		if !strings.HasPrefix(line, optionsPrefix) {
+			continue
+		}
+		opts := DefaultUnitOptions()
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, optionsPrefix)), &opts); err != nil {
+			return UnitOptions{}, false, fmt.Errorf("installed unit options: %w", err)
+		}
+		opts.UserMode = user
+		return opts, true, nil
+	}
+	return UnitOptions{}, false, nil
+}
+
+// quote quotes a unit value containing spaces, quotes or backslashes.
+// Percent signs are escaped since systemd expands specifiers in them.
+func quote(s string) string {
+	s = strings.ReplaceAll(s, "%", "%%")
+	if !strings.ContainsAny(s, " \t\"'\\") {
+		return s
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	return `"` + r.Replace(s) + `"`
+}
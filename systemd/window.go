@@ -0,0 +1,157 @@
+package systemd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// weekdays are the day names systemd calendar expressions use.
+var weekdays = []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}
+
+// Window is a recurring maintenance window such as "02:00-05:00" or
+// "Sat,Sun 00:00-23:59". A window whose end is before its start ends on the
+// next day.
+type Window struct {
+	days  [7]bool // indexed by time.Weekday; all false means every day
+	first string  // day the window starts on first as written, e.g. "Sat" for "Sat..Mon"
+	start time.Duration
+	end   time.Duration
+	spec  string
+}
+
+// ParseWindow parses "[DAYS ]HH:MM-HH:MM" where DAYS is a comma separated
+// list of day names or ranges, e.g. "Mon..Fri" or "Sat,Sun".
+func ParseWindow(s string) (Window, error) {
+	w := Window{spec: s}
+	fields := strings.Fields(s)
+	if len(fields) == 0 || len(fields) > 2 {
+		return Window{}, fmt.Errorf("invalid maintenance window %q: use [DAYS ]HH:MM-HH:MM", s)
+	}
+	if len(fields) == 2 {
+		if err := w.parseDays(fields[0]); err != nil {
+			return Window{}, fmt.Errorf("invalid maintenance window %q: %w", s, err)
+		}
+	}
+	from, to, ok := strings.Cut(fields[len(fields)-1], "-")
+	if !ok {
+		return Window{}, fmt.Errorf("invalid maintenance window %q: use [DAYS ]HH:MM-HH:MM", s)
+	}
+	var err error
+	if w.start, err = parseClock(from); err != nil {
+		return Window{}, fmt.Errorf("invalid maintenance window %q: %w", s, err)
+	}
+	if w.end, err = parseClock(to); err != nil {
+		return Window{}, fmt.Errorf("invalid maintenance window %q: %w", s, err)
+	}
+	if w.start == w.end {
+		return Window{}, fmt.Errorf("invalid maintenance window %q: empty", s)
+	}
+	return w, nil
+}
+
+func (w *Window) parseDays(s string) error {
+	for _, part := range strings.Split(s, ",") {
+		from, to, isRange := strings.Cut(part, "..")
+		first, err := weekday(from)
+		if err != nil {
+			return err
+		}
+		if w.first == "" {
+			w.first = weekdays[first]
+		}
+		last := first
+		if isRange {
+			if last, err = weekday(to); err != nil {
+				return err
+			}
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			w.days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func weekday(s string) (int, error) {
+	for i, d := range weekdays {
+		if strings.EqualFold(s, d) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown day %q", s)
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// String returns the window as it was given.
+func (w Window) String() string { return w.spec }
+
+// Length returns how long the window lasts.
+func (w Window) Length() time.Duration {
+	if w.end > w.start {
+		return w.end - w.start
+	}
+	return 24*time.Hour - w.start + w.end
+}
+
+// Contains reports whether t, in its own location, falls inside the window.
+// Windows crossing midnight belong to the day they start on.
+func (w Window) Contains(t time.Time) bool {
+	clock := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	day := t.Weekday()
+	if w.end > w.start {
+		return clock >= w.start && clock < w.end && w.onDay(day)
+	}
+	if clock >= w.start {
+		return w.onDay(day)
+	}
+	return clock < w.end && w.onDay((day+6)%7)
+}
+
+func (w Window) onDay(d time.Weekday) bool {
+	return w.days == [7]bool{} || w.days[d]
+}
+
+// dayList returns the days of the window in calendar syntax, or "" for
+// every day.
+func (w Window) dayList() string {
+	var days []string
+	for i, on := range w.days {
+		if on {
+			days = append(days, weekdays[i])
+		}
+	}
+	return strings.Join(days, ",")
+}
+
+// OnCalendar returns the calendar expression starting the window, daily or
+// only on its first day as written for weekly, so "Sat..Mon" updates on
+// Saturdays.
+func (w Window) OnCalendar(frequency string) (string, error) {
+	clock := fmt.Sprintf("*-*-* %02d:%02d:00", int(w.start.Hours()), int(w.start.Minutes())%60)
+	days := w.dayList()
+	switch frequency {
+	case "daily":
+	case "weekly":
+		days = w.first
+		if days == "" {
+			days = "Mon"
+		}
+	default:
+		return "", fmt.Errorf("a maintenance window needs daily or weekly updates, not %q", frequency)
+	}
+	if days == "" {
+		return clock, nil
+	}
+	return days + " " + clock, nil
+}
@@ -0,0 +1,143 @@
+package systemd
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseWindow(t *testing.T) {
+	tests := []struct {
+		spec   string
+		length time.Duration
+		err    string
+	}{
+		{"02:00-05:00", 3 * time.Hour, ""},
+		{"23:30-01:00", 90 * time.Minute, ""},
+		{"Sat,Sun 00:00-23:59", 23*time.Hour + 59*time.Minute, ""},
+		{"mon..fri 22:00-02:00", 4 * time.Hour, ""},
+		{"Sat..Mon 01:00-02:00", time.Hour, ""},
+		{"", 0, "use [DAYS ]HH:MM-HH:MM"},
+		{"02:00", 0, "use [DAYS ]HH:MM-HH:MM"},
+		{"Sat Sun 02:00-03:00", 0, "use [DAYS ]HH:MM-HH:MM"},
+		{"Someday 02:00-03:00", 0, `unknown day "Someday"`},
+		{"Mon..Xyz 02:00-03:00", 0, `unknown day "Xyz"`},
+		{"02:60-03:00", 0, `invalid time "02:60"`},
+		{"02:00-25:00", 0, `invalid time "25:00"`},
+		{"04:00-04:00", 0, "empty"},
+	}
+	for _, tt := range tests {
+		w, err := ParseWindow(tt.spec)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("ParseWindow(%q) error = %v, want %q", tt.spec, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseWindow(%q): %v", tt.spec, err)
+			continue
+		}
+		if w.Length() != tt.length || w.String() != tt.spec {
+			t.Errorf("ParseWindow(%q) = %s lasting %v, want %v", tt.spec, w, w.Length(), tt.length)
+		}
+	}
+}
+
+func TestWindowContains(t *testing.T) {
+	// 2026-10-17 is a Saturday.
+	at := func(day int, clock string) time.Time {
+		ts, err := time.Parse("2006-01-02 15:04", fmt.Sprintf("2026-10-%02d %s", day, clock))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+	const (
+		fri = 16
+		sat = 17
+		sun = 18
+		mon = 19
+		tue = 20
+		wed = 21
+	)
+	tests := []struct {
+		spec string
+		at   time.Time
+		want bool
+	}{
+		{"02:00-05:00", at(wed, "02:00"), true},
+		{"02:00-05:00", at(wed, "04:59"), true},
+		{"02:00-05:00", at(wed, "05:00"), false},
+		{"02:00-05:00", at(wed, "01:59"), false},
+
+		// Crossing midnight: the early hours belong to the previous day.
+		{"23:00-01:00", at(wed, "23:30"), true},
+		{"23:00-01:00", at(wed, "00:30"), true},
+		{"23:00-01:00", at(wed, "01:00"), false},
+		{"Fri 23:00-01:00", at(fri, "23:30"), true},
+		{"Fri 23:00-01:00", at(sat, "00:30"), true},
+		{"Fri 23:00-01:00", at(fri, "00:30"), false},
+		{"Fri 23:00-01:00", at(sat, "23:30"), false},
+
+		// A range wrapping past Saturday.
+		{"Sat..Mon 01:00-02:00", at(sat, "01:30"), true},
+		{"Sat..Mon 01:00-02:00", at(sun, "01:30"), true},
+		{"Sat..Mon 01:00-02:00", at(mon, "01:30"), true},
+		{"Sat..Mon 01:00-02:00", at(tue, "01:30"), false},
+		{"Sat..Mon 01:00-02:00", at(fri, "01:30"), false},
+		{"Sat..Mon 22:00-02:00", at(tue, "01:30"), true},
+		{"Sat..Mon 22:00-02:00", at(sat, "01:30"), false},
+
+		{"Sat,Sun 00:00-23:59", at(sun, "12:00"), true},
+		{"Sat,Sun 00:00-23:59", at(mon, "12:00"), false},
+	}
+	for _, tt := range tests {
+		w, err := ParseWindow(tt.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := w.Contains(tt.at); got != tt.want {
+			t.Errorf("%q.Contains(%s) = %v, want %v", tt.spec, tt.at.Format("Mon 15:04"), got, tt.want)
+		}
+	}
+
+	// Contains uses the location of the time it is given.
+	w, _ := ParseWindow("02:00-05:00")
+	berlin := time.FixedZone("CEST", 2*60*60)
+	if !w.Contains(at(wed, "01:00").In(berlin)) || w.Contains(at(wed, "03:00").In(berlin)) {
+		t.Error("Contains ignores the time's location")
+	}
+}
+
+func TestWindowOnCalendar(t *testing.T) {
+	tests := []struct {
+		spec, frequency string
+		want            string
+	}{
+		{"02:00-05:00", "daily", "*-*-* 02:00:00"},
+		{"02:00-05:00", "weekly", "Mon *-*-* 02:00:00"},
+		{"23:30-01:00", "daily", "*-*-* 23:30:00"},
+		{"Sat,Sun 03:15-05:00", "daily", "Sun,Sat *-*-* 03:15:00"},
+		{"Sat,Sun 03:15-05:00", "weekly", "Sat *-*-* 03:15:00"},
+		{"Tue,Thu,Sat 04:00-05:00", "weekly", "Tue *-*-* 04:00:00"},
+		{"Mon..Fri 22:00-02:00", "weekly", "Mon *-*-* 22:00:00"},
+		{"Sat..Mon 01:00-02:00", "daily", "Sun,Mon,Sat *-*-* 01:00:00"},
+		{"Sat..Mon 01:00-02:00", "weekly", "Sat *-*-* 01:00:00"},
+	}
+	for _, tt := range tests {
+		w, err := ParseWindow(tt.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := w.OnCalendar(tt.frequency)
+		if err != nil || got != tt.want {
+			t.Errorf("%q.OnCalendar(%s) = %q, %v; want %q", tt.spec, tt.frequency, got, err, tt.want)
+		}
+	}
+	w, _ := ParseWindow("02:00-05:00")
+	if _, err := w.OnCalendar("hourly"); err == nil {
+		t.Error("expected an error for hourly updates")
+	}
+}